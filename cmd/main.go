@@ -2,20 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
 	"newservice/internal/config"
+	"newservice/internal/denylist"
+	"newservice/internal/httpapi"
 	"newservice/internal/repo"
 	"newservice/internal/service"
+	"newservice/pkg/clientinfo"
 	"newservice/pkg/jwt"
 	"newservice/pkg/logger"
+	"newservice/pkg/mailer"
+	"newservice/pkg/ratelimit"
+	"newservice/pkg/secure"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	"google.golang.org/grpc"
@@ -50,24 +62,170 @@ func main() {
 		l.Fatalf("failed to initialize repository: %v", err)
 	}
 
-	// чтение ключей для JWT
-	privateKey, err := jwt.ReadPrivateKey()
-	if err != nil {
-		log.Fatal("failed to read private key")
+	// загрузка ключей для JWT: старые ключи остаются ключами проверки, пока живут выданные ими access токены
+	// (refresh токены непрозрачные и от ключей подписи не зависят)
+	var keySource jwt.KeySource
+	switch cfg.System.KeysSource {
+	case "file":
+		keySource = jwt.NewFileKeySource("private.pem")
+	case "dir":
+		keySource = jwt.NewDirKeySource(cfg.System.KeysDir)
+	case "db":
+		keySource = jwt.KeySourceFunc(repository.GetSigningKeys)
+	default:
+		l.Fatalf("unknown keys source: %s", cfg.System.KeysSource)
 	}
-	publicKey, err := jwt.ReadPublicKey()
+
+	keySet, err := jwt.NewKeySet(ctx, keySource, cfg.System.AccessTokenTimeout)
 	if err != nil {
-		log.Fatal("failed to read public key")
+		l.Fatalf("failed to load signing keys: %v", err)
+	}
+
+	// перечитываем ключи по таймеру и по SIGHUP, не перезапуская сервер.
+	// Нулевой интервал выключает таймер: ключи перечитываются только по SIGHUP
+	reloadKeys := make(chan os.Signal, 1)
+	signal.Notify(reloadKeys, syscall.SIGHUP)
+	go keySet.Watch(ctx, cfg.System.KeysReloadInterval, reloadKeys, func(err error) {
+		l.Errorf("failed to reload signing keys: %v", err)
+	})
+
+	// получатели токенов для каждого клиента
+	audiences := jwt.Audiences{
+		Default:  cfg.System.Audience,
+		ByClient: make(map[string][]string, len(cfg.System.ClientAudiences)),
+	}
+	for clientID, aud := range cfg.System.ClientAudiences {
+		audiences.ByClient[clientID] = strings.Fields(aud)
 	}
 
 	// создание JWT-клиента
-	jwtClient := jwt.NewJWTClient(privateKey, publicKey, cfg.System.AccessTokenTimeout, cfg.System.RefreshTokenTimeout)
+	jwtClient := jwt.NewJWTClient(
+		keySet,
+		cfg.System.AccessTokenTimeout,
+		cfg.System.Issuer,
+		audiences,
+		cfg.System.ClockSkew,
+	)
+
+	// кэш отозванных access токенов
+	tokenDenylist := denylist.New(repository)
+	if err := tokenDenylist.Sync(ctx); err != nil {
+		l.Fatalf("failed to load token denylist: %v", err)
+	}
+	go tokenDenylist.Run(ctx, cfg.System.DenylistSyncInterval, l)
+
+	// шифр секретов второго фактора, без ключа MFA выключена
+	var mfaCipher *secure.Cipher
+	if cfg.System.MfaEncryptionKey != "" {
+		mfaCipher, err = secure.NewCipher(cfg.System.MfaEncryptionKey)
+		if err != nil {
+			l.Fatalf("failed to initialize mfa cipher: %v", err)
+		}
+	} else {
+		l.Warn("MFA_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+	}
+
+	// проверяющая сторона WebAuthn (passkeys)
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.System.WebauthnRPID,
+		RPDisplayName: cfg.System.WebauthnRPName,
+		RPOrigins:     cfg.System.WebauthnRPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.System.WebauthnTimeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.System.WebauthnTimeout},
+		},
+	})
+	if err != nil {
+		l.Fatalf("failed to initialize webauthn: %v", err)
+	}
+
+	// доставка писем
+	var mail mailer.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mail = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+	case "file":
+		mail, err = mailer.NewFileMailer(cfg.Mail.Dir)
+		if err != nil {
+			l.Fatalf("failed to initialize mailer: %v", err)
+		}
+	case "memory":
+		mail = mailer.NewMemoryMailer()
+	default:
+		l.Fatalf("unknown mail driver: %s", cfg.Mail.Driver)
+	}
+
+	// запрос не ждёт SMTP: медленный или недоступный почтовый сервер не задерживает ответ
+	mailQueue := mailer.NewQueue(mail, cfg.Mail.QueueSize, l)
+
+	// журнал аудита: без ключа цепочку может пересчитать администратор БД
+	var auditKey []byte
+	if cfg.System.AuditHMACKey != "" {
+		auditKey, err = base64.StdEncoding.DecodeString(cfg.System.AuditHMACKey)
+		if err != nil || len(auditKey) != 32 {
+			l.Fatal("AUDIT_HMAC_KEY must be 32 bytes in base64")
+		}
+	} else {
+		l.Warn("AUDIT_HMAC_KEY is not set, audit log chain uses plain SHA-256")
+	}
+	auditLog := audit.New(repository, auditKey, l)
 
 	// создание сервера аутентификации
-	authSrv := service.NewAuthServer(cfg, repository, jwtClient, l)
+	authSrv := service.NewAuthServer(cfg, repository, jwtClient, tokenDenylist, mfaCipher, webAuthn, mailQueue, auditLog, l)
+
+	// адрес клиента: x-forwarded-for принимается только от доверенных прокси
+	interceptors := []grpc.UnaryServerInterceptor{clientinfo.UnaryServerInterceptor(cfg.RateLimit.TrustedProxies)}
+	httpHandler := httpapi.NewHandler(authSrv, l)
+
+	// ограничение частоты запросов, у gRPC и HTTP общие корзины
+	if cfg.RateLimit.Enabled {
+		var limiter ratelimit.Limiter
+		switch cfg.RateLimit.Backend {
+		case "memory":
+			limiter = ratelimit.NewMemoryLimiter()
+		case "redis":
+			redisLimiter := ratelimit.NewRedisLimiter(ratelimit.RedisConfig{
+				Addr:     cfg.RateLimit.RedisAddr,
+				Password: cfg.RateLimit.RedisPassword,
+				DB:       cfg.RateLimit.RedisDB,
+				Prefix:   "ratelimit:",
+				Timeout:  cfg.RateLimit.RedisTimeout,
+			})
+			defer redisLimiter.Close()
+			limiter = redisLimiter
+		default:
+			l.Fatalf("unknown rate limit backend: %s", cfg.RateLimit.Backend)
+		}
+
+		rules := ratelimit.Rules{
+			Default: ratelimit.Rule{PerIP: cfg.RateLimit.DefaultPerIP},
+			ByMethod: map[string]ratelimit.Rule{
+				AuthService.AuthService_Login_FullMethodName: {
+					PerIP:   cfg.RateLimit.LoginPerIP,
+					PerUser: cfg.RateLimit.LoginPerUser,
+				},
+				AuthService.AuthService_OAuthAuthorize_FullMethodName: {
+					PerIP:   cfg.RateLimit.LoginPerIP,
+					PerUser: cfg.RateLimit.LoginPerUser,
+				},
+				AuthService.AuthService_Register_FullMethodName:   {PerIP: cfg.RateLimit.RegisterPerIP},
+				AuthService.AuthService_Refresh_FullMethodName:    {PerIP: cfg.RateLimit.RefreshPerIP},
+				AuthService.AuthService_OAuthToken_FullMethodName: {PerIP: cfg.RateLimit.RefreshPerIP},
+			},
+		}
+		interceptors = append(interceptors, ratelimit.UnaryServerInterceptor(limiter, rules, l))
+		httpHandler = ratelimit.HTTPMiddleware(limiter, rules, httpapi.Methods, l, httpHandler)
+	}
+	httpHandler = clientinfo.HTTPMiddleware(cfg.RateLimit.TrustedProxies, httpHandler)
 
 	// настройка и запуск gRPC-сервера:
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	AuthService.RegisterAuthServiceServer(grpcServer, authSrv)
 
 	lis, err := net.Listen("tcp", cfg.GRPC.ListenAddress)
@@ -83,6 +241,19 @@ func main() {
 		}
 	}()
 
+	// HTTP-сервер для стандартных эндпоинтов (JWKS, интроспекция, OAuth 2.0, OpenID Connect)
+	httpServer := &http.Server{
+		Addr:    cfg.HTTP.ListenAddress,
+		Handler: httpHandler,
+	}
+
+	go func() {
+		l.Infof("HTTP server started on %s", cfg.HTTP.ListenAddress)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Fatalf("failed to serve http: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -90,13 +261,20 @@ func main() {
 	l.Info("Shutting down gRPC server...")
 
 	// создаём контекст с таймаутом для graceful shutdown
-	_, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		l.Errorf("error shutting down http server: %v", err)
+	}
+
 	// вызов GracefulStop - аналог ShutdownWithContext
 	grpcServer.GracefulStop()
 	l.Info("gRPC server stopped gracefully")
 
+	mailQueue.Close()
+	auditLog.Close()
+
 	l.Info("Closing database connection gracefully...")
 	if err := repository.Close(); err != nil {
 		l.Fatalf("error shutting down database: %v", err)
@@ -0,0 +1,303 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Журнал аудита действий, важных для безопасности. Записи хранятся в таблице audit_events и связаны
+// в цепочку: hash записи - HMAC-SHA256 от hash предыдущей и полей самой записи, поэтому правка или удаление
+// записи задним числом ломает цепочку и обнаруживается Verify. Ключ HMAC хранится вне БД: без него
+// администратор БД не может пересчитать цепочку после правки. Без ключа используется простой SHA-256,
+// который защищает только от случайной порчи и правки в обход сервиса теми, кто не знает алгоритма.
+// Удаление последних записей цепочкой не обнаруживается.
+//
+// Записи добавляет один фоновый писатель пачками: запрос только ставит событие в очередь и не ждёт
+// блокировки цепочки, которую реплики берут по очереди
+
+// результат действия
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure" // неверные данные, недействительный токен и т.п.
+	OutcomeDenied  = "denied"  // действие запрещено: блокировка, нет прав
+)
+
+// действия, которые записываются в журнал помимо событий безопасности сервиса
+const (
+	ActionRegister            = "register"
+	ActionLogin               = "login"
+	ActionRefresh             = "refresh"
+	ActionRevokeSession       = "revoke_session"
+	ActionRevokeOtherSessions = "revoke_other_sessions"
+	ActionRevokeUserSessions  = "revoke_user_sessions"
+	ActionRevokeAccessToken   = "revoke_access_token"
+	ActionOAuthAuthorize      = "oauth_authorize"
+	ActionOAuthToken          = "oauth_token"
+	ActionIntrospect          = "introspect"
+	ActionCreateOAuthClient   = "create_oauth_client"
+	ActionDeleteOAuthClient   = "delete_oauth_client"
+	ActionRevokeOAuthConsent  = "revoke_oauth_consent"
+	ActionOAuthLogout         = "oauth_logout"
+	ActionNewJwt              = "new_jwt"
+)
+
+const (
+	verifyBatchSize = 1000             // сколько записей читается за раз при проверке цепочки
+	writeBatchSize  = 100              // сколько записей добавляется одной транзакцией
+	queueSize       = 10000            // сколько записей ждут писателя, сверх этого записи теряются
+	writeTimeout    = 10 * time.Second // на запись одной пачки
+
+	maxRequestIDLength = 100  // размер колонки request_id
+	maxUserAgentLength = 1000 // user_agent хранится в TEXT, но клиент может прислать сколько угодно
+)
+
+type Store interface {
+	AppendAuditEvents(ctx context.Context, events []*repo.AuditEvent, seal func(prevHash string, event *repo.AuditEvent) string) error
+	GetAuditEventsAfter(ctx context.Context, afterID int64, limit int) ([]repo.AuditEvent, error)
+}
+
+type Event struct {
+	ActorID uuid.UUID // uuid.Nil - клиент не известен, например, при входе с неверным паролем
+	Action  string
+	Target  string
+	Outcome string
+	Details map[string]string
+}
+
+type Log struct {
+	store Store
+	key   []byte // ключ HMAC, nil - SHA-256
+	log   *zap.SugaredLogger
+	queue chan queued
+	done  chan struct{}
+}
+
+// queued - событие для записи или, если flushed не nil, запрос дождаться записи всего, что было в очереди раньше
+type queued struct {
+	event   *repo.AuditEvent
+	flushed chan struct{}
+}
+
+// New запускает писателя журнала. key - ключ HMAC цепочки, nil - цепочка на SHA-256.
+// Ключ нельзя убрать или сменить после того, как им подписаны записи: Verify их уже не проверит
+func New(store Store, key []byte, log *zap.SugaredLogger) *Log {
+	l := &Log{
+		store: store,
+		key:   key,
+		log:   log,
+		queue: make(chan queued, queueSize),
+		done:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Record ставит событие в очередь, дополняя его адресом клиента, user agent и идентификатором запроса.
+// Ошибка записи только логируется: недоступность журнала не должна останавливать вход в систему
+func (l *Log) Record(ctx context.Context, e Event) {
+	client := clientinfo.FromContext(ctx)
+
+	event := &repo.AuditEvent{
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond), // точность timestamptz, иначе hash не сойдётся после чтения
+		Action:     e.Action,
+		Target:     e.Target,
+		IP:         client.IP,
+		UserAgent:  clip(client.UserAgent, maxUserAgentLength),
+		Outcome:    e.Outcome,
+		RequestID:  clip(client.RequestID, maxRequestIDLength),
+		Details:    e.Details,
+	}
+	if e.ActorID != uuid.Nil {
+		event.ActorID = &e.ActorID
+	}
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+
+	select {
+	case l.queue <- queued{event: event}:
+	default:
+		l.log.Errorw("audit queue is full, event dropped", "action", e.Action, "outcome", e.Outcome, "target", e.Target)
+	}
+}
+
+// clip обрезает значение, которое прислал клиент, до n символов. Событие с длинным или не UTF-8 значением
+// не записалось бы, а вместе с ним и вся пачка
+func clip(value string, n int) string {
+	value = strings.ToValidUTF8(value, string(utf8.RuneError))
+	if utf8.RuneCountInString(value) <= n {
+		return value
+	}
+	return string([]rune(value)[:n])
+}
+
+// Flush ждёт, пока будут записаны события, поставленные в очередь до вызова
+func (l *Log) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case l.queue <- queued{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close записывает оставшиеся в очереди события и останавливает писателя. После Close вызывать Record нельзя
+func (l *Log) Close() {
+	close(l.queue)
+	<-l.done
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+
+	for item := range l.queue {
+		batch := []queued{item}
+		// всё, что успело накопиться, уходит одной транзакцией
+	collect:
+		for len(batch) < writeBatchSize {
+			select {
+			case next, ok := <-l.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+		l.write(batch)
+	}
+}
+
+func (l *Log) write(batch []queued) {
+	events := make([]*repo.AuditEvent, 0, len(batch))
+	for _, item := range batch {
+		if item.event != nil {
+			events = append(events, item.event)
+		}
+	}
+
+	if len(events) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err := l.store.AppendAuditEvents(ctx, events, l.hash)
+		cancel()
+		if err != nil {
+			for _, e := range events {
+				l.log.Errorw("failed to write audit event", "action", e.Action, "outcome", e.Outcome, "target", e.Target, "error", err)
+			}
+		}
+	}
+
+	for _, item := range batch {
+		if item.flushed != nil {
+			close(item.flushed)
+		}
+	}
+}
+
+// Verify проверяет цепочку целиком. Возвращает id первой записи, на которой цепочка нарушена, или 0, если она цела.
+// Записи, сделанные до появления ключа, проверяются по SHA-256, но только до первой записи с HMAC:
+// иначе запись можно было бы подделать, выдав её за старую
+func (l *Log) Verify(ctx context.Context) (int64, error) {
+	var (
+		afterID  int64
+		prevHash string
+		keyed    bool
+	)
+	for {
+		events, err := l.store.GetAuditEventsAfter(ctx, afterID, verifyBatchSize)
+		if err != nil {
+			return 0, err
+		}
+
+		for i := range events {
+			e := &events[i]
+			if e.PrevHash != prevHash {
+				return e.ID, nil
+			}
+
+			switch {
+			case l.key != nil && hmac.Equal([]byte(Hash(l.key, prevHash, e)), []byte(e.Hash)):
+				keyed = true
+			case !keyed && Hash(nil, prevHash, e) == e.Hash:
+			default:
+				return e.ID, nil
+			}
+
+			prevHash = e.Hash
+			afterID = e.ID
+		}
+
+		if len(events) < verifyBatchSize {
+			return 0, nil
+		}
+	}
+}
+
+func (l *Log) hash(prevHash string, e *repo.AuditEvent) string {
+	return Hash(l.key, prevHash, e)
+}
+
+// sealedEvent - поля записи, которые входят в hash, в фиксированном порядке
+type sealedEvent struct {
+	PrevHash   string            `json:"prev_hash"`
+	OccurredAt string            `json:"occurred_at"`
+	ActorID    string            `json:"actor_id"`
+	Action     string            `json:"action"`
+	Target     string            `json:"target"`
+	IP         string            `json:"ip"`
+	UserAgent  string            `json:"user_agent"`
+	Outcome    string            `json:"outcome"`
+	RequestID  string            `json:"request_id"`
+	Details    map[string]string `json:"details"` // json сортирует ключи map
+}
+
+// Hash считает hash записи по hash предыдущей: HMAC-SHA256 с ключом key или SHA-256, если ключа нет
+func Hash(key []byte, prevHash string, e *repo.AuditEvent) string {
+	sealed := sealedEvent{
+		PrevHash:   prevHash,
+		OccurredAt: e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		Action:     e.Action,
+		Target:     e.Target,
+		IP:         e.IP,
+		UserAgent:  e.UserAgent,
+		Outcome:    e.Outcome,
+		RequestID:  e.RequestID,
+		Details:    e.Details,
+	}
+	if e.ActorID != nil {
+		sealed.ActorID = e.ActorID.String()
+	}
+	if sealed.Details == nil {
+		sealed.Details = map[string]string{}
+	}
+
+	data, _ := json.Marshal(sealed)
+	if key == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,317 @@
+package audit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// memoryStore - журнал в памяти, который ведёт себя как таблица audit_events
+type memoryStore struct {
+	mu      sync.Mutex
+	events  []repo.AuditEvent
+	batches int
+	gate    chan struct{} // если не nil, запись ждёт, пока его закроют
+}
+
+func (s *memoryStore) AppendAuditEvents(_ context.Context, events []*repo.AuditEvent, seal func(prevHash string, event *repo.AuditEvent) string) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches++
+	for _, event := range events {
+		var prevHash string
+		if n := len(s.events); n > 0 {
+			prevHash = s.events[n-1].Hash
+		}
+		event.ID = int64(len(s.events) + 1)
+		event.PrevHash = prevHash
+		event.Hash = seal(prevHash, event)
+		s.events = append(s.events, *event)
+	}
+	return nil
+}
+
+func (s *memoryStore) GetAuditEventsAfter(_ context.Context, afterID int64, limit int) ([]repo.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []repo.AuditEvent
+	for _, e := range s.events {
+		if e.ID > afterID && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+var (
+	testKey  = []byte("0123456789abcdef0123456789abcdef")
+	otherKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// appendEvents дописывает в store n событий, подписанных ключом key (nil - SHA-256)
+func appendEvents(t *testing.T, store *memoryStore, key []byte, n int) {
+	t.Helper()
+
+	actorID := uuid.New()
+	events := make([]*repo.AuditEvent, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, &repo.AuditEvent{
+			OccurredAt: time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+			ActorID:    &actorID,
+			Action:     ActionLogin,
+			IP:         "203.0.113.1",
+			Outcome:    OutcomeSuccess,
+			Details:    map[string]string{"method": "password"},
+		})
+	}
+	err := store.AppendAuditEvents(context.Background(), events, func(prevHash string, e *repo.AuditEvent) string {
+		return Hash(key, prevHash, e)
+	})
+	if err != nil {
+		t.Fatalf("failed to append events: %v", err)
+	}
+}
+
+// newTestLog - журнал без фонового писателя: Verify он не нужен
+func newTestLog(store Store, key []byte) *Log {
+	return &Log{store: store, key: key, log: zap.NewNop().Sugar()}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare заполняет журнал и портит его
+		prepare func(t *testing.T, s *memoryStore)
+		key     []byte
+		want    int64
+	}{
+		{
+			name:    "empty log",
+			prepare: func(*testing.T, *memoryStore) {},
+			key:     testKey,
+			want:    0,
+		},
+		{
+			name:    "intact hmac chain",
+			prepare: func(t *testing.T, s *memoryStore) { appendEvents(t, s, testKey, 5) },
+			key:     testKey,
+			want:    0,
+		},
+		{
+			name:    "intact sha-256 chain without key",
+			prepare: func(t *testing.T, s *memoryStore) { appendEvents(t, s, nil, 5) },
+			key:     nil,
+			want:    0,
+		},
+		{
+			name: "modified field",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, testKey, 5)
+				s.events[2].Outcome = OutcomeFailure
+			},
+			key:  testKey,
+			want: 3,
+		},
+		{
+			name: "modified details",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, testKey, 5)
+				s.events[3].Details = map[string]string{"method": "passkey"}
+			},
+			key:  testKey,
+			want: 4,
+		},
+		{
+			name: "deleted row",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, testKey, 5)
+				s.events = append(s.events[:1], s.events[2:]...)
+			},
+			key:  testKey,
+			want: 3,
+		},
+		{
+			// администратор БД без ключа пересчитал hash правленой записи по SHA-256
+			name: "recomputed with sha-256",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, testKey, 5)
+				for i := 2; i < len(s.events); i++ {
+					if i == 2 {
+						s.events[i].Outcome = OutcomeFailure
+					}
+					s.events[i].PrevHash = s.events[i-1].Hash
+					s.events[i].Hash = Hash(nil, s.events[i].PrevHash, &s.events[i])
+				}
+			},
+			key:  testKey,
+			want: 3,
+		},
+		{
+			name:    "wrong key",
+			prepare: func(t *testing.T, s *memoryStore) { appendEvents(t, s, otherKey, 3) },
+			key:     testKey,
+			want:    1,
+		},
+		{
+			name: "sha-256 records before the key appeared",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, nil, 3)
+				appendEvents(t, s, testKey, 3)
+			},
+			key:  testKey,
+			want: 0,
+		},
+		{
+			name: "sha-256 record after hmac",
+			prepare: func(t *testing.T, s *memoryStore) {
+				appendEvents(t, s, testKey, 3)
+				appendEvents(t, s, nil, 1)
+			},
+			key:  testKey,
+			want: 4,
+		},
+		{
+			name:    "hmac chain without key",
+			prepare: func(t *testing.T, s *memoryStore) { appendEvents(t, s, testKey, 3) },
+			key:     nil,
+			want:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{}
+			tt.prepare(t, store)
+
+			got, err := newTestLog(store, tt.key).Verify(context.Background())
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Verify() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyManyBatches(t *testing.T) {
+	store := &memoryStore{}
+	appendEvents(t, store, testKey, verifyBatchSize+10)
+	store.events[verifyBatchSize+5].Target = "forged"
+
+	got, err := newTestLog(store, testKey).Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if want := int64(verifyBatchSize + 6); got != want {
+		t.Fatalf("Verify() = %d, want %d", got, want)
+	}
+}
+
+func TestRecordFlush(t *testing.T) {
+	store := &memoryStore{}
+	l := New(store, testKey, zap.NewNop().Sugar())
+	defer l.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		l.Record(ctx, Event{Action: ActionLogin, Outcome: OutcomeSuccess})
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	store.mu.Lock()
+	written := len(store.events)
+	store.mu.Unlock()
+	if written != 3 {
+		t.Fatalf("written events = %d, want 3", written)
+	}
+	if got, err := l.Verify(ctx); err != nil || got != 0 {
+		t.Fatalf("Verify() = %d, %v, want 0", got, err)
+	}
+}
+
+func TestRecordClipsClientValues(t *testing.T) {
+	store := &memoryStore{}
+	l := New(store, testKey, zap.NewNop().Sugar())
+	defer l.Close()
+
+	// значения из заголовков клиента не должны мешать записи события в колонки
+	ctx := clientinfo.NewContext(context.Background(), clientinfo.Info{
+		IP:        "203.0.113.1",
+		UserAgent: strings.Repeat("я", maxUserAgentLength+1),
+		RequestID: strings.Repeat("r", maxRequestIDLength) + "\xff",
+	})
+	l.Record(ctx, Event{Action: ActionLogin, Outcome: OutcomeSuccess})
+	l.Record(clientinfo.NewContext(context.Background(), clientinfo.Info{RequestID: "bad\xffid"}), Event{Action: ActionLogin, Outcome: OutcomeFailure})
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	store.mu.Lock()
+	events := append([]repo.AuditEvent(nil), store.events...)
+	store.mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("written events = %d, want 2", len(events))
+	}
+	if got := utf8.RuneCountInString(events[0].UserAgent); got != maxUserAgentLength {
+		t.Fatalf("user agent length = %d, want %d", got, maxUserAgentLength)
+	}
+	if events[0].RequestID != strings.Repeat("r", maxRequestIDLength) {
+		t.Fatalf("request id = %q", events[0].RequestID)
+	}
+	if !utf8.ValidString(events[1].RequestID) {
+		t.Fatalf("request id %q is not valid UTF-8", events[1].RequestID)
+	}
+
+	// hash считается от уже обрезанных значений, которые и попадают в БД
+	if got, err := l.Verify(context.Background()); err != nil || got != 0 {
+		t.Fatalf("Verify() = %d, %v, want 0", got, err)
+	}
+}
+
+func TestCloseWritesQueuedEvents(t *testing.T) {
+	// пока писатель занят первой записью, остальные копятся в очереди
+	store := &memoryStore{gate: make(chan struct{})}
+	l := New(store, testKey, zap.NewNop().Sugar())
+
+	n := writeBatchSize*2 + 1
+	for i := 0; i < n; i++ {
+		l.Record(context.Background(), Event{Action: ActionRefresh, Outcome: OutcomeFailure})
+	}
+	close(store.gate)
+	l.Close()
+
+	if len(store.events) != n {
+		t.Fatalf("written events = %d, want %d", len(store.events), n)
+	}
+	// события пишутся пачками не больше writeBatchSize, а не по одному
+	if store.batches > 4 {
+		t.Fatalf("batches = %d for %d events", store.batches, n)
+	}
+}
+
+func TestFlushCanceled(t *testing.T) {
+	l := &Log{queue: make(chan queued)} // писателя нет, очередь не принимает
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.Flush(ctx); err != context.Canceled {
+		t.Fatalf("Flush() error = %v, want context.Canceled", err)
+	}
+}
@@ -2,6 +2,9 @@ package config
 
 import (
 	"time"
+
+	"newservice/pkg/clientinfo"
+	"newservice/pkg/ratelimit"
 )
 
 // Общая конфигурация сервиса, тут должны быть все переменные
@@ -9,7 +12,10 @@ import (
 type AppConfig struct {
 	LogLevel   string
 	GRPC       GRPC
+	HTTP       HTTP
 	PostgreSQL PostgreSQL
+	Mail       Mail
+	RateLimit  RateLimit
 	System     System
 }
 
@@ -17,6 +23,10 @@ type GRPC struct {
 	ListenAddress string `envconfig:"GRPC_LISTEN_ADDRESS" required:"true"`
 }
 
+type HTTP struct {
+	ListenAddress string `envconfig:"HTTP_LISTEN_ADDRESS" default:":8080"`
+}
+
 type PostgreSQL struct {
 	Host                string        `envconfig:"DB_HOST" required:"true"`
 	Port                int           `envconfig:"DB_PORT" required:"true"`
@@ -29,7 +39,103 @@ type PostgreSQL struct {
 	PoolMaxConnIdleTime time.Duration `envconfig:"DB_POOL_MAX_CONN_IDLE_TIME" default:"100s"`
 }
 
+// Mail - доставка писем: smtp, file (письма складываются в каталог) или memory
+type Mail struct {
+	Driver       string `envconfig:"MAIL_DRIVER" default:"file"`
+	From         string `envconfig:"MAIL_FROM" default:"no-reply@localhost"`
+	Dir          string `envconfig:"MAIL_DIR" default:"mail"`
+	SMTPHost     string `envconfig:"SMTP_HOST" default:"localhost"`
+	SMTPPort     int    `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUsername string `envconfig:"SMTP_USERNAME"`
+	SMTPPassword string `envconfig:"SMTP_PASSWORD"`
+	QueueSize    int    `envconfig:"MAIL_QUEUE_SIZE" default:"1000"` // письма отправляются в фоне, сверх очереди - отказ
+}
+
+// RateLimit - ограничение частоты вызовов gRPC методов и HTTP эндпоинтов (корзины общие): memory - лимиты у каждой реплики свои,
+// redis - общие для всех реплик. Лимиты задаются как количество/период, например 20/1m, 0 - без ограничения
+type RateLimit struct {
+	Enabled       bool          `envconfig:"RATE_LIMIT_ENABLED" default:"true"`
+	Backend       string        `envconfig:"RATE_LIMIT_BACKEND" default:"memory"`
+	RedisAddr     string        `envconfig:"RATE_LIMIT_REDIS_ADDR" default:"localhost:6379"`
+	RedisPassword string        `envconfig:"RATE_LIMIT_REDIS_PASSWORD"`
+	RedisDB       int           `envconfig:"RATE_LIMIT_REDIS_DB" default:"0"`
+	RedisTimeout  time.Duration `envconfig:"RATE_LIMIT_REDIS_TIMEOUT" default:"200ms"`
+
+	// сети балансировщиков через запятую (CIDR или адрес), только от них принимается x-forwarded-for.
+	// По этому адресу работают и лимиты, и блокировка перебора паролей, и журнал аудита
+	TrustedProxies clientinfo.TrustedProxies `envconfig:"RATE_LIMIT_TRUSTED_PROXIES"`
+
+	// по IP клиента для каждого метода, для методов без своего лимита - DefaultPerIP
+	DefaultPerIP  ratelimit.Limit `envconfig:"RATE_LIMIT_DEFAULT_IP" default:"300/1m"`
+	LoginPerIP    ratelimit.Limit `envconfig:"RATE_LIMIT_LOGIN_IP" default:"30/1m"`
+	LoginPerUser  ratelimit.Limit `envconfig:"RATE_LIMIT_LOGIN_USER" default:"10/1m"` // по имени или почте из запроса
+	RegisterPerIP ratelimit.Limit `envconfig:"RATE_LIMIT_REGISTER_IP" default:"5/1m"`
+	RefreshPerIP  ratelimit.Limit `envconfig:"RATE_LIMIT_REFRESH_IP" default:"60/1m"`
+}
+
 type System struct {
 	AccessTokenTimeout  time.Duration `envconfig:"ACCESS_TOKEN_TIMEOUT" default:"15m"` // время жизни токена
 	RefreshTokenTimeout time.Duration `envconfig:"REFRESH_TOKEN_TIMEOUT" default:"60m"`
+
+	// ключи подписи: file - один private.pem, dir - каталог с ключами, db - таблица signing_keys;
+	// период перечитывания 0 - ключи перечитываются только по SIGHUP
+	KeysSource         string        `envconfig:"JWT_KEYS_SOURCE" default:"file"`
+	KeysDir            string        `envconfig:"JWT_KEYS_DIR" default:"keys"`
+	KeysReloadInterval time.Duration `envconfig:"JWT_KEYS_RELOAD_INTERVAL" default:"1m"`
+
+	// registered claims: издатель, получатели по умолчанию и для отдельных клиентов (client_id:aud1 aud2,...)
+	Issuer          string            `envconfig:"JWT_ISSUER" default:"auth-service"`
+	Audience        []string          `envconfig:"JWT_AUDIENCE" default:"api"`
+	ClientAudiences map[string]string `envconfig:"JWT_CLIENT_AUDIENCES"`
+	ClockSkew       time.Duration     `envconfig:"JWT_CLOCK_SKEW" default:"30s"` // допустимое расхождение часов
+
+	// как часто кэш отозванных access токенов догружается из БД
+	DenylistSyncInterval time.Duration `envconfig:"DENYLIST_SYNC_INTERVAL" default:"5s"`
+
+	// второй фактор: ключ шифрования секретов (32 байта в base64, без него MFA выключена),
+	// время на ввод кода после пароля и название сервиса в приложении-аутентификаторе
+	MfaEncryptionKey    string        `envconfig:"MFA_ENCRYPTION_KEY"`
+	MfaChallengeTimeout time.Duration `envconfig:"MFA_CHALLENGE_TIMEOUT" default:"5m"`
+	TotpIssuer          string        `envconfig:"TOTP_ISSUER" default:"auth-service"`
+
+	// ключ HMAC цепочки журнала аудита (32 байта в base64), хранится вне БД. Без него цепочка считается
+	// по SHA-256, и администратор БД может пересчитать её после правки. Сменить или убрать ключ нельзя
+	AuditHMACKey string `envconfig:"AUDIT_HMAC_KEY"`
+
+	// WebAuthn (passkeys): домен и название сервиса для аутентификатора, допустимые origin фронтенда,
+	// время на прохождение церемонии
+	WebauthnRPID      string        `envconfig:"WEBAUTHN_RP_ID" default:"localhost"`
+	WebauthnRPName    string        `envconfig:"WEBAUTHN_RP_NAME" default:"auth-service"`
+	WebauthnRPOrigins []string      `envconfig:"WEBAUTHN_RP_ORIGINS" default:"http://localhost:8080"`
+	WebauthnTimeout   time.Duration `envconfig:"WEBAUTHN_TIMEOUT" default:"5m"`
+
+	// адрес фронтенда, на который ведут ссылки из писем, и время жизни ссылок
+	AppURL                   string        `envconfig:"APP_URL" default:"http://localhost:3000"`
+	PasswordResetTimeout     time.Duration `envconfig:"PASSWORD_RESET_TIMEOUT" default:"1h"`
+	EmailVerificationTimeout time.Duration `envconfig:"EMAIL_VERIFICATION_TIMEOUT" default:"24h"`
+	EmailChangeTimeout       time.Duration `envconfig:"EMAIL_CHANGE_TIMEOUT" default:"24h"`
+	EmailChangeCancelTimeout time.Duration `envconfig:"EMAIL_CHANGE_CANCEL_TIMEOUT" default:"168h"` // ссылка отмены на старый адрес
+
+	// сколько последних паролей нельзя использовать повторно
+	PasswordHistorySize int `envconfig:"PASSWORD_HISTORY_SIZE" default:"5"`
+
+	// не пускать пользователя, пока он не подтвердил почту
+	RequireEmailVerification bool `envconfig:"REQUIRE_EMAIL_VERIFICATION" default:"false"`
+
+	// защита от перебора паролей: сколько ошибок подряд допускается для учётной записи и для IP,
+	// первая блокировка (каждая следующая ошибка удваивает её до LOGIN_LOCKOUT_MAX)
+	// и через сколько после последней ошибки счётчик сбрасывается
+	LoginMaxFailures   int           `envconfig:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginIPMaxFailures int           `envconfig:"LOGIN_IP_MAX_FAILURES" default:"50"`
+	LoginLockoutBase   time.Duration `envconfig:"LOGIN_LOCKOUT_BASE" default:"30s"`
+	LoginLockoutMax    time.Duration `envconfig:"LOGIN_LOCKOUT_MAX" default:"1h"`
+	LoginFailureWindow time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"1h"`
+
+	// сервер авторизации OAuth 2.0: время жизни кода авторизации и время на вход и согласие на странице авторизации
+	OAuthCodeTimeout  time.Duration `envconfig:"OAUTH_CODE_TIMEOUT" default:"1m"`
+	OAuthLoginTimeout time.Duration `envconfig:"OAUTH_LOGIN_TIMEOUT" default:"10m"`
+
+	// внешний адрес HTTP API, из него строятся адреса эндпоинтов в discovery OpenID Connect.
+	// Клиенты OpenID Connect сверяют iss с адресом провайдера, поэтому JWT_ISSUER стоит задать таким же
+	PublicURL string `envconfig:"PUBLIC_URL" default:"http://localhost:8080"`
 }
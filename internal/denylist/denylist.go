@@ -0,0 +1,128 @@
+package denylist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"newservice/internal/repo"
+
+	"go.uber.org/zap"
+)
+
+// Denylist - кэш отозванных access токенов в памяти процесса. Источник истины - таблица revoked_tokens,
+// кэш периодически догружает из неё новые записи, поэтому проверка токена не ходит в БД.
+// Запись живёт до истечения самого токена, после этого она не нужна
+
+// syncOverlap - запас при догрузке: транзакция могла закоммититься позже, чем записан её created_at
+const syncOverlap = 30 * time.Second
+
+type Store interface {
+	RevokeToken(ctx context.Context, token repo.RevokedToken) error
+	GetRevokedTokens(ctx context.Context, since time.Time) ([]repo.RevokedToken, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) error
+}
+
+type Denylist struct {
+	store Store
+
+	mu     sync.RWMutex
+	tokens map[string]time.Time // jti -> время истечения токена
+	since  time.Time
+}
+
+func New(store Store) *Denylist {
+	return &Denylist{
+		store:  store,
+		tokens: make(map[string]time.Time),
+	}
+}
+
+// IsRevoked проверяет токен только по кэшу
+func (d *Denylist) IsRevoked(tokenID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	expiresAt, ok := d.tokens[tokenID]
+	return ok && expiresAt.After(time.Now())
+}
+
+// Revoke отзывает токен до момента его истечения
+func (d *Denylist) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if !expiresAt.After(time.Now()) {
+		return nil
+	}
+
+	if err := d.store.RevokeToken(ctx, repo.RevokedToken{
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.tokens[tokenID] = expiresAt
+	d.mu.Unlock()
+
+	return nil
+}
+
+// Sync догружает записи, добавленные с прошлой синхронизации (в том числе другими репликами),
+// и выбрасывает из кэша истёкшие
+func (d *Denylist) Sync(ctx context.Context) error {
+	d.mu.RLock()
+	since := d.since
+	d.mu.RUnlock()
+
+	tokens, err := d.store.GetRevokedTokens(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	latest := since
+	for _, t := range tokens {
+		d.tokens[t.TokenID] = t.ExpiresAt
+		if t.CreatedAt.After(latest) {
+			latest = t.CreatedAt
+		}
+	}
+
+	for tokenID, expiresAt := range d.tokens {
+		if !expiresAt.After(now) {
+			delete(d.tokens, tokenID)
+		}
+	}
+
+	if next := latest.Add(-syncOverlap); next.After(d.since) {
+		d.since = next
+	}
+
+	return nil
+}
+
+// Run синхронизирует кэш с заданным интервалом и чистит истёкшие записи в БД
+func (d *Denylist) Run(ctx context.Context, interval time.Duration, log *zap.SugaredLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := d.Sync(ctx); err != nil {
+			log.Errorf("failed to sync token denylist: %v", err)
+		}
+
+		if err := d.store.DeleteExpiredRevokedTokens(ctx); err != nil {
+			log.Errorf("failed to delete expired revoked tokens: %v", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"net/http"
+
+	AuthService "newservice/grpc/genproto"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// HTTP-обёртка над gRPC сервисом для эндпоинтов, которые по стандарту должны быть доступны по HTTP
+// (JWKS, интроспекция токенов, сервер авторизации OAuth 2.0 и OpenID Connect)
+
+// Methods сопоставляет эндпоинты с методами gRPC, которые за ними стоят: для общих лимитов частоты запросов
+var Methods = map[string]string{
+	"GET /.well-known/jwks.json": AuthService.AuthService_GetJwks_FullMethodName,
+	"POST /introspect":           AuthService.AuthService_Introspect_FullMethodName,
+	"GET /oauth/authorize":       AuthService.AuthService_OAuthAuthorize_FullMethodName,
+	"POST /oauth/authorize":      AuthService.AuthService_OAuthAuthorize_FullMethodName,
+	"POST /oauth/token":          AuthService.AuthService_OAuthToken_FullMethodName,
+	"GET /oauth/userinfo":        AuthService.AuthService_UserInfo_FullMethodName,
+	"POST /oauth/userinfo":       AuthService.AuthService_UserInfo_FullMethodName,
+}
+
+type handler struct {
+	auth AuthService.AuthServiceServer
+	log  *zap.SugaredLogger
+}
+
+func NewHandler(auth AuthService.AuthServiceServer, log *zap.SugaredLogger) http.Handler {
+	h := &handler{
+		auth: auth,
+		log:  log,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+	mux.HandleFunc("POST /introspect", h.introspect)
+	mux.HandleFunc("GET /oauth/authorize", h.authorize)
+	mux.HandleFunc("POST /oauth/authorize", h.authorize)
+	mux.HandleFunc("POST /oauth/token", h.token)
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.openIDConfiguration)
+	mux.HandleFunc("GET /oauth/userinfo", h.userInfo)
+	mux.HandleFunc("POST /oauth/userinfo", h.userInfo)
+	mux.HandleFunc("GET /oauth/logout", h.logout)
+	mux.HandleFunc("POST /oauth/logout", h.logout)
+
+	return mux
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.auth.GetJwks(r.Context(), &AuthService.GetJwksRequest{})
+	if err != nil {
+		h.log.Errorf("failed to get jwks: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// проверяющие сервисы кэшируют ключи, поэтому отдаём их с небольшим временем жизни
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeProto(w, http.StatusOK, resp)
+}
+
+func (h *handler) writeProto(w http.ResponseWriter, code int, m proto.Message) {
+	body, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		h.log.Errorf("failed to marshal response: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// introspectionResponse - ответ по RFC 7662: exp и iat числами, пустые поля не выводятся
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Sub       string   `json:"sub,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// introspect принимает application/x-www-form-urlencoded с полями token и token_type_hint.
+// Клиент аутентифицируется так же, как на /oauth/token
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+
+	resp, err := h.auth.Introspect(incomingContext(r), &AuthService.IntrospectRequest{
+		Token:               r.PostForm.Get("token"),
+		TokenTypeHint:       r.PostForm.Get("token_type_hint"),
+		ClientId:            clientID,
+		ClientSecret:        clientSecret,
+		ClientAssertionType: r.PostForm.Get("client_assertion_type"),
+		ClientAssertion:     r.PostForm.Get("client_assertion"),
+	})
+	if err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			h.writeJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_client", Description: status.Convert(err).Message()})
+			return
+		}
+		if status.Code(err) == codes.InvalidArgument {
+			h.writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: status.Convert(err).Message()})
+			return
+		}
+		h.log.Errorf("failed to introspect token: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, introspectionResponse{
+		Active:    resp.Active,
+		Sub:       resp.Sub,
+		Exp:       resp.Exp,
+		Iat:       resp.Iat,
+		Scope:     resp.Scope,
+		ClientID:  resp.ClientId,
+		TokenType: resp.TokenType,
+		SessionID: resp.SessionId,
+		Iss:       resp.Iss,
+		Aud:       resp.Aud,
+		Jti:       resp.Jti,
+	})
+}
+
+// oauthError - тело ошибки в формате OAuth 2.0 (RFC 6749, раздел 5.2)
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, code int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		h.log.Errorf("failed to marshal response: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
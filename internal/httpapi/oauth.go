@@ -0,0 +1,219 @@
+package httpapi
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/service"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templates, "templates/authorize.html"))
+
+// параметры запроса авторизации, которые страница передаёт от шага к шагу
+var authorizeParams = []string{
+	"response_type",
+	"client_id",
+	"redirect_uri",
+	"scope",
+	"state",
+	"code_challenge",
+	"code_challenge_method",
+	"nonce",
+}
+
+type pageParam struct {
+	Name  string
+	Value string
+}
+
+type authorizePage struct {
+	Step       string // шаг страницы или error
+	ClientName string
+	Scopes     []string
+	Error      string
+	Identifier string
+	MfaToken   string
+	LoginToken string
+	Params     []pageParam
+}
+
+// tokenResponse - успешный ответ /token (RFC 6749, раздел 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// authorize - страница авторизации: GET открывает её по ссылке клиента, POST отправляет шаги входа и согласия.
+// Параметры запроса авторизации приходят в query при GET и скрытыми полями формы при POST
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderAuthorize(w, http.StatusBadRequest, authorizePage{Step: "error", Error: "malformed request"})
+		return
+	}
+
+	req := &AuthService.OAuthAuthorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientId:            r.Form.Get("client_id"),
+		RedirectUri:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+	// учётные данные принимаются только из тела формы, чтобы они не попадали в адресную строку и логи
+	if r.Method == http.MethodPost {
+		req.Identifier = r.PostForm.Get("identifier")
+		req.Password = r.PostForm.Get("password")
+		req.MfaToken = r.PostForm.Get("mfa_token")
+		req.MfaCode = r.PostForm.Get("mfa_code")
+		req.RecoveryCode = r.PostForm.Get("recovery_code")
+		req.LoginToken = r.PostForm.Get("login_token")
+		req.Consent = r.PostForm.Get("consent")
+	}
+
+	resp, err := h.auth.OAuthAuthorize(incomingContext(r), req)
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			h.renderAuthorize(w, http.StatusBadRequest, authorizePage{Step: "error", Error: status.Convert(err).Message()})
+			return
+		}
+		h.log.Errorf("failed to authorize: %v", err)
+		h.renderAuthorize(w, http.StatusInternalServerError, authorizePage{Step: "error", Error: "something went wrong, please try again later"})
+		return
+	}
+
+	if resp.Step == service.OAuthStepRedirect {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, resp.RedirectUri, http.StatusFound)
+		return
+	}
+
+	page := authorizePage{
+		Step:       resp.Step,
+		ClientName: resp.ClientName,
+		Scopes:     resp.Scopes,
+		Error:      resp.Error,
+		Identifier: req.Identifier,
+		MfaToken:   resp.MfaToken,
+		LoginToken: resp.LoginToken,
+	}
+	for _, name := range authorizeParams {
+		if value := r.Form.Get(name); value != "" {
+			page.Params = append(page.Params, pageParam{Name: name, Value: value})
+		}
+	}
+	h.renderAuthorize(w, http.StatusOK, page)
+}
+
+func (h *handler) renderAuthorize(w http.ResponseWriter, code int, page authorizePage) {
+	// страницу со входом и согласием нельзя встраивать в чужие страницы (clickjacking) и кэшировать
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(code)
+
+	if err := authorizeTemplate.Execute(w, page); err != nil {
+		h.log.Errorf("failed to render authorize page: %v", err)
+	}
+}
+
+// token - обмен на токены. Клиент передаёт учётные данные в Authorization: Basic (client_secret_basic),
+// в теле формы (client_secret_post) или подписанным JWT в client_assertion (private_key_jwt)
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		h.writeJSON(w, http.StatusBadRequest, oauthError{Error: service.OAuthInvalidRequest})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+
+	resp, err := h.auth.OAuthToken(incomingContext(r), &AuthService.OAuthTokenRequest{
+		GrantType:           r.PostForm.Get("grant_type"),
+		ClientId:            clientID,
+		ClientSecret:        clientSecret,
+		Code:                r.PostForm.Get("code"),
+		RedirectUri:         r.PostForm.Get("redirect_uri"),
+		CodeVerifier:        r.PostForm.Get("code_verifier"),
+		RefreshToken:        r.PostForm.Get("refresh_token"),
+		Scope:               r.PostForm.Get("scope"),
+		ClientAssertionType: r.PostForm.Get("client_assertion_type"),
+		ClientAssertion:     r.PostForm.Get("client_assertion"),
+	})
+	if err != nil {
+		reason := oauthErrorReason(err)
+		switch reason {
+		case "":
+			h.log.Errorf("failed to issue oauth token: %v", err)
+			h.writeJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+		case service.OAuthInvalidClient:
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			h.writeJSON(w, http.StatusUnauthorized, oauthError{Error: reason, Description: status.Convert(err).Message()})
+		default:
+			h.writeJSON(w, http.StatusBadRequest, oauthError{Error: reason, Description: status.Convert(err).Message()})
+		}
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		ExpiresIn:    resp.ExpiresIn,
+		RefreshToken: resp.RefreshToken,
+		Scope:        resp.Scope,
+		IDToken:      resp.IdToken,
+	})
+}
+
+// clientCredentials достаёт идентификатор и секрет клиента из Authorization: Basic или из тела формы
+func clientCredentials(r *http.Request) (string, string) {
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	// в Basic идентификатор и секрет закодированы как application/x-www-form-urlencoded (RFC 6749, раздел 2.3.1)
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	return clientID, clientSecret
+}
+
+// oauthErrorReason достаёт код ошибки OAuth из ErrorInfo
+func oauthErrorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+// incomingContext передаёт сервису user agent и идентификатор запроса так же, как они приходят
+// в метаданных gRPC. Адрес клиента уже определил clientinfo.HTTPMiddleware с учётом доверенных прокси
+func incomingContext(r *http.Request) context.Context {
+	md := metadata.Pairs("user-agent", r.UserAgent())
+	if requestID := r.Header.Get("X-Request-Id"); requestID != "" {
+		md.Set("x-request-id", requestID)
+	}
+	return metadata.NewIncomingContext(r.Context(), md)
+}
@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userInfoResponse - ответ userinfo (OpenID Connect Core 1.0, раздел 5.3.2): claims без значения не передаются
+type userInfoResponse struct {
+	Sub               string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"` // только вместе с email
+}
+
+func (h *handler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.auth.GetOpenIDConfiguration(r.Context(), &AuthService.GetOpenIDConfigurationRequest{})
+	if err != nil {
+		h.log.Errorf("failed to get openid configuration: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeProto(w, http.StatusOK, resp)
+}
+
+// userInfo принимает access токен в Authorization: Bearer или в поле access_token тела формы (RFC 6750, раздел 2)
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.Method == http.MethodPost {
+		if err := r.ParseForm(); err == nil {
+			accessToken = r.PostForm.Get("access_token")
+		}
+	}
+	if accessToken == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.auth.UserInfo(incomingContext(r), &AuthService.UserInfoRequest{
+		AccessToken: accessToken,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unauthenticated:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+			h.writeJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_token", Description: status.Convert(err).Message()})
+		case codes.PermissionDenied:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="insufficient_scope", scope="openid"`)
+			h.writeJSON(w, http.StatusForbidden, oauthError{Error: "insufficient_scope", Description: status.Convert(err).Message()})
+		default:
+			h.log.Errorf("failed to get userinfo: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	info := userInfoResponse{
+		Sub:               resp.Sub,
+		Name:              resp.Name,
+		GivenName:         resp.GivenName,
+		FamilyName:        resp.FamilyName,
+		PreferredUsername: resp.PreferredUsername,
+		UpdatedAt:         resp.UpdatedAt,
+		Email:             resp.Email,
+	}
+	if resp.Email != "" {
+		info.EmailVerified = &resp.EmailVerified
+	}
+	h.writeJSON(w, http.StatusOK, info)
+}
+
+// logout - выход по запросу клиента: GET по ссылке клиента или POST формой. После выхода пользователь
+// возвращается на post_logout_redirect_uri, а без него видит страницу о завершении сессии
+func (h *handler) logout(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderAuthorize(w, http.StatusBadRequest, authorizePage{Step: "error", Error: "malformed request"})
+		return
+	}
+
+	resp, err := h.auth.OAuthLogout(incomingContext(r), &AuthService.OAuthLogoutRequest{
+		IdTokenHint:           r.Form.Get("id_token_hint"),
+		ClientId:              r.Form.Get("client_id"),
+		PostLogoutRedirectUri: r.Form.Get("post_logout_redirect_uri"),
+		State:                 r.Form.Get("state"),
+	})
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			h.renderAuthorize(w, http.StatusBadRequest, authorizePage{Step: "error", Error: status.Convert(err).Message()})
+			return
+		}
+		h.log.Errorf("failed to logout: %v", err)
+		h.renderAuthorize(w, http.StatusInternalServerError, authorizePage{Step: "error", Error: "something went wrong, please try again later"})
+		return
+	}
+
+	if resp.RedirectUri != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, resp.RedirectUri, http.StatusFound)
+		return
+	}
+	h.renderAuthorize(w, http.StatusOK, authorizePage{Step: "logged_out"})
+}
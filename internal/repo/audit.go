@@ -0,0 +1,154 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// auditChainLockKey - ключ advisory lock, под которым реплики по очереди добавляют пачки записей журнала аудита,
+// чтобы цепочка хэшей не ветвилась. Блокировку берёт только фоновый писатель журнала, не запрос
+const auditChainLockKey = 0x61756469
+
+const (
+	lockAuditChainQuery = `
+		SELECT pg_advisory_xact_lock($1);
+	`
+
+	getLastAuditHashQuery = `
+		SELECT hash
+		FROM audit_events
+		ORDER BY id DESC
+		LIMIT 1;
+	`
+
+	insertAuditEventQuery = `
+		INSERT INTO audit_events (occurred_at, actor_id, action, target, ip, user_agent, outcome, request_id, details, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id;
+	`
+
+	listAuditEventsQuery = `
+		SELECT id, occurred_at, actor_id, action, target, ip, user_agent, outcome, request_id, details, prev_hash, hash
+		FROM audit_events
+		WHERE ($1::uuid IS NULL OR actor_id = $1)
+		  AND ($2::text = '' OR action = $2)
+		  AND ($3::text = '' OR target = $3)
+		  AND ($4::text = '' OR outcome = $4)
+		  AND ($5::timestamptz IS NULL OR occurred_at >= $5)
+		  AND ($6::timestamptz IS NULL OR occurred_at < $6)
+		  AND ($7::bigint = 0 OR id < $7)
+		ORDER BY id DESC
+		LIMIT $8;
+	`
+
+	getAuditEventsAfterQuery = `
+		SELECT id, occurred_at, actor_id, action, target, ip, user_agent, outcome, request_id, details, prev_hash, hash
+		FROM audit_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2;
+	`
+)
+
+// AppendAuditEvents добавляет записи в конец журнала одной транзакцией. seal считает hash записи по hash
+// предыдущей (пустая строка для первой записи); PrevHash, Hash и ID заполняются в events
+func (r *repository) AppendAuditEvents(ctx context.Context, events []*AuditEvent, seal func(prevHash string, event *AuditEvent) string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, lockAuditChainQuery, auditChainLockKey); err != nil {
+		return errors.Wrap(err, "failed to lock audit chain")
+	}
+
+	var prevHash string
+	err = tx.QueryRow(ctx, getLastAuditHashQuery).Scan(&prevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return errors.Wrap(err, "failed to get last audit hash")
+	}
+
+	for _, event := range events {
+		event.PrevHash = prevHash
+		event.Hash = seal(prevHash, event)
+
+		err = tx.QueryRow(ctx, insertAuditEventQuery,
+			event.OccurredAt, event.ActorID, event.Action, event.Target, event.IP, event.UserAgent,
+			event.Outcome, event.RequestID, event.Details, event.PrevHash, event.Hash,
+		).Scan(&event.ID)
+		if err != nil {
+			return errors.Wrap(err, "failed to insert audit event")
+		}
+		prevHash = event.Hash
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// ListAuditEvents возвращает записи журнала по фильтру, начиная с самых новых
+func (r *repository) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, listAuditEventsQuery,
+		filter.ActorID, filter.Action, filter.Target, filter.Outcome,
+		filter.Since, filter.Until, filter.BeforeID, filter.Limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list audit events")
+	}
+
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list audit events")
+	}
+	return events, nil
+}
+
+// GetAuditEventsAfter возвращает записи в порядке добавления, начиная со следующей за afterID, - для проверки цепочки
+func (r *repository) GetAuditEventsAfter(ctx context.Context, afterID int64, limit int) ([]AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, getAuditEventsAfterQuery, afterID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get audit events")
+	}
+
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get audit events")
+	}
+	return events, nil
+}
+
+func scanAuditEvents(rows pgx.Rows) ([]AuditEvent, error) {
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.OccurredAt,
+			&e.ActorID,
+			&e.Action,
+			&e.Target,
+			&e.IP,
+			&e.UserAgent,
+			&e.Outcome,
+			&e.RequestID,
+			&e.Details,
+			&e.PrevHash,
+			&e.Hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
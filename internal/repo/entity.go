@@ -1,42 +1,292 @@
 package repo
 
 import (
-	"database/sql"
 	"github.com/google/uuid"
-	"newservice/pkg/jwt"
 	"time"
 )
 
 type User struct {
-	ID             uuid.UUID `db:"id"`
-	Username       string    `db:"username"`
-	HashedPassword string    `db:"password_hash"`
-	Email          string    `db:"email"`
-	CreatedAt      time.Time `db:"created_at"`
-	UpdatedAt      time.Time `db:"updated_at"`
+	ID              uuid.UUID  `db:"id"`
+	Username        string     `db:"username"`
+	HashedPassword  string     `db:"password_hash"`
+	Email           string     `db:"email"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	FirstName       *string    `db:"first_name"`
+	LastName        *string    `db:"last_name"`
+	CreatedAt       time.Time  `db:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at"`
+}
+
+// UpdateProfileParams - nil оставляет поле без изменений, пустая строка очищает его
+type UpdateProfileParams struct {
+	UserID    uuid.UUID `db:"id"`
+	FirstName *string   `db:"first_name"`
+	LastName  *string   `db:"last_name"`
+}
+
+type UpdatePasswordParams struct {
+	UserID       uuid.UUID `db:"id"`
+	PasswordHash string    `db:"password_hash"`
+	HistorySize  int       // сколько прежних паролей хранить, 0 - историю не вести
+}
+
+// Session - сессия устройства, все refresh токены одной сессии образуют семейство
+type Session struct {
+	ID         uuid.UUID  `db:"id"`
+	UserID     uuid.UUID  `db:"user_id"`
+	ClientID   string     `db:"client_id"`
+	DeviceName string     `db:"device_name"`
+	IP         string     `db:"ip"`
+	UserAgent  string     `db:"user_agent"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt time.Time  `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+type AuthToken struct {
+	ID               int64      `db:"id"`
+	UserID           uuid.UUID  `db:"user_id"`
+	SessionID        uuid.UUID  `db:"session_id"`
+	ClientID         string     `db:"client_id"` // из сессии
+	Scope            string     `db:"scope"`     // из сессии
+	RefreshExpiresAt time.Time  `db:"refresh_expires_at"`
+	CreatedAt        time.Time  `db:"created_at"`
+	ConsumedAt       *time.Time `db:"consumed_at"`
+	RevokedAt        *time.Time `db:"revoked_at"`
+}
+
+type NewSessionParams struct {
+	ID         uuid.UUID `db:"id"`
+	UserID     uuid.UUID `db:"user_id"`
+	ClientID   string    `db:"client_id"`
+	DeviceName string    `db:"device_name"`
+	IP         string    `db:"ip"`
+	UserAgent  string    `db:"user_agent"`
+	Scope      string    `db:"scope"` // разрешения через пробел, выданные клиенту OAuth
 }
 
 type NewAuthTokenParams struct {
 	UserID           uuid.UUID `db:"user_id"`
-	Tokens           jwt.CreateTokenResponse
+	SessionID        uuid.UUID `db:"session_id"`
+	AccessTokenID    string    `db:"access_jti"`
+	AccessExpiresAt  time.Time `db:"access_expires_at"`
+	RefreshTokenHash string    `db:"refresh_token_hash"` // сам refresh токен не хранится
 	RefreshExpiresAt time.Time `db:"refresh_expires_at"`
 }
 
-type NewRefreshTokenParams struct {
-	UserID uuid.UUID `db:"user_id"`
-	Token  string
+type RotateRefreshTokenParams struct {
+	ConsumedID int64 // id записи предъявленного refresh токена
+	NewToken   NewAuthTokenParams
+	IP         string // адрес и user agent, с которых сессия использовалась последний раз
+	UserAgent  string
+}
+
+type RevokeSessionParams struct {
+	SessionID uuid.UUID `db:"id"`
+	UserID    uuid.UUID `db:"user_id"`
+}
+
+type RevokeUserSessionsParams struct {
+	UserID          uuid.UUID `db:"user_id"`
+	ExceptSessionID uuid.UUID // uuid.Nil - отозвать все сессии пользователя
+}
+
+// RevokedToken - запись denylist: отозванный до истечения срока access токен
+type RevokedToken struct {
+	TokenID   string    `db:"jti"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// UserTotp - подключённый (или подключаемый) второй фактор TOTP
+type UserTotp struct {
+	UserID       uuid.UUID  `db:"user_id"`
+	Secret       []byte     `db:"secret"` // зашифрован
+	ConfirmedAt  *time.Time `db:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// LoginFailureKey - счётчик неудачных попыток: по чему считаются и значение
+type LoginFailureKey struct {
+	Scope   string `db:"scope"`
+	Subject string `db:"subject"`
+}
+
+type RecoveryCode struct {
+	ID        int64     `db:"id"`
+	UserID    uuid.UUID `db:"user_id"`
+	CodeHash  string    `db:"code_hash"` // HMAC-SHA256 кода в hex
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type UseRecoveryCodeParams struct {
+	ID        int64  `db:"id"`
+	IP        string `db:"used_ip"`
+	UserAgent string `db:"used_user_agent"`
+}
+
+// WebauthnCredential - зарегистрированный ключ доступа (passkey)
+type WebauthnCredential struct {
+	ID              []byte     `db:"id"`
+	UserID          uuid.UUID  `db:"user_id"`
+	Name            string     `db:"name"`
+	PublicKey       []byte     `db:"public_key"`
+	AttestationType string     `db:"attestation_type"`
+	Transports      []string   `db:"transports"`
+	AAGUID          []byte     `db:"aaguid"`
+	Flags           int16      `db:"flags"`
+	SignCount       int64      `db:"sign_count"`
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
 }
 
-type DeleteRefreshTokenParams struct {
-	UserID uuid.UUID `db:"user_id"`
+// WebauthnChallenge - состояние незавершённой церемонии WebAuthn
+type WebauthnChallenge struct {
+	ID          uuid.UUID  `db:"id"`
+	UserID      *uuid.UUID `db:"user_id"`
+	Purpose     string     `db:"purpose"`
+	SessionData []byte     `db:"session_data"`
+	ExpiresAt   time.Time  `db:"expires_at"`
 }
 
-type GetRefreshTokenParams struct {
-	UserID uuid.UUID `db:"user_id"`
+// UserToken - одноразовый токен из письма
+type UserToken struct {
+	ID        int64      `db:"id"`
+	UserID    uuid.UUID  `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	Payload   string     `db:"payload"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
 }
 
-type UpdateRefreshTokenParams struct {
+type NewUserTokenParams struct {
 	UserID    uuid.UUID `db:"user_id"`
-	Token     string
-	UpdatedAt sql.NullTime
+	Purpose   string    `db:"purpose"`
+	TokenHash string    `db:"token_hash"`
+	Payload   string    `db:"payload"`
+	ExpiresAt time.Time `db:"expires_at"`
+	// не отменять ранее выданные токены того же назначения
+	KeepExisting bool
+}
+
+type Role struct {
+	ID          int64     `db:"id"`
+	Name        string    `db:"name"`
+	Description string    `db:"description"`
+	Permissions []string  // права роли
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// UserAuthorization - роли и права пользователя, назначенные на все ресурсы
+type UserAuthorization struct {
+	Roles       []string
+	Permissions []string
+}
+
+type RolePermissionParams struct {
+	RoleName   string `db:"name"`
+	Permission string `db:"permission"`
+}
+
+type UserRoleParams struct {
+	UserID   uuid.UUID `db:"user_id"`
+	RoleName string    `db:"name"`
+	Resource string    `db:"resource"` // '*' - все ресурсы
+}
+
+type HasPermissionParams struct {
+	UserID     uuid.UUID `db:"user_id"`
+	Permission string    `db:"permission"`
+	Resource   string    `db:"resource"`
+}
+
+// AuditEvent - запись журнала аудита
+type AuditEvent struct {
+	ID         int64             `db:"id"`
+	OccurredAt time.Time         `db:"occurred_at"`
+	ActorID    *uuid.UUID        `db:"actor_id"`
+	Action     string            `db:"action"`
+	Target     string            `db:"target"`
+	IP         string            `db:"ip"`
+	UserAgent  string            `db:"user_agent"`
+	Outcome    string            `db:"outcome"`
+	RequestID  string            `db:"request_id"`
+	Details    map[string]string `db:"details"`
+	PrevHash   string            `db:"prev_hash"`
+	Hash       string            `db:"hash"`
+}
+
+// AuditEventFilter - пустые поля не фильтруют
+type AuditEventFilter struct {
+	ActorID  *uuid.UUID
+	Action   string
+	Target   string
+	Outcome  string
+	Since    *time.Time
+	Until    *time.Time
+	BeforeID int64 // для постраничного чтения: только записи старше этой
+	Limit    int
+}
+
+// OAuthClient - приложение, которое получает токены по OAuth 2.0
+type OAuthClient struct {
+	ID                     string    `db:"id"`
+	Name                   string    `db:"name"`
+	SecretHash             *string   `db:"secret_hash"` // nil - публичный клиент или клиент с ключом
+	PublicKey              *string   `db:"public_key"`  // PEM для private_key_jwt
+	RedirectURIs           []string  `db:"redirect_uris"`
+	PostLogoutRedirectURIs []string  `db:"post_logout_redirect_uris"`
+	GrantTypes             []string  `db:"grant_types"`
+	Scopes                 []string  `db:"scopes"`
+	FirstParty             bool      `db:"first_party"`
+	CreatedAt              time.Time `db:"created_at"`
+	UpdatedAt              time.Time `db:"updated_at"`
+}
+
+// OAuthCode - код авторизации, хранится только его хэш
+type OAuthCode struct {
+	CodeHash            string     `db:"code_hash"`
+	ClientID            string     `db:"client_id"`
+	UserID              uuid.UUID  `db:"user_id"`
+	RedirectURI         string     `db:"redirect_uri"`
+	Scope               string     `db:"scope"`
+	CodeChallenge       string     `db:"code_challenge"`
+	CodeChallengeMethod string     `db:"code_challenge_method"`
+	Nonce               string     `db:"nonce"`
+	AuthTime            *time.Time `db:"auth_time"` // когда пользователь вошёл, у кодов до OpenID Connect - nil
+	Amr                 []string   `db:"amr"`       // способы аутентификации
+	CreatedAt           time.Time  `db:"created_at"`
+	ExpiresAt           time.Time  `db:"expires_at"`
+	UsedAt              *time.Time `db:"used_at"`
+	SessionID           *uuid.UUID `db:"session_id"`
+}
+
+// OAuthConsent - разрешения, на которые пользователь согласился для клиента
+type OAuthConsent struct {
+	UserID     uuid.UUID `db:"user_id"`
+	ClientID   string    `db:"client_id"`
+	ClientName string    `db:"name"` // из клиента
+	Scopes     []string  `db:"scopes"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+type UseOAuthCodeParams struct {
+	CodeHash  string    `db:"code_hash"`
+	ClientID  string    `db:"client_id"`  // клиент, предъявивший код: чужой код не гасится
+	SessionID uuid.UUID `db:"session_id"` // сессия, которая будет открыта по коду
+}
+
+// UseClientAssertionParams - подписанный JWT клиента, предъявленный для аутентификации
+type UseClientAssertionParams struct {
+	ClientID  string    `db:"client_id"`
+	TokenID   string    `db:"jti"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type RevokeClientSessionsParams struct {
+	UserID   *uuid.UUID `db:"user_id"` // nil - сессии всех пользователей клиента
+	ClientID string     `db:"client_id"`
 }
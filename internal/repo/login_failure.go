@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// по чему считаются неудачные попытки
+const (
+	LoginScopeUser = "user" // неверные пароли и коды к учётной записи (id пользователя)
+	LoginScopeIP   = "ip"   // неудачные входы с адреса клиента
+	LoginScopeMfa  = "mfa"  // неверные коды второго фактора по одному токену незавершённого входа (jti)
+)
+
+const (
+	// счётчики без действующей блокировки, последняя ошибка в которых старше окна, больше не нужны
+	deleteStaleLoginFailuresQuery = `
+		DELETE FROM login_failures
+		WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW());
+	`
+
+	recordLoginFailureQuery = `
+		INSERT INTO login_failures (scope, subject, failures, last_failed_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, subject) DO UPDATE
+		SET failures = CASE
+		        WHEN login_failures.last_failed_at < $3 THEN 1
+		        ELSE login_failures.failures + 1
+		    END,
+		    last_failed_at = NOW()
+		RETURNING failures;
+	`
+
+	lockLoginQuery = `
+		UPDATE login_failures
+		SET locked_until = GREATEST(locked_until, $3)
+		WHERE scope = $1 AND subject = $2;
+	`
+
+	getLoginLockQuery = `
+		SELECT MAX(locked_until)
+		FROM login_failures
+		WHERE (scope, subject) IN (SELECT * FROM unnest($1::text[], $2::text[])) AND locked_until > NOW();
+	`
+
+	resetLoginFailuresQuery = `
+		DELETE FROM login_failures
+		WHERE scope = $1 AND subject = $2;
+	`
+)
+
+// RecordLoginFailure увеличивает счётчик неудачных попыток и возвращает его значение.
+// Если с прошлой ошибки прошло больше окна (последняя ошибка раньше since), счёт начинается заново
+func (r *repository) RecordLoginFailure(ctx context.Context, key LoginFailureKey, since time.Time) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, deleteStaleLoginFailuresQuery, since); err != nil {
+		return 0, errors.Wrap(err, "failed to delete stale login failures")
+	}
+
+	var failures int
+	if err := tx.QueryRow(ctx, recordLoginFailureQuery, key.Scope, key.Subject, since).Scan(&failures); err != nil {
+		return 0, errors.Wrap(err, "failed to record login failure")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
+	}
+	return failures, nil
+}
+
+// LockLogin запрещает вход до until, более длинная действующая блокировка не сокращается
+func (r *repository) LockLogin(ctx context.Context, key LoginFailureKey, until time.Time) error {
+	if _, err := r.pool.Exec(ctx, lockLoginQuery, key.Scope, key.Subject, until); err != nil {
+		return errors.Wrap(err, "failed to lock login")
+	}
+	return nil
+}
+
+// GetLoginLock возвращает самую позднюю действующую блокировку среди ключей, nil - блокировки нет
+func (r *repository) GetLoginLock(ctx context.Context, keys ...LoginFailureKey) (*time.Time, error) {
+	scopes := make([]string, 0, len(keys))
+	subjects := make([]string, 0, len(keys))
+	for _, key := range keys {
+		scopes = append(scopes, key.Scope)
+		subjects = append(subjects, key.Subject)
+	}
+
+	var lockedUntil *time.Time
+	if err := r.pool.QueryRow(ctx, getLoginLockQuery, scopes, subjects).Scan(&lockedUntil); err != nil {
+		return nil, errors.Wrap(err, "failed to get login lock")
+	}
+	return lockedUntil, nil
+}
+
+// ResetLoginFailures сбрасывает счётчик и снимает блокировку
+func (r *repository) ResetLoginFailures(ctx context.Context, key LoginFailureKey) error {
+	if _, err := r.pool.Exec(ctx, resetLoginFailuresQuery, key.Scope, key.Subject); err != nil {
+		return errors.Wrap(err, "failed to reset login failures")
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	createOAuthClientQuery = `
+		INSERT INTO oauth_clients (id, name, secret_hash, public_key, redirect_uris, post_logout_redirect_uris, grant_types, scopes, first_party, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5::text[], '{}'), COALESCE($6::text[], '{}'), COALESCE($7::text[], '{}'), COALESCE($8::text[], '{}'), $9, NOW(), NOW())
+		RETURNING created_at, updated_at;
+	`
+
+	getOAuthClientQuery = `
+		SELECT id, name, secret_hash, public_key, redirect_uris, post_logout_redirect_uris, grant_types, scopes, first_party, created_at, updated_at
+		FROM oauth_clients
+		WHERE id = $1;
+	`
+
+	listOAuthClientsQuery = `
+		SELECT id, name, secret_hash, public_key, redirect_uris, post_logout_redirect_uris, grant_types, scopes, first_party, created_at, updated_at
+		FROM oauth_clients
+		ORDER BY created_at;
+	`
+
+	deleteOAuthClientQuery = `
+		DELETE FROM oauth_clients
+		WHERE id = $1;
+	`
+
+	deleteExpiredClientAssertionsQuery = `
+		DELETE FROM oauth_client_assertions
+		WHERE expires_at < NOW();
+	`
+
+	// повторно предъявленный JWT уже записан, и вставка ничего не меняет
+	useClientAssertionQuery = `
+		INSERT INTO oauth_client_assertions (client_id, jti, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (client_id, jti) DO NOTHING;
+	`
+
+	// использованные коды хранятся ещё сутки, чтобы распознать их повторное предъявление
+	deleteStaleOAuthCodesQuery = `
+		DELETE FROM oauth_authorization_codes
+		WHERE expires_at < NOW() - INTERVAL '1 day';
+	`
+
+	createOAuthCodeQuery = `
+		INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, amr, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::text[], '{}'), NOW(), $11);
+	`
+
+	getOAuthCodeQuery = `
+		SELECT code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, amr, created_at, expires_at, used_at, session_id
+		FROM oauth_authorization_codes
+		WHERE code_hash = $1;
+	`
+
+	useOAuthCodeQuery = `
+		UPDATE oauth_authorization_codes
+		SET used_at = NOW(), session_id = $2
+		WHERE code_hash = $1 AND client_id = $3 AND used_at IS NULL
+		RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, amr, created_at, expires_at, used_at, session_id;
+	`
+
+	getOAuthConsentQuery = `
+		SELECT c.user_id, c.client_id, cl.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients cl ON cl.id = c.client_id
+		WHERE c.user_id = $1 AND c.client_id = $2;
+	`
+
+	listOAuthConsentsQuery = `
+		SELECT c.user_id, c.client_id, cl.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients cl ON cl.id = c.client_id
+		WHERE c.user_id = $1
+		ORDER BY c.updated_at DESC;
+	`
+
+	// новое согласие дополняет прежнее
+	saveOAuthConsentQuery = `
+		INSERT INTO oauth_consents (user_id, client_id, scopes, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (user_id, client_id) DO UPDATE
+		SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes) ORDER BY 1),
+		    updated_at = NOW();
+	`
+
+	deleteOAuthConsentQuery = `
+		DELETE FROM oauth_consents
+		WHERE user_id = $1 AND client_id = $2;
+	`
+)
+
+func (r *repository) CreateOAuthClient(ctx context.Context, client *OAuthClient) error {
+	err := r.pool.QueryRow(ctx, createOAuthClientQuery,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		client.PublicKey,
+		client.RedirectURIs,
+		client.PostLogoutRedirectURIs,
+		client.GrantTypes,
+		client.Scopes,
+		client.FirstParty,
+	).Scan(&client.CreatedAt, &client.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert oauth client")
+	}
+	return nil
+}
+
+func (r *repository) GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client, err := scanOAuthClient(r.pool.QueryRow(ctx, getOAuthClientQuery, clientID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get oauth client")
+	}
+	return client, nil
+}
+
+func (r *repository) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	rows, err := r.pool.Query(ctx, listOAuthClientsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list oauth clients")
+	}
+	defer rows.Close()
+
+	var clients []OAuthClient
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan oauth client")
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read oauth clients")
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient удаляет клиента вместе с его кодами и согласиями и отзывает открытые для него сессии
+func (r *repository) DeleteOAuthClient(ctx context.Context, clientID string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := revokeClientSessions(ctx, tx, RevokeClientSessionsParams{ClientID: clientID}); err != nil {
+		return false, err
+	}
+
+	tag, err := tx.Exec(ctx, deleteOAuthClientQuery, clientID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete oauth client")
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, errors.Wrap(err, "failed to commit transaction")
+	}
+	return true, nil
+}
+
+// UseClientAssertion запоминает jti подписанного JWT клиента, false - JWT уже предъявлялся
+func (r *repository) UseClientAssertion(ctx context.Context, params UseClientAssertionParams) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, deleteExpiredClientAssertionsQuery); err != nil {
+		return false, errors.Wrap(err, "failed to delete expired client assertions")
+	}
+
+	tag, err := tx.Exec(ctx, useClientAssertionQuery, params.ClientID, params.TokenID, params.ExpiresAt)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to insert client assertion")
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, errors.Wrap(err, "failed to commit transaction")
+	}
+	return true, nil
+}
+
+func (r *repository) CreateOAuthCode(ctx context.Context, code OAuthCode) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, deleteStaleOAuthCodesQuery); err != nil {
+		return errors.Wrap(err, "failed to delete stale oauth codes")
+	}
+
+	_, err = tx.Exec(ctx, createOAuthCodeQuery,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.CodeChallenge,
+		code.CodeChallengeMethod,
+		code.Nonce,
+		code.AuthTime,
+		code.Amr,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert oauth code")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// GetOAuthCode ищет код, в том числе использованный и истёкший
+func (r *repository) GetOAuthCode(ctx context.Context, codeHash string) (*OAuthCode, error) {
+	code, err := scanOAuthCode(r.pool.QueryRow(ctx, getOAuthCodeQuery, codeHash))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get oauth code")
+	}
+	return code, nil
+}
+
+// UseOAuthCode атомарно гасит код и запоминает открываемую по нему сессию, для неизвестного,
+// уже использованного или выданного другому клиенту кода - pgx.ErrNoRows. Срок действия проверяет вызывающий
+func (r *repository) UseOAuthCode(ctx context.Context, params UseOAuthCodeParams) (*OAuthCode, error) {
+	code, err := scanOAuthCode(r.pool.QueryRow(ctx, useOAuthCodeQuery, params.CodeHash, params.SessionID, params.ClientID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to use oauth code")
+	}
+	return code, nil
+}
+
+func (r *repository) GetOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string) (*OAuthConsent, error) {
+	consent, err := scanOAuthConsent(r.pool.QueryRow(ctx, getOAuthConsentQuery, userID, clientID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get oauth consent")
+	}
+	return consent, nil
+}
+
+func (r *repository) ListOAuthConsents(ctx context.Context, userID uuid.UUID) ([]OAuthConsent, error) {
+	rows, err := r.pool.Query(ctx, listOAuthConsentsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list oauth consents")
+	}
+	defer rows.Close()
+
+	var consents []OAuthConsent
+	for rows.Next() {
+		consent, err := scanOAuthConsent(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan oauth consent")
+		}
+		consents = append(consents, *consent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read oauth consents")
+	}
+	return consents, nil
+}
+
+func (r *repository) SaveOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error {
+	if _, err := r.pool.Exec(ctx, saveOAuthConsentQuery, userID, clientID, scopes); err != nil {
+		return errors.Wrap(err, "failed to save oauth consent")
+	}
+	return nil
+}
+
+// DeleteOAuthConsent отзывает согласие и сессии, открытые по нему для клиента
+func (r *repository) DeleteOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, deleteOAuthConsentQuery, userID, clientID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete oauth consent")
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := revokeClientSessions(ctx, tx, RevokeClientSessionsParams{UserID: &userID, ClientID: clientID}); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, errors.Wrap(err, "failed to commit transaction")
+	}
+	return true, nil
+}
+
+func scanOAuthClient(row pgx.Row) (*OAuthClient, error) {
+	var client OAuthClient
+	err := row.Scan(
+		&client.ID,
+		&client.Name,
+		&client.SecretHash,
+		&client.PublicKey,
+		&client.RedirectURIs,
+		&client.PostLogoutRedirectURIs,
+		&client.GrantTypes,
+		&client.Scopes,
+		&client.FirstParty,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func scanOAuthCode(row pgx.Row) (*OAuthCode, error) {
+	var code OAuthCode
+	err := row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.CodeChallenge,
+		&code.CodeChallengeMethod,
+		&code.Nonce,
+		&code.AuthTime,
+		&code.Amr,
+		&code.CreatedAt,
+		&code.ExpiresAt,
+		&code.UsedAt,
+		&code.SessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func scanOAuthConsent(row pgx.Row) (*OAuthConsent, error) {
+	var consent OAuthConsent
+	err := row.Scan(
+		&consent.UserID,
+		&consent.ClientID,
+		&consent.ClientName,
+		&consent.Scopes,
+		&consent.CreatedAt,
+		&consent.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
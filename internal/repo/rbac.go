@@ -0,0 +1,246 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// AllResources - назначение роли на все ресурсы
+const AllResources = "*"
+
+const (
+	createRoleQuery = `
+		INSERT INTO roles (name, description, created_at)
+		VALUES ($1, $2, NOW())
+		RETURNING id;
+	`
+
+	deleteRoleQuery = `
+		DELETE FROM roles
+		WHERE name = $1;
+	`
+
+	listRolesQuery = `
+		SELECT r.id, r.name, r.description, r.created_at,
+		       COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.name;
+	`
+
+	getRoleIDQuery = `
+		SELECT id
+		FROM roles
+		WHERE name = $1;
+	`
+
+	upsertPermissionQuery = `
+		INSERT INTO permissions (name)
+		VALUES ($1)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id;
+	`
+
+	grantPermissionQuery = `
+		INSERT INTO role_permissions (role_id, permission_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING;
+	`
+
+	revokePermissionQuery = `
+		DELETE FROM role_permissions rp
+		USING roles r, permissions p
+		WHERE rp.role_id = r.id AND rp.permission_id = p.id AND r.name = $1 AND p.name = $2;
+	`
+
+	assignRoleQuery = `
+		INSERT INTO user_roles (user_id, role_id, resource, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT DO NOTHING;
+	`
+
+	unassignRoleQuery = `
+		DELETE FROM user_roles ur
+		USING roles r
+		WHERE ur.role_id = r.id AND ur.user_id = $1 AND r.name = $2 AND ur.resource = $3;
+	`
+
+	hasPermissionQuery = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_roles ur
+			JOIN role_permissions rp ON rp.role_id = ur.role_id
+			JOIN permissions p ON p.id = rp.permission_id
+			WHERE ur.user_id = $1 AND p.name = $2 AND (ur.resource = '*' OR ur.resource = $3)
+		);
+	`
+
+	getUserRolesQuery = `
+		SELECT DISTINCT r.name
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1 AND ur.resource = '*'
+		ORDER BY r.name;
+	`
+
+	getUserPermissionsQuery = `
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1 AND ur.resource = '*'
+		ORDER BY p.name;
+	`
+)
+
+func (r *repository) CreateRole(ctx context.Context, role *Role) (int64, error) {
+	var id int64
+	err := r.pool.QueryRow(ctx, createRoleQuery, role.Name, role.Description).Scan(&id)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insert role")
+	}
+	return id, nil
+}
+
+// DeleteRole удаляет роль вместе с её правами и назначениями, возвращает false если роли нет
+func (r *repository) DeleteRole(ctx context.Context, name string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, deleteRoleQuery, name)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete role")
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *repository) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := r.pool.Query(ctx, listRolesQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list roles")
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.Permissions); err != nil {
+			return nil, errors.Wrap(err, "failed to scan role")
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read roles")
+	}
+	return roles, nil
+}
+
+// GrantPermission добавляет право роли, создавая право при необходимости. Возвращает false если роли нет
+func (r *repository) GrantPermission(ctx context.Context, params RolePermissionParams) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	var roleID int64
+	if err := tx.QueryRow(ctx, getRoleIDQuery, params.RoleName).Scan(&roleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to get role")
+	}
+
+	var permissionID int64
+	if err := tx.QueryRow(ctx, upsertPermissionQuery, params.Permission).Scan(&permissionID); err != nil {
+		return false, errors.Wrap(err, "failed to upsert permission")
+	}
+
+	if _, err := tx.Exec(ctx, grantPermissionQuery, roleID, permissionID); err != nil {
+		return false, errors.Wrap(err, "failed to grant permission")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, errors.Wrap(err, "failed to commit transaction")
+	}
+	return true, nil
+}
+
+func (r *repository) RevokePermission(ctx context.Context, params RolePermissionParams) error {
+	_, err := r.pool.Exec(ctx, revokePermissionQuery, params.RoleName, params.Permission)
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke permission")
+	}
+	return nil
+}
+
+// AssignRole назначает роль пользователю, возвращает false если роли нет
+func (r *repository) AssignRole(ctx context.Context, params UserRoleParams) (bool, error) {
+	var roleID int64
+	if err := r.pool.QueryRow(ctx, getRoleIDQuery, params.RoleName).Scan(&roleID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to get role")
+	}
+
+	if _, err := r.pool.Exec(ctx, assignRoleQuery, params.UserID, roleID, params.Resource); err != nil {
+		return false, errors.Wrap(err, "failed to assign role")
+	}
+	return true, nil
+}
+
+func (r *repository) UnassignRole(ctx context.Context, params UserRoleParams) error {
+	_, err := r.pool.Exec(ctx, unassignRoleQuery, params.UserID, params.RoleName, params.Resource)
+	if err != nil {
+		return errors.Wrap(err, "failed to unassign role")
+	}
+	return nil
+}
+
+// HasPermission проверяет право пользователя на ресурс с учётом назначений на все ресурсы
+func (r *repository) HasPermission(ctx context.Context, params HasPermissionParams) (bool, error) {
+	var allowed bool
+	err := r.pool.QueryRow(ctx, hasPermissionQuery, params.UserID, params.Permission, params.Resource).Scan(&allowed)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check permission")
+	}
+	return allowed, nil
+}
+
+func (r *repository) GetUserAuthorization(ctx context.Context, userID uuid.UUID) (*UserAuthorization, error) {
+	roles, err := r.queryStrings(ctx, getUserRolesQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user roles")
+	}
+
+	permissions, err := r.queryStrings(ctx, getUserPermissionsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user permissions")
+	}
+
+	return &UserAuthorization{
+		Roles:       roles,
+		Permissions: permissions,
+	}, nil
+}
+
+func (r *repository) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
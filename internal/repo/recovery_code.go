@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	deleteRecoveryCodesQuery = `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL;
+	`
+
+	insertRecoveryCodeQuery = `
+		INSERT INTO recovery_codes (user_id, code_hash, created_at)
+		VALUES ($1, $2, NOW());
+	`
+
+	getUnusedRecoveryCodesQuery = `
+		SELECT id, user_id, code_hash, created_at
+		FROM recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL;
+	`
+
+	useRecoveryCodeQuery = `
+		UPDATE recovery_codes
+		SET used_at = NOW(), used_ip = $2, used_user_agent = $3
+		WHERE id = $1 AND used_at IS NULL;
+	`
+)
+
+// ReplaceRecoveryCodes заменяет неиспользованные коды пользователя новым набором,
+// использованные остаются как история
+func (r *repository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		return errors.Wrap(err, "failed to delete recovery codes")
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(ctx, insertRecoveryCodeQuery, userID, hash); err != nil {
+			return errors.Wrap(err, "failed to insert recovery code")
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+func (r *repository) GetUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]RecoveryCode, error) {
+	rows, err := r.pool.Query(ctx, getUnusedRecoveryCodesQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get recovery codes")
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.CodeHash, &code.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to scan recovery code")
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read recovery codes")
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode отмечает код использованным, возвращает false если его уже использовали
+func (r *repository) UseRecoveryCode(ctx context.Context, params UseRecoveryCodeParams) (bool, error) {
+	tag, err := r.pool.Exec(ctx, useRecoveryCodeQuery, params.ID, params.IP, params.UserAgent)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use recovery code")
+	}
+	return tag.RowsAffected() > 0, nil
+}
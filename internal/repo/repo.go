@@ -3,15 +3,20 @@ package repo
 import (
 	"context"
 	"fmt"
-	"github.com/jackc/pgx/v5"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
 
 	"github.com/google/uuid"
 	"newservice/internal/config"
+	"newservice/pkg/jwt"
 )
 
+// ErrRefreshTokenReused - предъявленный refresh токен уже был обменян на новый
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
 type repository struct {
 	pool *pgxpool.Pool
 }
@@ -20,14 +25,93 @@ type Repository interface {
 	// методы работы с пользователями
 	CreateUser(ctx context.Context, user *User) (uuid.UUID, error)
 	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	GetPassword(ctx context.Context, userID uuid.UUID) (string, error)
+	UpdatePassword(ctx context.Context, params UpdatePasswordParams) error
+	GetPasswordHistory(ctx context.Context, userID uuid.UUID, limit int) ([]string, error)
+	UpdateProfile(ctx context.Context, params UpdateProfileParams) (*User, error)
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string) (bool, error)
+	ChangeEmail(ctx context.Context, userID uuid.UUID, email string) error
+
+	// методы работы с одноразовыми токенами из писем
+	CreateUserToken(ctx context.Context, params NewUserTokenParams) error
+	GetUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error)
+	UseUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error)
+	DeleteUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error
 
 	// методы работы с токенами
-	NewRefreshToken(ctx context.Context, params NewRefreshTokenParams) (int64, error)
-	DeleteRefreshToken(ctx context.Context, params DeleteRefreshTokenParams) error
-	GetRefreshToken(ctx context.Context, params GetRefreshTokenParams) ([]string, error)
-	UpdateRefreshToken(ctx context.Context, params UpdateRefreshTokenParams) error
 	NewAuthToken(ctx context.Context, params NewAuthTokenParams) error
+	GetAuthTokenByRefresh(ctx context.Context, refreshTokenHash string) (*AuthToken, error)
+	RotateRefreshToken(ctx context.Context, params RotateRefreshTokenParams) error
+
+	// методы работы с сессиями
+	CreateSession(ctx context.Context, params NewSessionParams) error
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*Session, error)
+	ListSessions(ctx context.Context, userID uuid.UUID) ([]Session, error)
+	RevokeSession(ctx context.Context, params RevokeSessionParams) (bool, error)
+	RevokeUserSessions(ctx context.Context, params RevokeUserSessionsParams) error
+	RevokeClientSessions(ctx context.Context, params RevokeClientSessionsParams) error
+
+	// методы работы с клиентами OAuth 2.0, кодами авторизации и согласиями пользователей
+	CreateOAuthClient(ctx context.Context, client *OAuthClient) error
+	GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	ListOAuthClients(ctx context.Context) ([]OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, clientID string) (bool, error)
+	UseClientAssertion(ctx context.Context, params UseClientAssertionParams) (bool, error)
+	CreateOAuthCode(ctx context.Context, code OAuthCode) error
+	GetOAuthCode(ctx context.Context, codeHash string) (*OAuthCode, error)
+	UseOAuthCode(ctx context.Context, params UseOAuthCodeParams) (*OAuthCode, error)
+	GetOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string) (*OAuthConsent, error)
+	ListOAuthConsents(ctx context.Context, userID uuid.UUID) ([]OAuthConsent, error)
+	SaveOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error
+	DeleteOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string) (bool, error)
+
+	// методы работы с denylist отозванных access токенов
+	RevokeToken(ctx context.Context, token RevokedToken) error
+	GetRevokedTokens(ctx context.Context, since time.Time) ([]RevokedToken, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) error
+
+	// методы работы со вторым фактором
+	SaveTotpSecret(ctx context.Context, userID uuid.UUID, secret []byte) (bool, error)
+	GetTotp(ctx context.Context, userID uuid.UUID) (*UserTotp, error)
+	ConfirmTotp(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	UseTotpStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	GetUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, params UseRecoveryCodeParams) (bool, error)
+
+	// методы работы со счётчиками неудачных попыток
+	RecordLoginFailure(ctx context.Context, key LoginFailureKey, since time.Time) (int, error)
+	LockLogin(ctx context.Context, key LoginFailureKey, until time.Time) error
+	GetLoginLock(ctx context.Context, keys ...LoginFailureKey) (*time.Time, error)
+	ResetLoginFailures(ctx context.Context, key LoginFailureKey) error
+
+	// методы работы с ключами доступа WebAuthn
+	CreateWebauthnChallenge(ctx context.Context, challenge WebauthnChallenge) error
+	TakeWebauthnChallenge(ctx context.Context, id uuid.UUID, purpose string) (*WebauthnChallenge, error)
+	CreateWebauthnCredential(ctx context.Context, credential WebauthnCredential) error
+	GetWebauthnCredentials(ctx context.Context, userID uuid.UUID) ([]WebauthnCredential, error)
+	UpdateWebauthnCredentialUsage(ctx context.Context, credential WebauthnCredential) error
+
+	// методы работы с ролями и правами
+	CreateRole(ctx context.Context, role *Role) (int64, error)
+	DeleteRole(ctx context.Context, name string) (bool, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	GrantPermission(ctx context.Context, params RolePermissionParams) (bool, error)
+	RevokePermission(ctx context.Context, params RolePermissionParams) error
+	AssignRole(ctx context.Context, params UserRoleParams) (bool, error)
+	UnassignRole(ctx context.Context, params UserRoleParams) error
+	HasPermission(ctx context.Context, params HasPermissionParams) (bool, error)
+	GetUserAuthorization(ctx context.Context, userID uuid.UUID) (*UserAuthorization, error)
+
+	// методы работы с журналом аудита
+	AppendAuditEvents(ctx context.Context, events []*AuditEvent, seal func(prevHash string, event *AuditEvent) string) error
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+	GetAuditEventsAfter(ctx context.Context, afterID int64, limit int) ([]AuditEvent, error)
+
+	// методы работы с ключами подписи
+	GetSigningKeys(ctx context.Context) ([]jwt.Key, error)
 
 	// метод для graceful shutdown
 	Close() error
@@ -41,9 +125,21 @@ const (
 	`
 
 	getUserByUsernameQuery = `
-		SELECT id, username, password_hash, email, created_at, updated_at
+		SELECT id, username, password_hash, email, email_verified_at, first_name, last_name, created_at, updated_at
+		FROM users
+		WHERE lower(username) = lower($1);
+	`
+
+	getUserByIDQuery = `
+		SELECT id, username, password_hash, email, email_verified_at, first_name, last_name, created_at, updated_at
 		FROM users
-		WHERE username = $1;
+		WHERE id = $1;
+	`
+
+	getUserByEmailQuery = `
+		SELECT id, username, password_hash, email, email_verified_at, first_name, last_name, created_at, updated_at
+		FROM users
+		WHERE lower(email) = lower($1);
 	`
 
 	getPasswordQuery = `
@@ -52,27 +148,90 @@ const (
 		WHERE id = $1;
 	`
 
-	insertRefreshTokenQuery = `
-		INSERT INTO auth_tokens (user_id, refresh_token, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
-		RETURNING id;
+	// NULL в параметре оставляет поле без изменений, пустая строка очищает его
+	updateProfileQuery = `
+		UPDATE users
+		SET first_name = CASE WHEN $2::text IS NULL THEN first_name ELSE NULLIF($2, '') END,
+		    last_name = CASE WHEN $3::text IS NULL THEN last_name ELSE NULLIF($3, '') END,
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING id, username, password_hash, email, email_verified_at, first_name, last_name, created_at, updated_at;
+	`
+
+	markEmailVerifiedQuery = `
+		UPDATE users
+		SET email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email = $2;
+	`
+
+	// адрес, на который пришла ссылка, подтверждён самим переходом по ней
+	changeEmailQuery = `
+		UPDATE users
+		SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1;
+	`
+
+	// старый хэш переносится в историю
+	archivePasswordQuery = `
+		INSERT INTO password_history (user_id, password_hash, created_at)
+		SELECT id, password_hash, NOW()
+		FROM users
+		WHERE id = $1;
+	`
+
+	updatePasswordQuery = `
+		UPDATE users
+		SET password_hash = $2, updated_at = NOW()
+		WHERE id = $1;
+	`
+
+	trimPasswordHistoryQuery = `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id
+			FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		);
+	`
+
+	getPasswordHistoryQuery = `
+		SELECT password_hash
+		FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2;
 	`
 
-	deleteRefreshTokenQuery = `
-		DELETE FROM auth_tokens
-		WHERE user_id = $1;
+	insertAuthTokenQuery = `
+		INSERT INTO auth_tokens (user_id, session_id, access_jti, refresh_token_hash, created_at, updated_at, access_expires_at, refresh_expires_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW(), $5, $6);
 	`
 
-	getRefreshTokenQuery = `
-		SELECT refresh_token
-		FROM auth_tokens
-		WHERE user_id = $1;
+	getAuthTokenByRefreshQuery = `
+		SELECT t.id, t.user_id, t.session_id, s.client_id, s.scope, t.refresh_expires_at, t.created_at, t.consumed_at, t.revoked_at
+		FROM auth_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.refresh_token_hash = $1;
 	`
 
-	updateRefreshTokenQuery = `
+	consumeRefreshTokenQuery = `
 		UPDATE auth_tokens
-		SET refresh_token = $1, updated_at = NOW()
-		WHERE user_id = $2;
+		SET consumed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND consumed_at IS NULL AND revoked_at IS NULL;
+	`
+
+	touchSessionQuery = `
+		UPDATE sessions
+		SET last_used_at = NOW(), ip = $2, user_agent = $3
+		WHERE id = $1;
+	`
+
+	getSigningKeysQuery = `
+		SELECT private_key, activate_at
+		FROM signing_keys
+		WHERE retired_at IS NULL OR retired_at > NOW();
 	`
 )
 
@@ -116,19 +275,27 @@ func (r *repository) CreateUser(ctx context.Context, user *User) (uuid.UUID, err
 }
 
 func (r *repository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
-	var user User
-	err := r.pool.QueryRow(ctx, getUserByUsernameQuery, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.HashedPassword,
-		&user.Email,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := scanUser(r.pool.QueryRow(ctx, getUserByUsernameQuery, username))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get user by username")
 	}
-	return &user, nil
+	return user, nil
+}
+
+func (r *repository) GetUserByID(ctx context.Context, userID uuid.UUID) (*User, error) {
+	user, err := scanUser(r.pool.QueryRow(ctx, getUserByIDQuery, userID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user by id")
+	}
+	return user, nil
+}
+
+func (r *repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := scanUser(r.pool.QueryRow(ctx, getUserByEmailQuery, email))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user by email")
+	}
+	return user, nil
 }
 
 func (r *repository) GetPassword(ctx context.Context, userID uuid.UUID) (string, error) {
@@ -140,60 +307,188 @@ func (r *repository) GetPassword(ctx context.Context, userID uuid.UUID) (string,
 	return password, nil
 }
 
-func (r *repository) NewRefreshToken(ctx context.Context, params NewRefreshTokenParams) (int64, error) {
-	var id int64
-	err := r.pool.QueryRow(ctx, insertRefreshTokenQuery, params.UserID, params.Token).Scan(&id)
+// UpdatePassword меняет пароль, сохраняя прежний хэш в истории из HistorySize последних паролей
+func (r *repository) UpdatePassword(ctx context.Context, params UpdatePasswordParams) error {
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		return 0, errors.Wrap(err, "failed to insert refresh token")
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if params.HistorySize > 0 {
+		if _, err := tx.Exec(ctx, archivePasswordQuery, params.UserID); err != nil {
+			return errors.Wrap(err, "failed to archive password")
+		}
 	}
-	return id, nil
-}
 
-func (r *repository) DeleteRefreshToken(ctx context.Context, params DeleteRefreshTokenParams) error {
-	_, err := r.pool.Exec(ctx, deleteRefreshTokenQuery, params.UserID)
+	tag, err := tx.Exec(ctx, updatePasswordQuery, params.UserID, params.PasswordHash)
 	if err != nil {
-		return errors.Wrap(err, "failed to delete refresh token")
+		return errors.Wrap(err, "failed to update password")
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.Wrap(pgx.ErrNoRows, "failed to update password")
+	}
+
+	if _, err := tx.Exec(ctx, trimPasswordHistoryQuery, params.UserID, params.HistorySize); err != nil {
+		return errors.Wrap(err, "failed to trim password history")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
 	}
 	return nil
 }
 
-func (r *repository) GetRefreshToken(ctx context.Context, params GetRefreshTokenParams) ([]string, error) {
-	rows, err := r.pool.Query(ctx, getRefreshTokenQuery, params.UserID)
+// GetPasswordHistory возвращает хэши последних limit прежних паролей, начиная с самого свежего
+func (r *repository) GetPasswordHistory(ctx context.Context, userID uuid.UUID, limit int) ([]string, error) {
+	hashes, err := r.queryStrings(ctx, getPasswordHistoryQuery, userID, limit)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get refresh token")
+		return nil, errors.Wrap(err, "failed to get password history")
 	}
-	defer rows.Close()
+	return hashes, nil
+}
 
-	var tokens []string
-	for rows.Next() {
-		var token string
-		if err := rows.Scan(&token); err != nil {
-			return nil, errors.Wrap(err, "failed to scan refresh token")
-		}
-		tokens = append(tokens, token)
+// UpdateProfile меняет переданные поля профиля и возвращает обновлённого пользователя
+func (r *repository) UpdateProfile(ctx context.Context, params UpdateProfileParams) (*User, error) {
+	user, err := scanUser(r.pool.QueryRow(ctx, updateProfileQuery, params.UserID, params.FirstName, params.LastName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update profile")
+	}
+	return user, nil
+}
+
+// MarkEmailVerified подтверждает адрес, если он не сменился с момента отправки письма
+func (r *repository) MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, markEmailVerifiedQuery, userID, email)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to mark email verified")
 	}
-	return tokens, nil
+	return tag.RowsAffected() > 0, nil
 }
 
-func (r *repository) UpdateRefreshToken(ctx context.Context, params UpdateRefreshTokenParams) error {
-	_, err := r.pool.Exec(ctx, updateRefreshTokenQuery, params.Token, params.UserID)
+// ChangeEmail меняет адрес пользователя на подтверждённый, занятый адрес - ошибка unique_violation
+func (r *repository) ChangeEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	tag, err := r.pool.Exec(ctx, changeEmailQuery, userID, email)
 	if err != nil {
-		return errors.Wrap(err, "failed to update refresh token")
+		return errors.Wrap(err, "failed to change email")
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.Wrap(pgx.ErrNoRows, "failed to change email")
 	}
 	return nil
 }
 
 func (r *repository) NewAuthToken(ctx context.Context, params NewAuthTokenParams) error {
-	_, err := r.pool.Exec(ctx, `
-		INSERT INTO auth_tokens (user_id, access_token, refresh_token, created_at, updated_at, access_expires_at, refresh_expires_at)
-		VALUES ($1, $2, $3, NOW(), NOW(), NOW() + INTERVAL '1 hour', $4)`,
-		params.UserID, params.Tokens.AccessToken, params.Tokens.RefreshToken, params.RefreshExpiresAt)
+	_, err := r.pool.Exec(ctx, insertAuthTokenQuery,
+		params.UserID, params.SessionID, params.AccessTokenID, params.RefreshTokenHash, params.AccessExpiresAt, params.RefreshExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert auth tokens")
+	}
+	return nil
+}
+
+func (r *repository) GetAuthTokenByRefresh(ctx context.Context, refreshTokenHash string) (*AuthToken, error) {
+	var token AuthToken
+	err := r.pool.QueryRow(ctx, getAuthTokenByRefreshQuery, refreshTokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.SessionID,
+		&token.ClientID,
+		&token.Scope,
+		&token.RefreshExpiresAt,
+		&token.CreatedAt,
+		&token.ConsumedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get auth token by refresh token")
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken помечает предъявленный токен использованным и сохраняет новый в той же сессии.
+// Если токен уже был использован (в том числе параллельным запросом), возвращает ErrRefreshTokenReused
+func (r *repository) RotateRefreshToken(ctx context.Context, params RotateRefreshTokenParams) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, consumeRefreshTokenQuery, params.ConsumedID)
+	if err != nil {
+		return errors.Wrap(err, "failed to consume refresh token")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(ctx, insertAuthTokenQuery,
+		params.NewToken.UserID, params.NewToken.SessionID, params.NewToken.AccessTokenID,
+		params.NewToken.RefreshTokenHash, params.NewToken.AccessExpiresAt, params.NewToken.RefreshExpiresAt)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert auth tokens")
 	}
+
+	_, err = tx.Exec(ctx, touchSessionQuery, params.NewToken.SessionID, params.IP, params.UserAgent)
+	if err != nil {
+		return errors.Wrap(err, "failed to update session")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
 	return nil
 }
 
+func (r *repository) GetSigningKeys(ctx context.Context) ([]jwt.Key, error) {
+	rows, err := r.pool.Query(ctx, getSigningKeysQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get signing keys")
+	}
+	defer rows.Close()
+
+	var keys []jwt.Key
+	for rows.Next() {
+		var (
+			privateKeyPEM string
+			activateAt    time.Time
+		)
+		if err := rows.Scan(&privateKeyPEM, &activateAt); err != nil {
+			return nil, errors.Wrap(err, "failed to scan signing key")
+		}
+
+		privateKey, err := jwt.ParsePrivateKeyPEM([]byte(privateKeyPEM))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse signing key")
+		}
+		keys = append(keys, jwt.NewKey(privateKey, activateAt))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read signing keys")
+	}
+	return keys, nil
+}
+
+func scanUser(row pgx.Row) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.HashedPassword,
+		&user.Email,
+		&user.EmailVerifiedAt,
+		&user.FirstName,
+		&user.LastName,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // Close gracefully shuts down the database connection pool
 func (r *repository) Close() error {
 	if r.pool != nil {
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	revokeTokenQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	getRevokedTokensQuery = `
+		SELECT jti, expires_at, created_at
+		FROM revoked_tokens
+		WHERE created_at >= $1 AND expires_at > NOW();
+	`
+
+	deleteExpiredRevokedTokensQuery = `
+		DELETE FROM revoked_tokens
+		WHERE expires_at <= NOW();
+	`
+)
+
+func (r *repository) RevokeToken(ctx context.Context, token RevokedToken) error {
+	_, err := r.pool.Exec(ctx, revokeTokenQuery, token.TokenID, token.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke token")
+	}
+	return nil
+}
+
+// GetRevokedTokens возвращает ещё не истёкшие записи denylist, добавленные начиная с since
+func (r *repository) GetRevokedTokens(ctx context.Context, since time.Time) ([]RevokedToken, error) {
+	rows, err := r.pool.Query(ctx, getRevokedTokensQuery, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get revoked tokens")
+	}
+	defer rows.Close()
+
+	var tokens []RevokedToken
+	for rows.Next() {
+		var token RevokedToken
+		if err := rows.Scan(&token.TokenID, &token.ExpiresAt, &token.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to scan revoked token")
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read revoked tokens")
+	}
+	return tokens, nil
+}
+
+func (r *repository) DeleteExpiredRevokedTokens(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, deleteExpiredRevokedTokensQuery)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete expired revoked tokens")
+	}
+	return nil
+}
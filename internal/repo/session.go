@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	createSessionQuery = `
+		INSERT INTO sessions (id, user_id, client_id, device_name, ip, user_agent, scope, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW());
+	`
+
+	getSessionQuery = `
+		SELECT id, user_id, client_id, device_name, ip, user_agent, created_at, last_used_at, revoked_at
+		FROM sessions
+		WHERE id = $1;
+	`
+
+	// активные сессии - не отозванные и с ещё живым refresh токеном
+	listSessionsQuery = `
+		SELECT s.id, s.user_id, s.client_id, s.device_name, s.ip, s.user_agent, s.created_at, s.last_used_at, s.revoked_at
+		FROM sessions s
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL AND EXISTS (
+			SELECT 1
+			FROM auth_tokens t
+			WHERE t.session_id = s.id AND t.consumed_at IS NULL AND t.revoked_at IS NULL AND t.refresh_expires_at > NOW()
+		)
+		ORDER BY s.last_used_at DESC;
+	`
+
+	revokeSessionQuery = `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+	`
+
+	// отзываем ещё живые access токены сессии через denylist
+	revokeSessionAccessTokensQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at, created_at)
+		SELECT access_jti, access_expires_at, NOW()
+		FROM auth_tokens
+		WHERE session_id = $1 AND access_jti IS NOT NULL AND access_expires_at > NOW()
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	revokeSessionTokensQuery = `
+		UPDATE auth_tokens
+		SET revoked_at = NOW(), updated_at = NOW()
+		WHERE session_id = $1 AND revoked_at IS NULL;
+	`
+
+	revokeUserSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL;
+	`
+
+	revokeUserSessionAccessTokensQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at, created_at)
+		SELECT access_jti, access_expires_at, NOW()
+		FROM auth_tokens
+		WHERE user_id = $1 AND session_id <> $2 AND access_jti IS NOT NULL AND access_expires_at > NOW()
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	revokeUserSessionTokensQuery = `
+		UPDATE auth_tokens
+		SET revoked_at = NOW(), updated_at = NOW()
+		WHERE user_id = $1 AND session_id <> $2 AND revoked_at IS NULL;
+	`
+
+	// токены отзываются раньше сессий: после отзыва сессии по revoked_at их уже не найти
+	revokeClientSessionAccessTokensQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at, created_at)
+		SELECT t.access_jti, t.access_expires_at, NOW()
+		FROM auth_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE s.client_id = $2 AND ($1::uuid IS NULL OR s.user_id = $1) AND s.revoked_at IS NULL
+		  AND t.access_jti IS NOT NULL AND t.access_expires_at > NOW()
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	revokeClientSessionTokensQuery = `
+		UPDATE auth_tokens t
+		SET revoked_at = NOW(), updated_at = NOW()
+		FROM sessions s
+		WHERE s.id = t.session_id AND s.client_id = $2 AND ($1::uuid IS NULL OR s.user_id = $1)
+		  AND s.revoked_at IS NULL AND t.revoked_at IS NULL;
+	`
+
+	revokeClientSessionsQuery = `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE client_id = $2 AND ($1::uuid IS NULL OR user_id = $1) AND revoked_at IS NULL;
+	`
+)
+
+func (r *repository) CreateSession(ctx context.Context, params NewSessionParams) error {
+	_, err := r.pool.Exec(ctx, createSessionQuery, params.ID, params.UserID, params.ClientID, params.DeviceName, params.IP, params.UserAgent, params.Scope)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert session")
+	}
+	return nil
+}
+
+func (r *repository) GetSession(ctx context.Context, sessionID uuid.UUID) (*Session, error) {
+	var session Session
+	err := r.pool.QueryRow(ctx, getSessionQuery, sessionID).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.ClientID,
+		&session.DeviceName,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get session")
+	}
+	return &session, nil
+}
+
+func (r *repository) ListSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	rows, err := r.pool.Query(ctx, listSessionsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list sessions")
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.ClientID,
+			&session.DeviceName,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.RevokedAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to scan session")
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSession отзывает сессию пользователя вместе с её токенами, живые access токены попадают в denylist.
+// Возвращает false, если у пользователя нет такой активной сессии
+func (r *repository) RevokeSession(ctx context.Context, params RevokeSessionParams) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, revokeSessionQuery, params.SessionID, params.UserID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to revoke session")
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, revokeSessionAccessTokensQuery, params.SessionID); err != nil {
+		return false, errors.Wrap(err, "failed to revoke session access tokens")
+	}
+
+	if _, err := tx.Exec(ctx, revokeSessionTokensQuery, params.SessionID); err != nil {
+		return false, errors.Wrap(err, "failed to revoke session tokens")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, errors.Wrap(err, "failed to commit transaction")
+	}
+	return true, nil
+}
+
+// RevokeUserSessions отзывает все сессии пользователя, кроме ExceptSessionID
+func (r *repository) RevokeUserSessions(ctx context.Context, params RevokeUserSessionsParams) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, revokeUserSessionsQuery, params.UserID, params.ExceptSessionID); err != nil {
+		return errors.Wrap(err, "failed to revoke sessions")
+	}
+
+	if _, err := tx.Exec(ctx, revokeUserSessionAccessTokensQuery, params.UserID, params.ExceptSessionID); err != nil {
+		return errors.Wrap(err, "failed to revoke session access tokens")
+	}
+
+	if _, err := tx.Exec(ctx, revokeUserSessionTokensQuery, params.UserID, params.ExceptSessionID); err != nil {
+		return errors.Wrap(err, "failed to revoke session tokens")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// RevokeClientSessions отзывает сессии, открытые для клиента OAuth
+func (r *repository) RevokeClientSessions(ctx context.Context, params RevokeClientSessionsParams) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := revokeClientSessions(ctx, tx, params); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+func revokeClientSessions(ctx context.Context, tx pgx.Tx, params RevokeClientSessionsParams) error {
+	if _, err := tx.Exec(ctx, revokeClientSessionAccessTokensQuery, params.UserID, params.ClientID); err != nil {
+		return errors.Wrap(err, "failed to revoke session access tokens")
+	}
+
+	if _, err := tx.Exec(ctx, revokeClientSessionTokensQuery, params.UserID, params.ClientID); err != nil {
+		return errors.Wrap(err, "failed to revoke session tokens")
+	}
+
+	if _, err := tx.Exec(ctx, revokeClientSessionsQuery, params.UserID, params.ClientID); err != nil {
+		return errors.Wrap(err, "failed to revoke sessions")
+	}
+	return nil
+}
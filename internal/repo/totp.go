@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// неподтверждённый секрет перезаписывается при повторном начале подключения
+	saveTotpSecretQuery = `
+		INSERT INTO user_totp (user_id, secret, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE user_totp.confirmed_at IS NULL;
+	`
+
+	getTotpQuery = `
+		SELECT user_id, secret, confirmed_at, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1;
+	`
+
+	confirmTotpQuery = `
+		UPDATE user_totp
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL;
+	`
+
+	useTotpStepQuery = `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2;
+	`
+)
+
+// SaveTotpSecret сохраняет новый секрет, возвращает false если TOTP уже подключён
+func (r *repository) SaveTotpSecret(ctx context.Context, userID uuid.UUID, secret []byte) (bool, error) {
+	tag, err := r.pool.Exec(ctx, saveTotpSecretQuery, userID, secret)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save totp secret")
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *repository) GetTotp(ctx context.Context, userID uuid.UUID) (*UserTotp, error) {
+	var totp UserTotp
+	err := r.pool.QueryRow(ctx, getTotpQuery, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.ConfirmedAt,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get totp")
+	}
+	return &totp, nil
+}
+
+// ConfirmTotp включает TOTP, step - шаг кода, которым подключение подтверждено
+func (r *repository) ConfirmTotp(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, confirmTotpQuery, userID, step)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to confirm totp")
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UseTotpStep атомарно отмечает шаг кода использованным, возвращает false если этот или более поздний шаг уже был
+func (r *repository) UseTotpStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, useTotpStepQuery, userID, step)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use totp step")
+	}
+	return tag.RowsAffected() > 0, nil
+}
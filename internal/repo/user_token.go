@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// назначение одноразовых токенов из писем
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"  // payload - подтверждаемый адрес
+	UserTokenEmailChange       = "email_change"        // payload - новый адрес
+	UserTokenEmailChangeCancel = "email_change_cancel" // payload - прежний адрес
+)
+
+const (
+	// новый токен отменяет ранее выданные неиспользованные токены того же назначения
+	invalidateUserTokensQuery = `
+		DELETE FROM user_tokens
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;
+	`
+
+	createUserTokenQuery = `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, payload, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, NOW(), $5);
+	`
+
+	getUserTokenQuery = `
+		SELECT id, user_id, purpose, payload, created_at, expires_at, used_at
+		FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW();
+	`
+
+	useUserTokenQuery = `
+		UPDATE user_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, purpose, payload, created_at, expires_at, used_at;
+	`
+)
+
+func (r *repository) CreateUserToken(ctx context.Context, params NewUserTokenParams) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if !params.KeepExisting {
+		if _, err := tx.Exec(ctx, invalidateUserTokensQuery, params.UserID, params.Purpose); err != nil {
+			return errors.Wrap(err, "failed to invalidate user tokens")
+		}
+	}
+
+	_, err = tx.Exec(ctx, createUserTokenQuery, params.UserID, params.Purpose, params.TokenHash, params.Payload, params.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert user token")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// DeleteUserTokens отменяет неиспользованные токены пользователя с этим назначением
+func (r *repository) DeleteUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error {
+	if _, err := r.pool.Exec(ctx, invalidateUserTokensQuery, userID, purpose); err != nil {
+		return errors.Wrap(err, "failed to delete user tokens")
+	}
+	return nil
+}
+
+// GetUserToken ищет действующий токен, не используя его
+func (r *repository) GetUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	var token UserToken
+	err := r.pool.QueryRow(ctx, getUserTokenQuery, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.Payload,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user token")
+	}
+	return &token, nil
+}
+
+// UseUserToken атомарно гасит действующий токен, для неизвестного, истёкшего или использованного токена - pgx.ErrNoRows
+func (r *repository) UseUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	var token UserToken
+	err := r.pool.QueryRow(ctx, useUserTokenQuery, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.Payload,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to use user token")
+	}
+	return &token, nil
+}
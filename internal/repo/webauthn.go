@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	deleteExpiredWebauthnChallengesQuery = `
+		DELETE FROM webauthn_challenges
+		WHERE expires_at < NOW();
+	`
+
+	createWebauthnChallengeQuery = `
+		INSERT INTO webauthn_challenges (id, user_id, purpose, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+
+	// challenge одноразовый: забираем его удалением
+	takeWebauthnChallengeQuery = `
+		DELETE FROM webauthn_challenges
+		WHERE id = $1 AND purpose = $2 AND expires_at > NOW()
+		RETURNING id, user_id, purpose, session_data, expires_at;
+	`
+
+	createWebauthnCredentialQuery = `
+		INSERT INTO webauthn_credentials (id, user_id, name, public_key, attestation_type, transports, aaguid, flags, sign_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW());
+	`
+
+	getWebauthnCredentialsQuery = `
+		SELECT id, user_id, name, public_key, attestation_type, transports, aaguid, flags, sign_count, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at;
+	`
+
+	updateWebauthnCredentialUsageQuery = `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, flags = $3, last_used_at = NOW()
+		WHERE id = $1;
+	`
+)
+
+// CreateWebauthnChallenge сохраняет challenge церемонии, попутно удаляя просроченные
+func (r *repository) CreateWebauthnChallenge(ctx context.Context, challenge WebauthnChallenge) error {
+	if _, err := r.pool.Exec(ctx, deleteExpiredWebauthnChallengesQuery); err != nil {
+		return errors.Wrap(err, "failed to delete expired webauthn challenges")
+	}
+
+	_, err := r.pool.Exec(ctx, createWebauthnChallengeQuery,
+		challenge.ID,
+		challenge.UserID,
+		challenge.Purpose,
+		challenge.SessionData,
+		challenge.ExpiresAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webauthn challenge")
+	}
+	return nil
+}
+
+// TakeWebauthnChallenge забирает ещё не истёкший challenge, повторно его получить нельзя
+func (r *repository) TakeWebauthnChallenge(ctx context.Context, id uuid.UUID, purpose string) (*WebauthnChallenge, error) {
+	var challenge WebauthnChallenge
+	err := r.pool.QueryRow(ctx, takeWebauthnChallengeQuery, id, purpose).Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.Purpose,
+		&challenge.SessionData,
+		&challenge.ExpiresAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to take webauthn challenge")
+	}
+	return &challenge, nil
+}
+
+func (r *repository) CreateWebauthnCredential(ctx context.Context, credential WebauthnCredential) error {
+	_, err := r.pool.Exec(ctx, createWebauthnCredentialQuery,
+		credential.ID,
+		credential.UserID,
+		credential.Name,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.Transports,
+		credential.AAGUID,
+		credential.Flags,
+		credential.SignCount,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webauthn credential")
+	}
+	return nil
+}
+
+func (r *repository) GetWebauthnCredentials(ctx context.Context, userID uuid.UUID) ([]WebauthnCredential, error) {
+	rows, err := r.pool.Query(ctx, getWebauthnCredentialsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get webauthn credentials")
+	}
+	defer rows.Close()
+
+	var credentials []WebauthnCredential
+	for rows.Next() {
+		var c WebauthnCredential
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.PublicKey,
+			&c.AttestationType,
+			&c.Transports,
+			&c.AAGUID,
+			&c.Flags,
+			&c.SignCount,
+			&c.CreatedAt,
+			&c.LastUsedAt,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan webauthn credential")
+		}
+		credentials = append(credentials, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read webauthn credentials")
+	}
+	return credentials, nil
+}
+
+// UpdateWebauthnCredentialUsage сохраняет счётчик подписей и флаги после успешного входа
+func (r *repository) UpdateWebauthnCredentialUsage(ctx context.Context, credential WebauthnCredential) error {
+	_, err := r.pool.Exec(ctx, updateWebauthnCredentialUsageQuery, credential.ID, credential.SignCount, credential.Flags)
+	if err != nil {
+		return errors.Wrap(err, "failed to update webauthn credential")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// auditEvent записывает действие в журнал аудита, keysAndValues попадают в details
+func (a *authServer) auditEvent(ctx context.Context, action, outcome string, actorID uuid.UUID, target string, keysAndValues ...interface{}) {
+	details := make(map[string]string, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		details[fmt.Sprint(keysAndValues[i])] = fmt.Sprint(keysAndValues[i+1])
+	}
+
+	a.audit.Record(ctx, audit.Event{
+		ActorID: actorID,
+		Action:  action,
+		Target:  target,
+		Outcome: outcome,
+		Details: details,
+	})
+}
+
+func (a *authServer) ListAuditEvents(
+	ctx context.Context,
+	req *AuthService.ListAuditEventsRequest,
+) (
+	*AuthService.ListAuditEventsResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	filter := repo.AuditEventFilter{
+		Action:  req.Action,
+		Target:  req.Target,
+		Outcome: req.Outcome,
+		Limit:   defaultAuditPageSize,
+	}
+
+	if req.ActorId != "" {
+		actorID, err := uuid.Parse(req.ActorId)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid actor id format")
+		}
+		filter.ActorID = &actorID
+	}
+	if req.Since != nil {
+		since := req.Since.AsTime()
+		filter.Since = &since
+	}
+	if req.Until != nil {
+		until := req.Until.AsTime()
+		filter.Until = &until
+	}
+	if req.PageSize > 0 {
+		filter.Limit = min(int(req.PageSize), maxAuditPageSize)
+	}
+	if req.PageToken != "" {
+		beforeID, err := strconv.ParseInt(req.PageToken, 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		filter.BeforeID = beforeID
+	}
+
+	events, err := a.repo.ListAuditEvents(ctx, filter)
+	if err != nil {
+		a.log.Errorf("list audit events err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	resp := &AuthService.ListAuditEventsResponse{
+		Events: make([]*AuthService.AuditEvent, 0, len(events)),
+	}
+	for _, e := range events {
+		event := &AuthService.AuditEvent{
+			Id:         e.ID,
+			OccurredAt: timestamppb.New(e.OccurredAt),
+			Action:     e.Action,
+			Target:     e.Target,
+			Ip:         e.IP,
+			UserAgent:  e.UserAgent,
+			Outcome:    e.Outcome,
+			RequestId:  e.RequestID,
+			Details:    e.Details,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+		}
+		if e.ActorID != nil {
+			event.ActorId = e.ActorID.String()
+		}
+		resp.Events = append(resp.Events, event)
+	}
+
+	// страница заполнена целиком - возможно, есть следующая
+	if len(events) == filter.Limit {
+		resp.NextPageToken = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+
+	return resp, nil
+}
+
+// VerifyAuditLog проверяет цепочку хэшей журнала аудита целиком
+func (a *authServer) VerifyAuditLog(
+	ctx context.Context,
+	req *AuthService.VerifyAuditLogRequest,
+) (
+	*AuthService.VerifyAuditLogResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	// события этой реплики, которые ещё в очереди, тоже должны попасть в проверку
+	if err := a.audit.Flush(ctx); err != nil {
+		a.log.Errorf("flush audit log err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	brokenID, err := a.audit.Verify(ctx)
+	if err != nil {
+		a.log.Errorf("verify audit log err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if brokenID != 0 {
+		a.log.Warnw("audit log chain is broken", "event_id", brokenID)
+	}
+
+	return &AuthService.VerifyAuditLogResponse{
+		Intact:        brokenID == 0,
+		BrokenEventId: brokenID,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/secure"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requestEmailVerification отправляет письмо со ссылкой подтверждения адреса. Токен привязан
+// к адресу, поэтому после смены почты старая ссылка её не подтвердит
+func (a *authServer) requestEmailVerification(ctx context.Context, user *repo.User) error {
+	token, err := a.newUserToken(ctx, user.ID, repo.UserTokenEmailVerification, user.Email, a.cfg.System.EmailVerificationTimeout)
+	if err != nil {
+		return err
+	}
+	return a.sendEmailVerification(ctx, user.Email, token)
+}
+
+// checkEmailVerified не пускает пользователя с неподтверждённой почтой, если это требуется настройками
+func (a *authServer) checkEmailVerified(user *repo.User) error {
+	if a.cfg.System.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		return status.Error(codes.FailedPrecondition, ErrEmailNotVerified)
+	}
+	return nil
+}
+
+func (a *authServer) VerifyEmail(
+	ctx context.Context,
+	req *AuthService.VerifyEmailRequest,
+) (
+	*AuthService.VerifyEmailResponse, error,
+) {
+	token, err := a.repo.UseUserToken(ctx, repo.UserTokenEmailVerification, secure.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidVerifyToken)
+		}
+		a.log.Errorf("use email verification token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	verified, err := a.repo.MarkEmailVerified(ctx, token.UserID, token.Payload)
+	if err != nil {
+		a.log.Errorf("mark email verified err: user_id = %s: %v", token.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !verified {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidVerifyToken)
+	}
+
+	return &AuthService.VerifyEmailResponse{}, nil
+}
+
+// ResendVerification повторно отправляет письмо подтверждения. Ответ одинаковый для любой почты
+func (a *authServer) ResendVerification(
+	ctx context.Context,
+	req *AuthService.ResendVerificationRequest,
+) (
+	*AuthService.ResendVerificationResponse, error,
+) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	user, err := a.repo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			a.log.Errorf("get user by email err: %v", err)
+		}
+		return &AuthService.ResendVerificationResponse{}, nil
+	}
+	if user.EmailVerifiedAt != nil {
+		return &AuthService.ResendVerificationResponse{}, nil
+	}
+
+	if err := a.requestEmailVerification(ctx, user); err != nil {
+		a.log.Errorf("send email verification err: user_id = %s: %v", user.ID, err)
+	}
+
+	return &AuthService.ResendVerificationResponse{}, nil
+}
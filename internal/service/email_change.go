@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChangeEmail запускает смену почты. Адрес в users меняется только после перехода по ссылке из письма на новый адрес
+func (a *authServer) ChangeEmail(
+	ctx context.Context,
+	req *AuthService.ChangeEmailRequest,
+) (
+	*AuthService.ChangeEmailResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	newEmail := normalizeEmail(req.NewEmail)
+	if !validEmail(newEmail) {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidEmail)
+	}
+
+	user, err := a.repo.GetUserByID(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("get user err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// смена почты даёт контроль над восстановлением пароля, поэтому требуем пароль даже при живой сессии.
+	// Неверный пароль считается против той же блокировки, что и при входе
+	if err := a.checkLoginLock(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if err := secure.CheckPassword(user.HashedPassword, req.CurrentPassword); err != nil {
+		a.loginFailed(ctx, user.ID)
+		return nil, status.Error(codes.Unauthenticated, ErrInvalidPassword)
+	}
+
+	if newEmail == normalizeEmail(user.Email) {
+		return nil, status.Error(codes.InvalidArgument, "new email must differ from current email")
+	}
+
+	_, err = a.repo.GetUserByEmail(ctx, newEmail)
+	if err == nil {
+		return nil, status.Error(codes.AlreadyExists, ErrEmailTaken)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		a.log.Errorf("get user by email err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	confirmToken, err := a.newUserToken(ctx, user.ID, repo.UserTokenEmailChange, newEmail, a.cfg.System.EmailChangeTimeout)
+	if err != nil {
+		a.log.Errorf("create email change token err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// ссылки отмены из прошлых писем не гасятся: иначе, сменив почту дважды, можно лишить владельца возможности вернуть адрес
+	cancelToken, err := secure.NewOpaqueToken()
+	if err != nil {
+		a.log.Errorf("create email change cancel token err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	err = a.repo.CreateUserToken(ctx, repo.NewUserTokenParams{
+		UserID:       user.ID,
+		Purpose:      repo.UserTokenEmailChangeCancel,
+		TokenHash:    secure.HashToken(cancelToken),
+		Payload:      user.Email,
+		ExpiresAt:    time.Now().Add(a.cfg.System.EmailChangeCancelTimeout),
+		KeepExisting: true,
+	})
+	if err != nil {
+		a.log.Errorf("create email change cancel token err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.sendEmailChange(ctx, newEmail, confirmToken); err != nil {
+		a.log.Errorf("send email change err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if err := a.sendEmailChangeNotice(ctx, user.Email, newEmail, cancelToken); err != nil {
+		a.log.Errorf("send email change notice err: user_id = %s: %v", user.ID, err)
+	}
+
+	return &AuthService.ChangeEmailResponse{}, nil
+}
+
+func (a *authServer) ConfirmEmailChange(
+	ctx context.Context,
+	req *AuthService.ConfirmEmailChangeRequest,
+) (
+	*AuthService.ConfirmEmailChangeResponse, error,
+) {
+	token, err := a.repo.UseUserToken(ctx, repo.UserTokenEmailChange, secure.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidEmailChange)
+		}
+		a.log.Errorf("use email change token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.setEmail(ctx, token.UserID, token.Payload); err != nil {
+		return nil, err
+	}
+
+	a.securityEvent(ctx, EventEmailChanged, token.UserID)
+
+	return &AuthService.ConfirmEmailChangeResponse{}, nil
+}
+
+// CancelEmailChange отменяет смену почты по ссылке, отправленной на прежний адрес
+func (a *authServer) CancelEmailChange(
+	ctx context.Context,
+	req *AuthService.CancelEmailChangeRequest,
+) (
+	*AuthService.CancelEmailChangeResponse, error,
+) {
+	token, err := a.repo.UseUserToken(ctx, repo.UserTokenEmailChangeCancel, secure.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidEmailChange)
+		}
+		a.log.Errorf("use email change cancel token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.repo.DeleteUserTokens(ctx, token.UserID, repo.UserTokenEmailChange); err != nil {
+		a.log.Errorf("delete email change tokens err: user_id = %s: %v", token.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	user, err := a.repo.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		a.log.Errorf("get user err: user_id = %s: %v", token.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// смена уже подтверждена: возвращаем адрес и выкидываем того, кто её сделал
+	if user.Email != token.Payload {
+		if err := a.setEmail(ctx, user.ID, token.Payload); err != nil {
+			return nil, err
+		}
+
+		err = a.repo.RevokeUserSessions(ctx, repo.RevokeUserSessionsParams{
+			UserID:          user.ID,
+			ExceptSessionID: uuid.Nil,
+		})
+		if err != nil {
+			a.log.Errorf("revoke sessions err: user_id = %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		a.syncDenylist(ctx)
+	}
+
+	a.securityEvent(ctx, EventEmailChangeCancel, user.ID, "reverted", user.Email != token.Payload)
+
+	return &AuthService.CancelEmailChangeResponse{}, nil
+}
+
+func (a *authServer) setEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	err := a.repo.ChangeEmail(ctx, userID, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return status.Error(codes.AlreadyExists, ErrEmailTaken)
+		}
+		a.log.Errorf("change email err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// changeEmail запускает смену почты пользователя с паролем из addUser
+func changeEmail(t *testing.T, srv *authServer, accessToken, newEmail string) {
+	t.Helper()
+
+	_, err := srv.ChangeEmail(context.Background(), &AuthService.ChangeEmailRequest{
+		AccessToken:     accessToken,
+		CurrentPassword: "Password123!",
+		NewEmail:        newEmail,
+	})
+	if err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+}
+
+func TestChangeEmailRejected(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	f.addUser("bob")
+	tokens := login(t, srv, "alice")
+
+	tests := []struct {
+		name     string
+		password string
+		newEmail string
+		want     codes.Code
+	}{
+		{name: "wrong password", password: "WrongPassword1*", newEmail: "alice@example.org", want: codes.Unauthenticated},
+		{name: "invalid address", password: "Password123!", newEmail: "Alice <alice@example.org>", want: codes.InvalidArgument},
+		{name: "same address", password: "Password123!", newEmail: "Alice@Example.com", want: codes.InvalidArgument},
+		{name: "taken address", password: "Password123!", newEmail: "bob@example.com", want: codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.ChangeEmail(context.Background(), &AuthService.ChangeEmailRequest{
+				AccessToken:     tokens.AccessToken,
+				CurrentPassword: tt.password,
+				NewEmail:        tt.newEmail,
+			})
+			if status.Code(err) != tt.want {
+				t.Fatalf("ChangeEmail() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if got := len(sentMail(srv)); got != 0 {
+		t.Fatalf("sent mails = %d, want 0", got)
+	}
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	ctx := context.Background()
+
+	changeEmail(t, srv, tokens.AccessToken, "alice@example.org")
+
+	// до перехода по ссылке адрес прежний
+	if user.Email != "alice@example.com" {
+		t.Fatalf("email = %s before confirmation", user.Email)
+	}
+
+	req := &AuthService.ConfirmEmailChangeRequest{Token: mailToken(t, srv, "alice@example.org")}
+	if _, err := srv.ConfirmEmailChange(ctx, req); err != nil {
+		t.Fatalf("ConfirmEmailChange() error = %v", err)
+	}
+	if user.Email != "alice@example.org" || user.EmailVerifiedAt == nil {
+		t.Fatalf("email = %s, verified = %v, want confirmed alice@example.org", user.Email, user.EmailVerifiedAt != nil)
+	}
+	if _, err := srv.ConfirmEmailChange(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ConfirmEmailChange() reuse error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestCancelEmailChangeBeforeConfirmation(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	ctx := context.Background()
+
+	changeEmail(t, srv, tokens.AccessToken, "alice@example.org")
+	confirm := mailToken(t, srv, "alice@example.org")
+
+	_, err := srv.CancelEmailChange(ctx, &AuthService.CancelEmailChangeRequest{Token: mailToken(t, srv, "alice@example.com")})
+	if err != nil {
+		t.Fatalf("CancelEmailChange() error = %v", err)
+	}
+
+	// ссылка на новый адрес больше не действует, а сессии не тронуты
+	_, err = srv.ConfirmEmailChange(ctx, &AuthService.ConfirmEmailChangeRequest{Token: confirm})
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != ErrInvalidEmailChange {
+		t.Fatalf("ConfirmEmailChange() after cancel error = %v, want %q", err, ErrInvalidEmailChange)
+	}
+	if user.Email != "alice@example.com" {
+		t.Fatalf("email = %s, want alice@example.com", user.Email)
+	}
+	if code := validate(srv, tokens.AccessToken); code != codes.OK {
+		t.Fatalf("Validate() after cancel = %v, want OK", code)
+	}
+}
+
+func TestCancelEmailChangeAfterConfirmation(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	ctx := context.Background()
+
+	// ссылка отмены из первого письма переживает повторную смену почты
+	changeEmail(t, srv, tokens.AccessToken, "alice@example.org")
+	cancel := mailToken(t, srv, "alice@example.com")
+	changeEmail(t, srv, tokens.AccessToken, "alice@example.net")
+
+	_, err := srv.ConfirmEmailChange(ctx, &AuthService.ConfirmEmailChangeRequest{Token: mailToken(t, srv, "alice@example.net")})
+	if err != nil {
+		t.Fatalf("ConfirmEmailChange() error = %v", err)
+	}
+
+	// смена уже подтверждена: отмена возвращает адрес и завершает все сессии
+	if _, err := srv.CancelEmailChange(ctx, &AuthService.CancelEmailChangeRequest{Token: cancel}); err != nil {
+		t.Fatalf("CancelEmailChange() error = %v", err)
+	}
+	if user.Email != "alice@example.com" {
+		t.Fatalf("email = %s, want alice@example.com", user.Email)
+	}
+	if code := validate(srv, tokens.AccessToken); code != codes.Unauthenticated {
+		t.Fatalf("Validate() after cancel = %v, want Unauthenticated", code)
+	}
+
+	if _, err := srv.CancelEmailChange(ctx, &AuthService.CancelEmailChangeRequest{Token: cancel}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CancelEmailChange() reuse error = %v, want InvalidArgument", err)
+	}
+}
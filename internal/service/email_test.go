@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/pkg/jwt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	srv.cfg.System.RequireEmailVerification = true
+	ctx := context.Background()
+
+	_, err := srv.Register(ctx, &AuthService.RegisterRequest{Username: "alice", Password: "Password123*", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// пока почта не подтверждена, вход запрещён
+	_, err = srv.Login(ctx, &AuthService.LoginRequest{Username: "alice", Password: "Password123*"})
+	if status.Code(err) != codes.FailedPrecondition || status.Convert(err).Message() != ErrEmailNotVerified {
+		t.Fatalf("Login() before verification error = %v, want %q", err, ErrEmailNotVerified)
+	}
+
+	req := &AuthService.VerifyEmailRequest{Token: mailToken(t, srv, "alice@example.com")}
+	if _, err := srv.VerifyEmail(ctx, req); err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if _, err := srv.VerifyEmail(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("VerifyEmail() reuse error = %v, want InvalidArgument", err)
+	}
+
+	tokens, err := srv.Login(ctx, &AuthService.LoginRequest{Username: "alice", Password: "Password123*"})
+	if err != nil {
+		t.Fatalf("Login() after verification error = %v", err)
+	}
+	data, err := srv.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{Token: tokens.AccessToken})
+	if err != nil {
+		t.Fatalf("GetDataFromToken() error = %v", err)
+	}
+	if !data.EmailVerified {
+		t.Fatal("access token claims email_verified = false")
+	}
+
+	// подтверждённому адресу письмо повторно не отправляется
+	sent := len(sentMail(srv))
+	if _, err := srv.ResendVerification(ctx, &AuthService.ResendVerificationRequest{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("ResendVerification() error = %v", err)
+	}
+	if got := len(sentMail(srv)); got != sent {
+		t.Fatalf("sent mails = %d, want %d", got, sent)
+	}
+}
+
+func TestVerifyEmailChangedAddress(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	ctx := context.Background()
+
+	if _, err := srv.ResendVerification(ctx, &AuthService.ResendVerificationRequest{Email: user.Email}); err != nil {
+		t.Fatalf("ResendVerification() error = %v", err)
+	}
+	token := mailToken(t, srv, user.Email)
+
+	// ссылка подтверждает только тот адрес, на который её отправили
+	f.mu.Lock()
+	user.Email = "alice@example.org"
+	f.mu.Unlock()
+
+	_, err := srv.VerifyEmail(ctx, &AuthService.VerifyEmailRequest{Token: token})
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != ErrInvalidVerifyToken {
+		t.Fatalf("VerifyEmail() for a changed address error = %v, want %q", err, ErrInvalidVerifyToken)
+	}
+	if user.EmailVerifiedAt != nil {
+		t.Fatal("VerifyEmail() verified an address the link was not sent to")
+	}
+}
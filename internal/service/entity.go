@@ -6,4 +6,45 @@ const (
 	ErrUserNotFound         = "User not found"
 	ErrValidateJwt          = "not authorized"
 	ErrTokenNotFound        = "refresh token not found"
+	ErrTokenReused          = "refresh token has already been used, please log in again"
+	ErrTokenExpired         = "refresh token has expired, please log in again"
+	ErrSessionNotFound      = "session not found"
+	ErrTokenRevoked         = "token has been revoked"
+	ErrInvalidAudience      = "token is not intended for this audience"
+	ErrAccessTokenExpected  = "wrong token type: access token expected"
+	ErrRefreshTokenExpected = "wrong token type: refresh token expected"
+	ErrMfaTokenExpected     = "wrong token type: mfa token expected"
+	ErrMfaNotConfigured     = "two-factor authentication is not configured"
+	ErrInvalidMfaCode       = "invalid two-factor authentication code"
+	ErrMfaAttemptsExceeded  = "too many invalid two-factor authentication codes, please log in again"
+	ErrTotpAlreadyEnabled   = "two-factor authentication is already enabled"
+	ErrTotpNotEnrolled      = "two-factor authentication enrollment has not been started"
+	ErrMfaNotEnabled        = "two-factor authentication is not enabled"
+	ErrInvalidRecoveryCode  = "invalid recovery code"
+	ErrCeremonyNotFound     = "webauthn ceremony not found or expired"
+	ErrPasskeyVerification  = "passkey verification failed"
+	ErrNoPasskeys           = "no passkeys registered for user"
+	ErrPasskeyAlreadyExist  = "passkey already registered"
+	ErrInvalidResetToken    = "password reset link is invalid or expired"
+	ErrInvalidVerifyToken   = "email verification link is invalid or expired"
+	ErrEmailNotVerified     = "email address is not verified"
+	ErrInvalidEmail         = "invalid email address"
+	ErrEmailTaken           = "email address is already in use"
+	ErrInvalidEmailChange   = "email change link is invalid or expired"
+	ErrInvalidPassword      = "invalid password"
+	ErrLockedOut            = "too many failed login attempts, try again later"
+	ErrPasswordReused       = "new password must differ from recently used passwords"
+	ErrPermissionDenied     = "permission denied"
+	ErrRoleNotFound         = "role not found"
+	ErrRoleAlreadyExist     = "role already exist"
+	ErrOAuthClientNotFound  = "oauth client not found"
+	ErrInvalidRedirectURI   = "redirect_uri is not registered for the client"
+	ErrInvalidClientAuth    = "invalid client credentials"
+	ErrInvalidOAuthCode     = "authorization code is invalid or expired"
+	ErrOAuthLoginExpired    = "sign-in has expired, please sign in again"
+	ErrInvalidCredentials   = "invalid username or password"
+	ErrConsentNotFound      = "consent not found"
+	ErrScopeNotAllowed      = "token scope does not allow this operation"
+	ErrInvalidIDTokenHint   = "id_token_hint is invalid"
+	ErrInvalidLogoutURI     = "post_logout_redirect_uri is not registered for the client"
 )
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+
+	"newservice/internal/repo"
+)
+
+// Имена пользователей и адреса почты хранятся в нижнем регистре и сравниваются без учёта регистра.
+// Имя не может содержать @, поэтому по идентификатору входа сразу понятно, почта это или имя
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func isEmailIdentifier(identifier string) bool {
+	return strings.Contains(identifier, "@")
+}
+
+// validEmail принимает только голый адрес, без имени и угловых скобок
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// findUserByLogin ищет пользователя по почте или имени
+func (a *authServer) findUserByLogin(ctx context.Context, identifier string) (*repo.User, error) {
+	if isEmailIdentifier(identifier) {
+		return a.repo.GetUserByEmail(ctx, normalizeEmail(identifier))
+	}
+	return a.repo.GetUserByUsername(ctx, normalizeUsername(identifier))
+}
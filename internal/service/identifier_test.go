@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRegisterInvalidArgument(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+
+	tests := []struct {
+		name string
+		req  *AuthService.RegisterRequest
+	}{
+		{name: "weak password", req: &AuthService.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "123"}},
+		{name: "empty username", req: &AuthService.RegisterRequest{Username: "  ", Email: "alice@example.com", Password: "Password123!"}},
+		{name: "username with @", req: &AuthService.RegisterRequest{Username: "alice@example.com", Email: "alice@example.com", Password: "Password123!"}},
+		{name: "invalid email", req: &AuthService.RegisterRequest{Username: "alice", Email: "Alice <alice@example.com>", Password: "Password123!"}},
+		{name: "empty email", req: &AuthService.RegisterRequest{Username: "alice", Password: "Password123!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.Register(context.Background(), tt.req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Register() error = %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
+
+func TestLoginIdentifier(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+
+	tests := []struct {
+		name       string
+		identifier string
+		want       codes.Code
+	}{
+		{name: "username", identifier: "alice", want: codes.OK},
+		{name: "username any case", identifier: " Alice ", want: codes.OK},
+		{name: "email", identifier: "alice@example.com", want: codes.OK},
+		{name: "email any case", identifier: "ALICE@Example.COM", want: codes.OK},
+		{name: "unknown username", identifier: "bob", want: codes.NotFound},
+		{name: "unknown email", identifier: "bob@example.com", want: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := srv.Login(context.Background(), &AuthService.LoginRequest{
+				Identifier: tt.identifier,
+				Password:   "Password123!",
+			})
+			if status.Code(err) != tt.want {
+				t.Fatalf("Login() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == codes.OK && resp.AccessToken == "" {
+				t.Fatal("Login() returned no access token")
+			}
+		})
+	}
+}
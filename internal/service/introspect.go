@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// значения token_type_hint и token_type из RFC 7009 / RFC 7662
+const (
+	TokenTypeHintAccess  = "access_token"
+	TokenTypeHintRefresh = "refresh_token"
+)
+
+// Introspect сообщает, активен ли токен, и возвращает его данные. По RFC 7662 любая причина
+// недействительности токена (подпись, срок, отзыв) даёт просто active = false.
+// Отвечает только конфиденциальным клиентам: иначе это открытый способ проверять чужие токены
+func (a *authServer) Introspect(
+	ctx context.Context,
+	req *AuthService.IntrospectRequest,
+) (
+	*AuthService.IntrospectResponse, error,
+) {
+	client, err := a.authenticateClient(ctx, audit.ActionIntrospect, req)
+	if err != nil {
+		return nil, err
+	}
+	if !confidentialClient(client) {
+		a.auditEvent(ctx, audit.ActionIntrospect, audit.OutcomeDenied, uuid.Nil, client.ID, "reason", "public_client")
+		return nil, a.oauthError(codes.Unauthenticated, OAuthInvalidClient, ErrInvalidClientAuth)
+	}
+
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	// подсказка определяет только порядок проверки, токен другого типа тоже ищем
+	if req.TokenTypeHint != TokenTypeHintRefresh {
+		if resp := a.introspectAccessToken(req.Token); resp != nil {
+			return resp, nil
+		}
+	}
+
+	resp, err := a.introspectRefreshToken(ctx, req.Token)
+	if err != nil {
+		a.log.Errorf("introspect refresh token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if resp != nil {
+		return resp, nil
+	}
+
+	if req.TokenTypeHint == TokenTypeHintRefresh {
+		if resp := a.introspectAccessToken(req.Token); resp != nil {
+			return resp, nil
+		}
+	}
+
+	return &AuthService.IntrospectResponse{Active: false}, nil
+}
+
+// introspectAccessToken возвращает nil, если это не действующий access токен
+func (a *authServer) introspectAccessToken(token string) *AuthService.IntrospectResponse {
+	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
+		Token:     token,
+		TokenType: jwt.TokenTypeAccess,
+	})
+	if err != nil || !check {
+		return nil
+	}
+
+	data, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+		Token: token,
+	})
+	if err != nil || a.denylist.IsRevoked(data.TokenId) {
+		return nil
+	}
+
+	// у токенов клиента (client credentials) сессии нет
+	var sessionID string
+	if data.SessionId != uuid.Nil {
+		sessionID = data.SessionId.String()
+	}
+
+	return &AuthService.IntrospectResponse{
+		Active:    true,
+		Sub:       data.Subject,
+		Exp:       data.ExpiresAt.Unix(),
+		Iat:       data.IssuedAt.Unix(),
+		Scope:     data.Scope,
+		ClientId:  data.ClientId,
+		TokenType: TokenTypeHintAccess,
+		SessionId: sessionID,
+		Iss:       data.Issuer,
+		Aud:       data.Audience,
+		Jti:       data.TokenId,
+	}
+}
+
+// introspectRefreshToken возвращает nil, если это не действующий refresh токен
+func (a *authServer) introspectRefreshToken(ctx context.Context, token string) (*AuthService.IntrospectResponse, error) {
+	stored, err := a.repo.GetAuthTokenByRefresh(ctx, secure.HashToken(token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil || stored.ConsumedAt != nil || stored.RefreshExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	return &AuthService.IntrospectResponse{
+		Active:    true,
+		Sub:       stored.UserID.String(),
+		Exp:       stored.RefreshExpiresAt.Unix(),
+		Iat:       stored.CreatedAt.Unix(),
+		Scope:     stored.Scope,
+		ClientId:  stored.ClientID,
+		TokenType: TokenTypeHintRefresh,
+		SessionId: stored.SessionID.String(),
+	}, nil
+}
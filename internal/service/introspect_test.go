@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// introspect проверяет токен от имени ресурсного сервера, зарегистрированного через addOAuthClient
+func introspect(t *testing.T, srv *authServer, token, hint string) *AuthService.IntrospectResponse {
+	t.Helper()
+
+	resp, err := srv.Introspect(context.Background(), &AuthService.IntrospectRequest{
+		Token:         token,
+		TokenTypeHint: hint,
+		ClientId:      testIntrospectionClient,
+		ClientSecret:  testIntrospectionSecret,
+	})
+	if err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	return resp
+}
+
+func TestIntrospectRequiresClientAuthentication(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	server := f.addOAuthClient(testIntrospectionClient, testIntrospectionSecret)
+	f.addOAuthClient("spa", "")
+	tokens := login(t, srv, "alice")
+
+	tests := []struct {
+		name         string
+		clientID     string
+		clientSecret string
+	}{
+		{name: "without credentials"},
+		{name: "without secret", clientID: testIntrospectionClient},
+		{name: "wrong secret", clientID: testIntrospectionClient, clientSecret: "wrong-secret"},
+		{name: "secret hash instead of secret", clientID: testIntrospectionClient, clientSecret: *server.SecretHash},
+		{name: "public client", clientID: "spa"},
+		{name: "unknown client", clientID: "unknown", clientSecret: testIntrospectionSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.Introspect(context.Background(), &AuthService.IntrospectRequest{
+				Token:        tokens.AccessToken,
+				ClientId:     tt.clientID,
+				ClientSecret: tt.clientSecret,
+			})
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("Introspect() code = %v, want %v", status.Code(err), codes.Unauthenticated)
+			}
+		})
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	alice := f.addUser("alice")
+	f.addOAuthClient(testIntrospectionClient, testIntrospectionSecret)
+	tokens := login(t, srv, "alice")
+
+	access := introspect(t, srv, tokens.AccessToken, "")
+	if !access.Active || access.TokenType != TokenTypeHintAccess || access.Sub != alice.ID.String() || access.Jti == "" {
+		t.Fatalf("Introspect() of an access token = %+v", access)
+	}
+
+	// подсказка влияет только на порядок поиска
+	refresh := introspect(t, srv, tokens.RefreshToken, TokenTypeHintAccess)
+	if !refresh.Active || refresh.TokenType != TokenTypeHintRefresh || refresh.Sub != alice.ID.String() {
+		t.Fatalf("Introspect() of a refresh token = %+v", refresh)
+	}
+
+	if resp := introspect(t, srv, "not-a-token", ""); resp.Active {
+		t.Fatal("Introspect() of garbage is active")
+	}
+
+	// обменянный refresh токен и отозванный access токен неактивны
+	if _, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{RefreshToken: tokens.RefreshToken}); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if resp := introspect(t, srv, tokens.RefreshToken, TokenTypeHintRefresh); resp.Active {
+		t.Fatal("Introspect() of a consumed refresh token is active")
+	}
+	if _, err := srv.RevokeAccessToken(context.Background(), &AuthService.RevokeAccessTokenRequest{AccessToken: tokens.AccessToken}); err != nil {
+		t.Fatalf("RevokeAccessToken() error = %v", err)
+	}
+	if resp := introspect(t, srv, tokens.AccessToken, ""); resp.Active {
+		t.Fatal("Introspect() of a revoked access token is active")
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ReasonLockedOut - причина в ErrorInfo ответа на вход в заблокированную учётную запись
+const ReasonLockedOut = "LOCKED_OUT"
+
+// loginFailureKeys - счётчики, которые затрагивает попытка входа: учётная запись (если она найдена) и IP клиента
+func loginFailureKeys(ctx context.Context, userID uuid.UUID) []repo.LoginFailureKey {
+	var keys []repo.LoginFailureKey
+	if userID != uuid.Nil {
+		keys = append(keys, repo.LoginFailureKey{Scope: repo.LoginScopeUser, Subject: userID.String()})
+	}
+	if ip := clientinfo.FromContext(ctx).IP; ip != "" {
+		keys = append(keys, repo.LoginFailureKey{Scope: repo.LoginScopeIP, Subject: ip})
+	}
+	return keys
+}
+
+// checkLoginLock не пускает к проверке пароля, пока действует блокировка учётной записи или IP
+func (a *authServer) checkLoginLock(ctx context.Context, userID uuid.UUID) error {
+	keys := loginFailureKeys(ctx, userID)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	lockedUntil, err := a.repo.GetLoginLock(ctx, keys...)
+	if err != nil {
+		a.log.Errorf("get login lock err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if lockedUntil == nil {
+		return nil
+	}
+
+	return a.lockedOut(time.Until(*lockedUntil))
+}
+
+// lockedOut - ошибка с деталями ErrorInfo и RetryInfo, по которым клиент может показать, когда повторить попытку
+func (a *authServer) lockedOut(retryAfter time.Duration) error {
+	retryAfter = retryAfter.Round(time.Second)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	st, err := status.New(codes.PermissionDenied, ErrLockedOut).WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: ReasonLockedOut,
+			Domain: a.cfg.System.Issuer,
+			Metadata: map[string]string{
+				"retry_after": strconv.Itoa(int(retryAfter.Seconds())),
+			},
+		},
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		},
+	)
+	if err != nil {
+		return status.Error(codes.PermissionDenied, ErrLockedOut)
+	}
+	return st.Err()
+}
+
+// loginFailed учитывает неудачную попытку входа и блокирует счётчики, превысившие порог.
+// Ошибки только логируются: ответ клиенту от них не зависит
+func (a *authServer) loginFailed(ctx context.Context, userID uuid.UUID) {
+	since := time.Now().Add(-a.cfg.System.LoginFailureWindow)
+
+	for _, key := range loginFailureKeys(ctx, userID) {
+		failures, err := a.repo.RecordLoginFailure(ctx, key, since)
+		if err != nil {
+			a.log.Errorf("record login failure err: %s %s: %v", key.Scope, key.Subject, err)
+			continue
+		}
+
+		maxFailures := a.cfg.System.LoginMaxFailures
+		if key.Scope == repo.LoginScopeIP {
+			maxFailures = a.cfg.System.LoginIPMaxFailures
+		}
+
+		lockout := loginLockout(failures, maxFailures, a.cfg.System.LoginLockoutBase, a.cfg.System.LoginLockoutMax)
+		if lockout == 0 {
+			continue
+		}
+
+		if err := a.repo.LockLogin(ctx, key, time.Now().Add(lockout)); err != nil {
+			a.log.Errorf("lock login err: %s %s: %v", key.Scope, key.Subject, err)
+			continue
+		}
+		if key.Scope == repo.LoginScopeUser {
+			a.securityEvent(ctx, EventAccountLocked, userID, "failures", failures, "lockout", lockout.String())
+		}
+	}
+}
+
+// loginSucceeded сбрасывает счётчик учётной записи. Счётчик IP не сбрасывается, иначе,
+// входя в свою учётную запись, можно было бы перебирать пароли к чужим с того же адреса
+func (a *authServer) loginSucceeded(ctx context.Context, userID uuid.UUID) {
+	err := a.repo.ResetLoginFailures(ctx, repo.LoginFailureKey{Scope: repo.LoginScopeUser, Subject: userID.String()})
+	if err != nil {
+		a.log.Errorf("reset login failures err: user_id = %s: %v", userID, err)
+	}
+}
+
+// loginLockout - длительность блокировки после failures ошибок подряд: начиная с maxFailures
+// каждая ошибка удваивает блокировку, но не дольше maxLockout
+func loginLockout(failures, maxFailures int, base, maxLockout time.Duration) time.Duration {
+	if maxFailures <= 0 || failures < maxFailures {
+		return 0
+	}
+
+	lockout := base
+	for i := maxFailures; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, maxLockout)
+}
+
+// UnlockUser снимает блокировку входа с учётной записи, требуется право auth:admin
+func (a *authServer) UnlockUser(
+	ctx context.Context,
+	req *AuthService.UnlockUserRequest,
+) (
+	*AuthService.UnlockUserResponse, error,
+) {
+	accessData, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := uuid.Parse(req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id format")
+	}
+
+	if _, err := a.repo.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("get user err: user_id = %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	err = a.repo.ResetLoginFailures(ctx, repo.LoginFailureKey{Scope: repo.LoginScopeUser, Subject: userID.String()})
+	if err != nil {
+		a.log.Errorf("reset login failures err: user_id = %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	a.auditEvent(ctx, EventAccountUnlocked, audit.OutcomeSuccess, accessData.UserId, userID.String())
+
+	return &AuthService.UnlockUserResponse{}, nil
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/totp"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoginLockout(t *testing.T) {
+	base := 30 * time.Second
+	maxLockout := 10 * time.Minute
+
+	tests := []struct {
+		failures    int
+		maxFailures int
+		want        time.Duration
+	}{
+		{failures: 1, maxFailures: 5, want: 0},
+		{failures: 4, maxFailures: 5, want: 0},
+		{failures: 5, maxFailures: 5, want: 30 * time.Second},
+		{failures: 6, maxFailures: 5, want: time.Minute},
+		{failures: 7, maxFailures: 5, want: 2 * time.Minute},
+		{failures: 9, maxFailures: 5, want: 8 * time.Minute},
+		{failures: 10, maxFailures: 5, want: maxLockout},
+		{failures: 1000, maxFailures: 5, want: maxLockout},
+		{failures: 100, maxFailures: 0, want: 0}, // блокировка выключена
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.failures)+"/"+strconv.Itoa(tt.maxFailures), func(t *testing.T) {
+			if got := loginLockout(tt.failures, tt.maxFailures, base, maxLockout); got != tt.want {
+				t.Fatalf("loginLockout(%d, %d) = %v, want %v", tt.failures, tt.maxFailures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLockedOut(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	admin := f.addUser("admin")
+	f.grant(admin.ID, PermissionAdmin)
+	adminTokens := login(t, srv, "admin")
+	ctx := context.Background()
+
+	for i := 0; i < srv.cfg.System.LoginMaxFailures; i++ {
+		_, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "alice", Password: "WrongPassword1*"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("attempt %d: Login() error = %v, want Unauthenticated", i+1, err)
+		}
+	}
+
+	// после блокировки не проходит и верный пароль, а клиент узнаёт, когда повторить
+	_, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Login() on locked account error = %v, want PermissionDenied", err)
+	}
+	var reason string
+	var retryDelay time.Duration
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+		case *errdetails.RetryInfo:
+			retryDelay = d.RetryDelay.AsDuration()
+		}
+	}
+	if reason != ReasonLockedOut || retryDelay != srv.cfg.System.LoginLockoutBase {
+		t.Fatalf("Login() details reason = %q, retry = %v", reason, retryDelay)
+	}
+
+	_, err = srv.UnlockUser(ctx, &AuthService.UnlockUserRequest{AccessToken: adminTokens.AccessToken, UserId: user.ID.String()})
+	if err != nil {
+		t.Fatalf("UnlockUser() error = %v", err)
+	}
+	if _, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"}); err != nil {
+		t.Fatalf("Login() after unlock error = %v", err)
+	}
+}
+
+// Подтверждение действия паролем при живой сессии подчиняется той же блокировке, что и вход
+func TestCurrentPasswordLockout(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(srv *authServer, accessToken, password string) error
+	}{
+		{
+			name: "ChangePassword",
+			call: func(srv *authServer, accessToken, password string) error {
+				_, err := srv.ChangePassword(context.Background(), &AuthService.ChangePasswordRequest{
+					AccessToken:     accessToken,
+					CurrentPassword: password,
+					NewPassword:     "NewPassword456*",
+				})
+				return err
+			},
+		},
+		{
+			name: "ChangeEmail",
+			call: func(srv *authServer, accessToken, password string) error {
+				_, err := srv.ChangeEmail(context.Background(), &AuthService.ChangeEmailRequest{
+					AccessToken:     accessToken,
+					CurrentPassword: password,
+					NewEmail:        "alice@example.org",
+				})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRepo()
+			srv := newTestServer(t, f)
+			f.addUser("alice")
+			tokens := login(t, srv, "alice")
+
+			for i := 0; i < srv.cfg.System.LoginMaxFailures; i++ {
+				if err := tt.call(srv, tokens.AccessToken, "WrongPassword1*"); status.Code(err) != codes.Unauthenticated {
+					t.Fatalf("attempt %d: %s() error = %v, want Unauthenticated", i+1, tt.name, err)
+				}
+			}
+			if err := tt.call(srv, tokens.AccessToken, "Password123!"); status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("%s() on locked account error = %v, want PermissionDenied", tt.name, err)
+			}
+			_, err := srv.Login(context.Background(), &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("Login() on locked account error = %v, want PermissionDenied", err)
+			}
+		})
+	}
+}
+
+// peerContext - запрос с адреса ip, в котором клиент сам прислал x-forwarded-for
+func peerContext(ip, forwardedFor string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", forwardedFor))
+	return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+func TestLoginLockoutIgnoresForwardedFor(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+
+	// подмена x-forwarded-for не даёт каждой попытке новый счётчик IP
+	for i := 0; i < srv.cfg.System.LoginIPMaxFailures; i++ {
+		ctx := peerContext("203.0.113.7", "198.51.100."+strconv.Itoa(i))
+		_, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "nobody" + strconv.Itoa(i), Password: "Password123!"})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("attempt %d: Login() error = %v, want NotFound", i+1, err)
+		}
+	}
+
+	f.addUser("alice")
+	_, err := srv.Login(peerContext("203.0.113.7", "198.51.100.250"), &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Login() from locked IP error = %v, want PermissionDenied", err)
+	}
+	if _, ok := f.loginFailures[repo.LoginFailureKey{Scope: repo.LoginScopeIP, Subject: "198.51.100.0"}]; ok {
+		t.Fatal("failure counted against the address from x-forwarded-for")
+	}
+
+	// с другого адреса вход не заблокирован
+	if _, err := srv.Login(peerContext("203.0.113.8", ""), &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"}); err != nil {
+		t.Fatalf("Login() from another IP error = %v", err)
+	}
+}
+
+func TestSecondFactorFailuresLockAccount(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	secret := enableTotp(t, srv, f, user)
+	ctx := context.Background()
+
+	login := func() string {
+		t.Helper()
+		resp, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if resp.MfaToken == "" {
+			t.Fatal("Login() did not ask for the second factor")
+		}
+		return resp.MfaToken
+	}
+
+	// неверные коды по разным токенам складываются, а верный пароль между ними счётчик не сбрасывает
+	failures := 0
+	for failures < srv.cfg.System.LoginMaxFailures {
+		mfaToken := login()
+		for i := 0; i < 2 && failures < srv.cfg.System.LoginMaxFailures; i++ {
+			_, err := srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{MfaToken: mfaToken, Code: wrongTotpCode(secret)})
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("VerifyMfa() error = %v, want Unauthenticated", err)
+			}
+			failures++
+		}
+	}
+
+	// учётная запись заблокирована: ни пароль, ни верный код по уже выданному токену не проходят
+	mfaToken, err := srv.mfaChallenge(user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+	_, err = srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{
+		MfaToken: mfaToken.MfaToken,
+		Code:     totp.Code(secret, totp.Step(time.Now())),
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("VerifyMfa() on locked account error = %v, want PermissionDenied", err)
+	}
+	if _, err := srv.Login(ctx, &AuthService.LoginRequest{Identifier: "alice", Password: "Password123!"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Login() on locked account error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestSecondFactorSuccessResetsFailures(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	secret := enableTotp(t, srv, f, user)
+	ctx := context.Background()
+	userKey := repo.LoginFailureKey{Scope: repo.LoginScopeUser, Subject: user.ID.String()}
+
+	challenge, err := srv.mfaChallenge(user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+	if _, err := srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{MfaToken: challenge.MfaToken, Code: wrongTotpCode(secret)}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("VerifyMfa() error = %v, want Unauthenticated", err)
+	}
+	if f.loginFailures[userKey] == nil || f.loginFailures[userKey].failures != 1 {
+		t.Fatal("invalid code was not counted against the account")
+	}
+
+	_, err = srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{
+		MfaToken: challenge.MfaToken,
+		Code:     totp.Code(secret, totp.Step(time.Now())),
+	})
+	if err != nil {
+		t.Fatalf("VerifyMfa() error = %v", err)
+	}
+	if _, ok := f.loginFailures[userKey]; ok {
+		t.Fatal("completed login did not reset the account counter")
+	}
+}
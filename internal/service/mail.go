@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"newservice/internal/repo"
+	"newservice/pkg/mailer"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+)
+
+// newUserToken выпускает одноразовый токен для ссылки из письма, в БД сохраняется только его хэш
+func (a *authServer) newUserToken(ctx context.Context, userID uuid.UUID, purpose, payload string, ttl time.Duration) (string, error) {
+	token, err := secure.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.repo.CreateUserToken(ctx, repo.NewUserTokenParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: secure.HashToken(token),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// appLink строит ссылку на страницу фронтенда с токеном
+func (a *authServer) appLink(path, token string) string {
+	return strings.TrimRight(a.cfg.System.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func (a *authServer) sendPasswordReset(ctx context.Context, email, token string) error {
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: "Someone requested a password reset for your account.\n\n" +
+			"To choose a new password, follow the link below. It expires in " + a.cfg.System.PasswordResetTimeout.String() + ".\n\n" +
+			a.appLink("/reset-password", token) + "\n\n" +
+			"If you did not request a reset, you can ignore this email.\n",
+	})
+}
+
+func (a *authServer) sendEmailVerification(ctx context.Context, email, token string) error {
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: "Please confirm your email address by following the link below. It expires in " + a.cfg.System.EmailVerificationTimeout.String() + ".\n\n" +
+			a.appLink("/verify-email", token) + "\n\n" +
+			"If you did not create an account, you can ignore this email.\n",
+	})
+}
+
+func (a *authServer) sendEmailChange(ctx context.Context, newEmail, token string) error {
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: "Someone asked to use this address for their account.\n\n" +
+			"To confirm the change, follow the link below. It expires in " + a.cfg.System.EmailChangeTimeout.String() + ".\n\n" +
+			a.appLink("/confirm-email-change", token) + "\n\n" +
+			"If you did not request this, you can ignore this email.\n",
+	})
+}
+
+func (a *authServer) sendEmailChangeNotice(ctx context.Context, oldEmail, newEmail, token string) error {
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address is being changed",
+		Body: "Someone asked to change the email address of your account to " + newEmail + ".\n\n" +
+			"If this was not you, follow the link below to cancel the change and sign out all devices. " +
+			"It works for " + a.cfg.System.EmailChangeCancelTimeout.String() + ", even after the new address is confirmed.\n\n" +
+			a.appLink("/cancel-email-change", token) + "\n",
+	})
+}
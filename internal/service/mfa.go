@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/totp"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// totpSkew - сколько соседних шагов TOTP принимается из-за расхождения часов телефона
+const totpSkew = 1
+
+// mfaMaxAttempts - сколько неверных кодов можно ввести по одному токену незавершённого входа
+const mfaMaxAttempts = 5
+
+// mfaRequired проверяет, подключён ли у пользователя второй фактор
+func (a *authServer) mfaRequired(ctx context.Context, userID uuid.UUID) (bool, error) {
+	userTotp, err := a.repo.GetTotp(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return userTotp.ConfirmedAt != nil, nil
+}
+
+// mfaChallenge выдаёт токен незавершённого входа вместо пары токенов
+func (a *authServer) mfaChallenge(userID uuid.UUID, clientID, deviceName string) (*AuthService.LoginResponse, error) {
+	challenge, err := a.jwt.CreateChallengeToken(&jwt.CreateChallengeTokenParams{
+		UserId:     userID,
+		TokenType:  jwt.TokenTypeMfa,
+		ClientId:   clientID,
+		DeviceName: deviceName,
+		ExpiresIn:  a.cfg.System.MfaChallengeTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthService.LoginResponse{
+		MfaRequired: true,
+		MfaToken:    challenge.Token,
+	}, nil
+}
+
+// checkTotp проверяет код и отмечает его шаг использованным, чтобы код нельзя было предъявить повторно
+func (a *authServer) checkTotp(ctx context.Context, userID uuid.UUID, code string) error {
+	if a.mfaCipher == nil {
+		return status.Error(codes.FailedPrecondition, ErrMfaNotConfigured)
+	}
+
+	userTotp, err := a.repo.GetTotp(ctx, userID)
+	if err != nil || userTotp.ConfirmedAt == nil {
+		return status.Error(codes.Unauthenticated, ErrInvalidMfaCode)
+	}
+
+	secret, err := a.mfaCipher.Decrypt(userTotp.Secret)
+	if err != nil {
+		a.log.Errorf("decrypt totp secret err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return status.Error(codes.Unauthenticated, ErrInvalidMfaCode)
+	}
+
+	used, err := a.repo.UseTotpStep(ctx, userID, step)
+	if err != nil {
+		a.log.Errorf("use totp step err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if !used {
+		return status.Error(codes.Unauthenticated, ErrInvalidMfaCode)
+	}
+	return nil
+}
+
+// checkSecondFactor проверяет код из приложения или код восстановления и возвращает способ входа для аудита.
+// Неверные коды считаются против токена незавершённого входа challenge, а также против учётной записи и IP,
+// как неверный пароль
+func (a *authServer) checkSecondFactor(ctx context.Context, challenge *jwt.GetDataFromTokenResponse, code, recoveryCode string) (string, error) {
+	userID := challenge.UserId
+	key := repo.LoginFailureKey{Scope: repo.LoginScopeMfa, Subject: challenge.TokenId}
+
+	// блокировка в БД видна всем репликам сразу, отзыв токена - только после синхронизации denylist
+	lockedUntil, err := a.repo.GetLoginLock(ctx, key)
+	if err != nil {
+		a.log.Errorf("get mfa lock err: jti = %s: %v", challenge.TokenId, err)
+		return "", status.Error(codes.Internal, ErrUnknown)
+	}
+	if lockedUntil != nil {
+		return "", status.Error(codes.Unauthenticated, ErrMfaAttemptsExceeded)
+	}
+
+	// подбор кода по разным токенам упирается в общую блокировку учётной записи и IP
+	if err := a.checkLoginLock(ctx, userID); err != nil {
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeDenied, uuid.Nil, userID.String(), "reason", "locked_out")
+		return "", err
+	}
+
+	// код восстановления заменяет код из приложения, если доступа к нему нет
+	method := "totp"
+	if recoveryCode != "" {
+		method = "recovery_code"
+		err = a.checkRecoveryCode(ctx, userID, recoveryCode)
+	} else {
+		err = a.checkTotp(ctx, userID, code)
+	}
+	if err != nil {
+		if status.Code(err) != codes.Internal {
+			a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeFailure, uuid.Nil, userID.String(), "method", method, "reason", "invalid_code")
+		}
+		if status.Code(err) == codes.Unauthenticated {
+			a.loginFailed(ctx, userID)
+			return "", a.secondFactorFailed(ctx, challenge, key, err)
+		}
+		return "", err
+	}
+	a.loginSucceeded(ctx, userID)
+	return method, nil
+}
+
+// secondFactorFailed учитывает неверный код. Когда попытки по токену незавершённого входа исчерпаны,
+// токен отзывается: подбирать код дальше можно, только снова введя пароль
+func (a *authServer) secondFactorFailed(ctx context.Context, challenge *jwt.GetDataFromTokenResponse, key repo.LoginFailureKey, err error) error {
+	// счётчик нужен, пока жив токен незавершённого входа
+	failures, recordErr := a.repo.RecordLoginFailure(ctx, key, time.Now().Add(-a.cfg.System.MfaChallengeTimeout))
+	if recordErr != nil {
+		a.log.Errorf("record mfa failure err: jti = %s: %v", challenge.TokenId, recordErr)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if failures < mfaMaxAttempts {
+		return err
+	}
+
+	if err := a.repo.LockLogin(ctx, key, challenge.ExpiresAt); err != nil {
+		a.log.Errorf("lock mfa challenge err: jti = %s: %v", challenge.TokenId, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if err := a.denylist.Revoke(ctx, challenge.TokenId, challenge.ExpiresAt); err != nil {
+		a.log.Errorf("revoke mfa token err: jti = %s: %v", challenge.TokenId, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	return status.Error(codes.Unauthenticated, ErrMfaAttemptsExceeded)
+}
+
+// VerifyMfa завершает вход: проверяет второй фактор и выдаёт пару токенов
+func (a *authServer) VerifyMfa(
+	ctx context.Context,
+	req *AuthService.VerifyMfaRequest,
+) (
+	*AuthService.VerifyMfaResponse, error,
+) {
+	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
+		Token:     req.MfaToken,
+		Audience:  a.cfg.System.Issuer,
+		TokenType: jwt.TokenTypeMfa,
+	})
+	if errors.Is(err, jwt.ErrWrongTokenType) {
+		return nil, status.Error(codes.Unauthenticated, ErrMfaTokenExpected)
+	}
+	if err != nil || !check {
+		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	}
+
+	challenge, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+		Token: req.MfaToken,
+	})
+	if err != nil || challenge.TokenId == "" {
+		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	}
+	if a.denylist.IsRevoked(challenge.TokenId) {
+		return nil, status.Error(codes.Unauthenticated, ErrTokenRevoked)
+	}
+
+	method, err := a.checkSecondFactor(ctx, challenge, req.Code, req.RecoveryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	// токен незавершённого входа одноразовый
+	if err := a.denylist.Revoke(ctx, challenge.TokenId, challenge.ExpiresAt); err != nil {
+		a.log.Errorf("revoke mfa token err: jti = %s: %v", challenge.TokenId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	tokens, err := a.issueTokens(ctx, challenge.UserId, challenge.ClientId, challenge.DeviceName)
+	if err != nil {
+		a.log.Errorf("failed to add auth token for user %s: %v", challenge.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, challenge.UserId, challenge.UserId.String(), "method", method)
+
+	return &AuthService.VerifyMfaResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+// BeginTotpEnrollment генерирует секрет TOTP, второй фактор включается только после ConfirmTotpEnrollment
+func (a *authServer) BeginTotpEnrollment(
+	ctx context.Context,
+	req *AuthService.BeginTotpEnrollmentRequest,
+) (
+	*AuthService.BeginTotpEnrollmentResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.mfaCipher == nil {
+		return nil, status.Error(codes.FailedPrecondition, ErrMfaNotConfigured)
+	}
+
+	user, err := a.repo.GetUserByID(ctx, accessData.UserId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("get user err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.log.Errorf("generate totp secret err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	encrypted, err := a.mfaCipher.Encrypt(secret)
+	if err != nil {
+		a.log.Errorf("encrypt totp secret err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	saved, err := a.repo.SaveTotpSecret(ctx, user.ID, encrypted)
+	if err != nil {
+		a.log.Errorf("save totp secret err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !saved {
+		return nil, status.Error(codes.AlreadyExists, ErrTotpAlreadyEnabled)
+	}
+
+	return &AuthService.BeginTotpEnrollmentResponse{
+		Secret:          totp.EncodeSecret(secret),
+		ProvisioningUri: totp.ProvisioningURI(a.cfg.System.TotpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTotpEnrollment включает второй фактор, если пользователь ввёл верный код из приложения
+func (a *authServer) ConfirmTotpEnrollment(
+	ctx context.Context,
+	req *AuthService.ConfirmTotpEnrollmentRequest,
+) (
+	*AuthService.ConfirmTotpEnrollmentResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.mfaCipher == nil {
+		return nil, status.Error(codes.FailedPrecondition, ErrMfaNotConfigured)
+	}
+
+	userTotp, err := a.repo.GetTotp(ctx, accessData.UserId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.FailedPrecondition, ErrTotpNotEnrolled)
+		}
+		a.log.Errorf("get totp err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if userTotp.ConfirmedAt != nil {
+		return nil, status.Error(codes.AlreadyExists, ErrTotpAlreadyEnabled)
+	}
+
+	secret, err := a.mfaCipher.Decrypt(userTotp.Secret)
+	if err != nil {
+		a.log.Errorf("decrypt totp secret err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	step, ok := totp.Validate(secret, req.Code, time.Now(), totpSkew)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidMfaCode)
+	}
+
+	// коды восстановления создаются до включения TOTP, чтобы второй фактор не оказался без запасного пути
+	recoveryCodes, err := a.newRecoveryCodes(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("generate recovery codes err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	confirmed, err := a.repo.ConfirmTotp(ctx, accessData.UserId, step)
+	if err != nil {
+		a.log.Errorf("confirm totp err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !confirmed {
+		return nil, status.Error(codes.AlreadyExists, ErrTotpAlreadyEnabled)
+	}
+
+	return &AuthService.ConfirmTotpEnrollmentResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
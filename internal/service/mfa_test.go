@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/totp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enableTotp подключает пользователю второй фактор и возвращает секрет
+func enableTotp(t *testing.T, srv *authServer, f *fakeRepo, user *repo.User) []byte {
+	t.Helper()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %v", err)
+	}
+	encrypted, err := srv.mfaCipher.Encrypt(secret)
+	if err != nil {
+		t.Fatalf("failed to encrypt secret: %v", err)
+	}
+
+	confirmedAt := time.Now()
+	f.mu.Lock()
+	f.totp[user.ID] = &repo.UserTotp{UserID: user.ID, Secret: encrypted, ConfirmedAt: &confirmedAt}
+	f.mu.Unlock()
+	return secret
+}
+
+// wrongTotpCode - код, который не совпадает ни с одним допустимым сейчас
+func wrongTotpCode(secret []byte) string {
+	valid := map[string]bool{}
+	step := totp.Step(time.Now())
+	for i := -totpSkew - 1; i <= totpSkew+1; i++ {
+		valid[totp.Code(secret, step+int64(i))] = true
+	}
+	for _, code := range []string{"000000", "111111", "222222"} {
+		if !valid[code] {
+			return code
+		}
+	}
+	return "333333"
+}
+
+func TestVerifyMfa(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	secret := enableTotp(t, srv, f, user)
+
+	// с подключённым вторым фактором пароль даёт только токен незавершённого входа
+	challenge := login(t, srv, "alice")
+	if !challenge.MfaRequired || challenge.MfaToken == "" || challenge.AccessToken != "" || challenge.RefreshToken != "" {
+		t.Fatalf("Login() = %+v, want an mfa challenge only", challenge)
+	}
+
+	resp, err := srv.VerifyMfa(context.Background(), &AuthService.VerifyMfaRequest{
+		MfaToken: challenge.MfaToken,
+		Code:     totp.Code(secret, totp.Step(time.Now())),
+	})
+	if err != nil {
+		t.Fatalf("VerifyMfa() error = %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatal("VerifyMfa() returned no tokens")
+	}
+
+	// токен незавершённого входа одноразовый
+	_, err = srv.VerifyMfa(context.Background(), &AuthService.VerifyMfaRequest{
+		MfaToken: challenge.MfaToken,
+		Code:     totp.Code(secret, totp.Step(time.Now())+1),
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("second VerifyMfa() error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestVerifyMfaAttemptsLimited(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	secret := enableTotp(t, srv, f, user)
+	ctx := context.Background()
+
+	challenge, err := srv.mfaChallenge(user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+
+	for i := 1; i <= mfaMaxAttempts; i++ {
+		_, err := srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{
+			MfaToken: challenge.MfaToken,
+			Code:     wrongTotpCode(secret),
+		})
+		want := ErrInvalidMfaCode
+		if i == mfaMaxAttempts {
+			want = ErrMfaAttemptsExceeded
+		}
+		if status.Code(err) != codes.Unauthenticated || status.Convert(err).Message() != want {
+			t.Fatalf("attempt %d: VerifyMfa() error = %v, want %q", i, err, want)
+		}
+	}
+
+	// после исчерпания попыток даже верный код по этому токену не принимается
+	_, err = srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{
+		MfaToken: challenge.MfaToken,
+		Code:     totp.Code(secret, totp.Step(time.Now())),
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("VerifyMfa() after lockout error = %v, want Unauthenticated", err)
+	}
+
+	// блокировка в БД действует и на репликах, где denylist ещё не синхронизирован
+	data, err := srv.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{Token: challenge.MfaToken})
+	if err != nil {
+		t.Fatalf("failed to parse challenge: %v", err)
+	}
+	_, err = srv.checkSecondFactor(ctx, data, totp.Code(secret, totp.Step(time.Now())), "")
+	if status.Convert(err).Message() != ErrMfaAttemptsExceeded {
+		t.Fatalf("checkSecondFactor() on locked challenge error = %v, want %q", err, ErrMfaAttemptsExceeded)
+	}
+}
@@ -0,0 +1,751 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Сервер авторизации OAuth 2.0 (RFC 6749): authorization code с обязательным PKCE (RFC 7636),
+// refresh_token и client_credentials. Сессии, открытые по коду, - обычные сессии пользователя
+// с client_id клиента и выданными разрешениями. Клиенты с client_credentials - сервисные аккаунты:
+// их токены выпускаются от имени самого клиента
+
+// шаги страницы авторизации
+const (
+	OAuthStepLogin    = "login"
+	OAuthStepMfa      = "mfa"
+	OAuthStepConsent  = "consent"
+	OAuthStepRedirect = "redirect" // вернуть пользователя клиенту
+)
+
+// типы грантов
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// коды ошибок OAuth (RFC 6749, разделы 4.1.2.1 и 5.2), передаются в reason ErrorInfo
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+)
+
+const (
+	oauthResponseTypeCode = "code"
+	oauthTokenTypeBearer  = "Bearer"
+	pkceMethodS256        = "S256"
+
+	clientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	oauthConsentAllow = "allow"
+	oauthConsentDeny  = "deny"
+
+	maxNonceLength = 255
+)
+
+// способы аутентификации для claim amr (RFC 8176)
+const (
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+	amrMFA      = "mfa"
+)
+
+// oauthLogin - пользователь, вошедший на странице авторизации. Токен входа переносит его между шагами
+type oauthLogin struct {
+	userID    uuid.UUID
+	token     string
+	tokenID   string
+	expiresAt time.Time
+	authTime  time.Time // когда пользователь вошёл
+	amr       []string
+}
+
+// oauthError - ошибка с кодом OAuth в ErrorInfo, по которому HTTP-обёртка формирует ответ /token
+func (a *authServer) oauthError(code codes.Code, reason, description string) error {
+	st, err := status.New(code, description).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: a.cfg.System.Issuer,
+	})
+	if err != nil {
+		return status.Error(code, description)
+	}
+	return st.Err()
+}
+
+// OAuthAuthorize проводит пользователя по шагам страницы авторизации: вход, второй фактор, согласие,
+// и выдаёт код авторизации
+func (a *authServer) OAuthAuthorize(
+	ctx context.Context,
+	req *AuthService.OAuthAuthorizeRequest,
+) (
+	*AuthService.OAuthAuthorizeResponse, error,
+) {
+	client, err := a.repo.GetOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidClient, ErrOAuthClientNotFound)
+		}
+		a.log.Errorf("get oauth client err: client_id = %s: %v", req.ClientId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// без подтверждённого redirect_uri пользователя нельзя вернуть клиенту, ошибку покажет сама страница
+	redirectURI := req.RedirectUri
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidRequest, ErrInvalidRedirectURI)
+	}
+
+	if req.ResponseType != oauthResponseTypeCode {
+		return oauthRedirectError(redirectURI, req.State, OAuthUnsupportedResponseType, "response_type must be code"), nil
+	}
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return oauthRedirectError(redirectURI, req.State, OAuthUnauthorizedClient, "client is not allowed to use authorization code"), nil
+	}
+	if req.CodeChallengeMethod != pkceMethodS256 || !validPKCEValue(req.CodeChallenge, 43, 43) {
+		return oauthRedirectError(redirectURI, req.State, OAuthInvalidRequest, "code_challenge with code_challenge_method S256 is required"), nil
+	}
+	scopes, ok := requestedScopes(req.Scope, client.Scopes)
+	if !ok {
+		return oauthRedirectError(redirectURI, req.State, OAuthInvalidScope, "requested scope is not allowed for the client"), nil
+	}
+	if len(req.Nonce) > maxNonceLength {
+		return oauthRedirectError(redirectURI, req.State, OAuthInvalidRequest, "nonce is too long"), nil
+	}
+
+	resp := &AuthService.OAuthAuthorizeResponse{
+		ClientName: client.Name,
+		Scopes:     scopes,
+	}
+
+	login, err := a.oauthSignIn(ctx, client, req, resp)
+	if err != nil || login == nil {
+		return resp, err
+	}
+
+	// собственные приложения согласия не спрашивают, остальным нужно согласие на все запрошенные разрешения.
+	// Ответ на запрос согласия принимается только вместе с токеном входа, подделать форму согласия нельзя
+	if !client.FirstParty {
+		granted, err := a.consentGranted(ctx, login.userID, client.ID, scopes)
+		if err != nil {
+			return nil, err
+		}
+		if !granted {
+			switch {
+			case req.LoginToken != "" && req.Consent == oauthConsentAllow:
+				if err := a.repo.SaveOAuthConsent(ctx, login.userID, client.ID, scopes); err != nil {
+					a.log.Errorf("save oauth consent err: user_id = %s: %v", login.userID, err)
+					return nil, status.Error(codes.Internal, ErrUnknown)
+				}
+			case req.LoginToken != "" && req.Consent == oauthConsentDeny:
+				a.finishOAuthLogin(ctx, login)
+				a.auditEvent(ctx, audit.ActionOAuthAuthorize, audit.OutcomeDenied, login.userID, client.ID, "reason", "consent_denied")
+				return oauthRedirectError(redirectURI, req.State, OAuthAccessDenied, "user denied access"), nil
+			default:
+				resp.Step = OAuthStepConsent
+				resp.LoginToken = login.token
+				return resp, nil
+			}
+		}
+	}
+
+	code, err := secure.NewOpaqueToken()
+	if err != nil {
+		a.log.Errorf("generate oauth code err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	err = a.repo.CreateOAuthCode(ctx, repo.OAuthCode{
+		CodeHash:            secure.HashToken(code),
+		ClientID:            client.ID,
+		UserID:              login.userID,
+		RedirectURI:         redirectURI,
+		Scope:               strings.Join(scopes, " "),
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            &login.authTime,
+		Amr:                 login.amr,
+		ExpiresAt:           time.Now().Add(a.cfg.System.OAuthCodeTimeout),
+	})
+	if err != nil {
+		a.log.Errorf("create oauth code err: user_id = %s: %v", login.userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.finishOAuthLogin(ctx, login)
+	a.auditEvent(ctx, audit.ActionOAuthAuthorize, audit.OutcomeSuccess, login.userID, client.ID, "scope", strings.Join(scopes, " "))
+
+	resp.Step = OAuthStepRedirect
+	resp.RedirectUri = oauthRedirect(redirectURI, url.Values{"code": {code}}, req.State)
+	return resp, nil
+}
+
+// oauthSignIn выполняет шаг входа на странице авторизации. Если вход ещё не завершён,
+// возвращает nil и заполняет resp следующим шагом
+func (a *authServer) oauthSignIn(
+	ctx context.Context,
+	client *repo.OAuthClient,
+	req *AuthService.OAuthAuthorizeRequest,
+	resp *AuthService.OAuthAuthorizeResponse,
+) (*oauthLogin, error) {
+	switch {
+	case req.LoginToken != "":
+		data := a.oauthChallenge(req.LoginToken, jwt.TokenTypeOAuthLogin, client.ID)
+		if data == nil {
+			resp.Step = OAuthStepLogin
+			resp.Error = ErrOAuthLoginExpired
+			return nil, nil
+		}
+		return &oauthLogin{
+			userID:    data.UserId,
+			token:     req.LoginToken,
+			tokenID:   data.TokenId,
+			expiresAt: data.ExpiresAt,
+			authTime:  data.IssuedAt, // токен входа выдаётся сразу после входа
+			amr:       data.Amr,
+		}, nil
+
+	case req.MfaToken != "":
+		data := a.oauthChallenge(req.MfaToken, jwt.TokenTypeOAuthMfa, client.ID)
+		if data == nil {
+			resp.Step = OAuthStepLogin
+			resp.Error = ErrOAuthLoginExpired
+			return nil, nil
+		}
+
+		method, err := a.checkSecondFactor(ctx, data, req.MfaCode, req.RecoveryCode)
+		if err != nil {
+			if status.Code(err) == codes.Internal {
+				return nil, err
+			}
+			// после исчерпания попыток токен второго фактора отозван, вход начинается заново
+			if status.Convert(err).Message() == ErrMfaAttemptsExceeded {
+				resp.Step = OAuthStepLogin
+				resp.Error = ErrMfaAttemptsExceeded
+				return nil, nil
+			}
+			resp.Step = OAuthStepMfa
+			resp.MfaToken = req.MfaToken
+			resp.Error = status.Convert(err).Message()
+			return nil, nil
+		}
+
+		// токен второго фактора одноразовый
+		if err := a.denylist.Revoke(ctx, data.TokenId, data.ExpiresAt); err != nil {
+			a.log.Errorf("revoke oauth mfa token err: jti = %s: %v", data.TokenId, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, data.UserId, data.UserId.String(), "method", method, "client_id", client.ID)
+
+		amr := []string{amrPassword, amrMFA}
+		if method == "totp" {
+			amr = []string{amrPassword, amrOTP, amrMFA}
+		}
+		return a.newOAuthLogin(data.UserId, client.ID, amr)
+
+	case req.Identifier != "" || req.Password != "":
+		user, err := a.checkCredentials(ctx, req.Identifier, req.Password)
+		if err != nil {
+			if status.Code(err) == codes.Internal {
+				return nil, err
+			}
+			resp.Step = OAuthStepLogin
+			resp.Error = status.Convert(err).Message()
+			if status.Code(err) == codes.NotFound {
+				resp.Error = ErrInvalidCredentials
+			}
+			return nil, nil
+		}
+
+		mfaRequired, err := a.mfaRequired(ctx, user.ID)
+		if err != nil {
+			a.log.Errorf("failed to check mfa for user %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		if mfaRequired {
+			challenge, err := a.jwt.CreateChallengeToken(&jwt.CreateChallengeTokenParams{
+				UserId:    user.ID,
+				TokenType: jwt.TokenTypeOAuthMfa,
+				ClientId:  client.ID,
+				ExpiresIn: a.cfg.System.MfaChallengeTimeout,
+			})
+			if err != nil {
+				a.log.Errorf("failed to create mfa challenge for user %s: %v", user.ID, err)
+				return nil, status.Error(codes.Internal, ErrUnknown)
+			}
+			a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, user.ID, user.ID.String(), "method", "password", "mfa_required", true, "client_id", client.ID)
+			resp.Step = OAuthStepMfa
+			resp.MfaToken = challenge.Token
+			return nil, nil
+		}
+
+		a.loginSucceeded(ctx, user.ID)
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, user.ID, user.ID.String(), "method", "password", "client_id", client.ID)
+		return a.newOAuthLogin(user.ID, client.ID, []string{amrPassword})
+	}
+
+	resp.Step = OAuthStepLogin
+	return nil, nil
+}
+
+// newOAuthLogin выдаёт токен входа, которым страница авторизации подтверждает пользователя на следующих шагах.
+// Способы входа сохраняются в токене до выдачи кода
+func (a *authServer) newOAuthLogin(userID uuid.UUID, clientID string, amr []string) (*oauthLogin, error) {
+	authTime := time.Now()
+	challenge, err := a.jwt.CreateChallengeToken(&jwt.CreateChallengeTokenParams{
+		UserId:    userID,
+		TokenType: jwt.TokenTypeOAuthLogin,
+		ClientId:  clientID,
+		Amr:       amr,
+		ExpiresIn: a.cfg.System.OAuthLoginTimeout,
+	})
+	if err != nil {
+		a.log.Errorf("failed to create oauth login token for user %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &oauthLogin{
+		userID:    userID,
+		token:     challenge.Token,
+		tokenID:   challenge.TokenId,
+		expiresAt: challenge.ExpiresAt,
+		authTime:  authTime,
+		amr:       amr,
+	}, nil
+}
+
+// finishOAuthLogin гасит токен входа: код выдан или пользователь отказал клиенту
+func (a *authServer) finishOAuthLogin(ctx context.Context, login *oauthLogin) {
+	if err := a.denylist.Revoke(ctx, login.tokenID, login.expiresAt); err != nil {
+		a.log.Errorf("revoke oauth login token err: jti = %s: %v", login.tokenID, err)
+	}
+}
+
+// oauthChallenge проверяет токен шага страницы авторизации: тип, клиента и то, что он ещё не погашен.
+// Для недействительного токена - nil
+func (a *authServer) oauthChallenge(token, tokenType, clientID string) *jwt.GetDataFromTokenResponse {
+	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
+		Token:     token,
+		Audience:  a.cfg.System.Issuer,
+		TokenType: tokenType,
+	})
+	if err != nil || !check {
+		return nil
+	}
+
+	data, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+		Token: token,
+	})
+	if err != nil || data.TokenId == "" || data.ClientId != clientID || a.denylist.IsRevoked(data.TokenId) {
+		return nil
+	}
+	return data
+}
+
+// consentGranted проверяет, что пользователь уже согласился на все запрошенные разрешения
+func (a *authServer) consentGranted(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) (bool, error) {
+	consent, err := a.repo.GetOAuthConsent(ctx, userID, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		a.log.Errorf("get oauth consent err: user_id = %s: %v", userID, err)
+		return false, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// OAuthToken выдаёт токены клиенту по коду авторизации, refresh токену или его собственным учётным данным
+func (a *authServer) OAuthToken(
+	ctx context.Context,
+	req *AuthService.OAuthTokenRequest,
+) (
+	*AuthService.OAuthTokenResponse, error,
+) {
+	switch req.GrantType {
+	case GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials:
+	default:
+		return nil, a.oauthError(codes.InvalidArgument, OAuthUnsupportedGrantType, "unsupported grant_type")
+	}
+
+	client, err := a.authenticateClient(ctx, audit.ActionOAuthToken, req)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.GrantTypes, req.GrantType) {
+		return nil, a.oauthError(codes.PermissionDenied, OAuthUnauthorizedClient, "grant_type is not allowed for the client")
+	}
+
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		return a.exchangeOAuthCode(ctx, client, req)
+	case GrantRefreshToken:
+		return a.refreshOAuthToken(ctx, client, req)
+	default:
+		return a.clientCredentials(ctx, client, req)
+	}
+}
+
+// clientAuthRequest - запрос с учётными данными клиента: обмен на токены и интроспекция
+type clientAuthRequest interface {
+	GetClientId() string
+	GetClientSecret() string
+	GetClientAssertionType() string
+	GetClientAssertion() string
+}
+
+// authenticateClient проверяет клиента: конфиденциальный клиент обязан предъявить секрет или JWT, подписанный
+// своим ключом (private_key_jwt), публичный - только client_id. action - действие для журнала аудита
+func (a *authServer) authenticateClient(ctx context.Context, action string, req clientAuthRequest) (*repo.OAuthClient, error) {
+	clientID := req.GetClientId()
+	if clientID == "" && req.GetClientAssertion() != "" {
+		clientID = jwt.ClientAssertionIssuer(req.GetClientAssertion())
+	}
+	if clientID == "" {
+		return nil, a.oauthError(codes.Unauthenticated, OAuthInvalidClient, "client_id is required")
+	}
+
+	client, err := a.repo.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, a.oauthError(codes.Unauthenticated, OAuthInvalidClient, ErrInvalidClientAuth)
+		}
+		a.log.Errorf("get oauth client err: client_id = %s: %v", clientID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	switch {
+	case client.PublicKey != nil:
+		if err := a.verifyClientAssertion(ctx, action, client, req); err != nil {
+			return nil, err
+		}
+	case client.SecretHash != nil:
+		secretHash := secure.HashToken(req.GetClientSecret())
+		if req.GetClientSecret() == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(*client.SecretHash)) != 1 {
+			a.auditEvent(ctx, action, audit.OutcomeFailure, uuid.Nil, client.ID, "reason", "invalid_client_secret")
+			return nil, a.oauthError(codes.Unauthenticated, OAuthInvalidClient, ErrInvalidClientAuth)
+		}
+	}
+	return client, nil
+}
+
+// verifyClientAssertion проверяет JWT клиента (RFC 7523, раздел 3) и гасит его jti, чтобы перехваченный JWT
+// нельзя было предъявить ещё раз
+func (a *authServer) verifyClientAssertion(ctx context.Context, action string, client *repo.OAuthClient, req clientAuthRequest) error {
+	if req.GetClientAssertionType() != clientAssertionTypeJWT || req.GetClientAssertion() == "" || req.GetClientSecret() != "" {
+		return a.oauthError(codes.Unauthenticated, OAuthInvalidClient, "client must authenticate with private_key_jwt")
+	}
+
+	assertion, err := a.jwt.VerifyClientAssertion(&jwt.VerifyClientAssertionParams{
+		Assertion: req.GetClientAssertion(),
+		PublicKey: *client.PublicKey,
+		ClientId:  client.ID,
+		Audience:  []string{a.publicURL("/oauth/token"), a.publicURL("/introspect"), a.cfg.System.Issuer},
+	})
+	if err != nil {
+		a.auditEvent(ctx, action, audit.OutcomeFailure, uuid.Nil, client.ID, "reason", "invalid_client_assertion")
+		return a.oauthError(codes.Unauthenticated, OAuthInvalidClient, ErrInvalidClientAuth)
+	}
+
+	fresh, err := a.repo.UseClientAssertion(ctx, repo.UseClientAssertionParams{
+		ClientID:  client.ID,
+		TokenID:   assertion.TokenId,
+		ExpiresAt: assertion.ExpiresAt,
+	})
+	if err != nil {
+		a.log.Errorf("use client assertion err: client_id = %s: %v", client.ID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if !fresh {
+		// повторное предъявление значит, что JWT перехватили
+		a.log.Warnw("security event", "event", "client_assertion_reuse", "client_id", client.ID, "jti", assertion.TokenId)
+		a.auditEvent(ctx, action, audit.OutcomeFailure, uuid.Nil, client.ID, "reason", "client_assertion_reuse")
+		return a.oauthError(codes.Unauthenticated, OAuthInvalidClient, ErrInvalidClientAuth)
+	}
+	return nil
+}
+
+// exchangeOAuthCode обменивает код авторизации на токены новой сессии
+func (a *authServer) exchangeOAuthCode(ctx context.Context, client *repo.OAuthClient, req *AuthService.OAuthTokenRequest) (*AuthService.OAuthTokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidRequest, "code and code_verifier are required")
+	}
+
+	// код гасится вместе с назначением сессии, чтобы при повторном предъявлении её можно было отозвать.
+	// Код другого клиента не гасится: иначе любой клиент мог бы сжечь чужой код
+	codeHash := secure.HashToken(req.Code)
+	sessionID := uuid.New()
+	code, err := a.repo.UseOAuthCode(ctx, repo.UseOAuthCodeParams{
+		CodeHash:  codeHash,
+		ClientID:  client.ID,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, a.oauthCodeReused(ctx, client, codeHash)
+		}
+		a.log.Errorf("use oauth code err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if code.ExpiresAt.Before(time.Now()) {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, ErrInvalidOAuthCode)
+	}
+	if req.RedirectUri != "" && req.RedirectUri != code.RedirectURI {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+		a.auditEvent(ctx, audit.ActionOAuthToken, audit.OutcomeFailure, uuid.Nil, client.ID, "reason", "invalid_code_verifier")
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, "code_verifier does not match code_challenge")
+	}
+
+	// id_token выпускается до открытия сессии, чтобы сбой не оставил сессию без токенов у клиента
+	var idToken string
+	if slices.Contains(strings.Fields(code.Scope), ScopeOpenID) {
+		idToken, err = a.newIDToken(ctx, code, sessionID)
+		if err != nil {
+			a.log.Errorf("failed to create id token for user %s: %v", code.UserID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+	}
+
+	tokens, err := a.openSession(ctx, repo.NewSessionParams{
+		ID:         sessionID,
+		UserID:     code.UserID,
+		ClientID:   client.ID,
+		DeviceName: client.Name,
+		Scope:      code.Scope,
+	})
+	if err != nil {
+		a.log.Errorf("failed to add auth token for user %s: %v", code.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionOAuthToken, audit.OutcomeSuccess, code.UserID, client.ID,
+		"grant_type", GrantAuthorizationCode, "session_id", sessionID.String())
+
+	resp := &AuthService.OAuthTokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   oauthTokenTypeBearer,
+		ExpiresIn:   expiresIn(tokens.AccessExpiresAt),
+		Scope:       code.Scope,
+		IdToken:     idToken,
+	}
+	// refresh токен получают только клиенты, которым разрешено обновление
+	if slices.Contains(client.GrantTypes, GrantRefreshToken) {
+		resp.RefreshToken = tokens.RefreshToken
+	}
+	return resp, nil
+}
+
+// oauthCodeReused отзывает сессию, открытую по уже обменянному коду: по RFC 6749 (раздел 4.1.2)
+// повторное предъявление кода означает, что его перехватили. Чужой код на сессию его клиента не влияет
+func (a *authServer) oauthCodeReused(ctx context.Context, client *repo.OAuthClient, codeHash string) error {
+	code, err := a.repo.GetOAuthCode(ctx, codeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, ErrInvalidOAuthCode)
+		}
+		a.log.Errorf("get oauth code err: %v", err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if code.ClientID == client.ID && code.SessionID != nil {
+		a.securityEvent(ctx, EventOAuthCodeReuse, code.UserID, "client_id", code.ClientID, "session_id", code.SessionID.String())
+
+		_, err := a.repo.RevokeSession(ctx, repo.RevokeSessionParams{
+			SessionID: *code.SessionID,
+			UserID:    code.UserID,
+		})
+		if err != nil {
+			a.log.Errorf("revoke session err: %v", err)
+			return status.Error(codes.Internal, ErrUnknown)
+		}
+		a.syncDenylist(ctx)
+	}
+
+	return a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, ErrInvalidOAuthCode)
+}
+
+// refreshOAuthToken обновляет токены сессии клиента, разрешения сессии сохраняются
+func (a *authServer) refreshOAuthToken(ctx context.Context, client *repo.OAuthClient, req *AuthService.OAuthTokenRequest) (*AuthService.OAuthTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidRequest, "refresh_token is required")
+	}
+
+	stored, err := a.repo.GetAuthTokenByRefresh(ctx, secure.HashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, ErrTokenNotFound)
+		}
+		a.log.Errorf("get refresh token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if stored.ClientID != client.ID {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, ErrTokenNotFound)
+	}
+
+	// сузить разрешения при обновлении нельзя, можно только подтвердить прежние
+	if req.Scope != "" {
+		if _, ok := requestedScopes(req.Scope, strings.Fields(stored.Scope)); !ok {
+			return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidScope, "requested scope exceeds the original grant")
+		}
+	}
+
+	tokens, err := a.rotateRefreshToken(ctx, stored)
+	if err != nil {
+		if status.Code(err) == codes.Internal {
+			return nil, err
+		}
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidGrant, status.Convert(err).Message())
+	}
+
+	return &AuthService.OAuthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    oauthTokenTypeBearer,
+		ExpiresIn:    expiresIn(tokens.AccessExpiresAt),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        stored.Scope,
+	}, nil
+}
+
+// clientCredentials выдаёт конфиденциальному клиенту access токен от его собственного имени
+func (a *authServer) clientCredentials(ctx context.Context, client *repo.OAuthClient, req *AuthService.OAuthTokenRequest) (*AuthService.OAuthTokenResponse, error) {
+	if !confidentialClient(client) {
+		return nil, a.oauthError(codes.PermissionDenied, OAuthUnauthorizedClient, "client_credentials requires a confidential client")
+	}
+
+	scopes, ok := requestedScopes(req.Scope, client.Scopes)
+	if !ok {
+		return nil, a.oauthError(codes.InvalidArgument, OAuthInvalidScope, "requested scope is not allowed for the client")
+	}
+	scope := strings.Join(scopes, " ")
+
+	tokens, err := a.jwt.CreateClientToken(&jwt.CreateClientTokenParams{
+		ClientId: client.ID,
+		Scope:    scope,
+	})
+	if err != nil {
+		a.log.Errorf("create client token err: client_id = %s: %v", client.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionOAuthToken, audit.OutcomeSuccess, uuid.Nil, client.ID, "grant_type", GrantClientCredentials, "scope", scope)
+
+	return &AuthService.OAuthTokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   oauthTokenTypeBearer,
+		ExpiresIn:   expiresIn(tokens.AccessExpiresAt),
+		Scope:       scope,
+	}, nil
+}
+
+// confidentialClient - клиент, который может доказать, что это он: секретом или подписью своим ключом
+func confidentialClient(client *repo.OAuthClient) bool {
+	return client.SecretHash != nil || client.PublicKey != nil
+}
+
+// requestedScopes разбирает scope запроса: пустой - все разрешения клиента, неразрешённое - ошибка
+func requestedScopes(scope string, allowed []string) ([]string, bool) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return slices.Clone(allowed), true
+	}
+
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return nil, false
+		}
+	}
+	slices.Sort(requested)
+	return slices.Compact(requested), true
+}
+
+// validPKCEValue проверяет длину и алфавит code_verifier и code_challenge (RFC 7636, раздел 4.1)
+func validPKCEValue(value string, minLen, maxLen int) bool {
+	if len(value) < minLen || len(value) > maxLen {
+		return false
+	}
+	for _, c := range value {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCodeChallenge - проверка PKCE S256: BASE64URL(SHA256(code_verifier)) == code_challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if !validPKCEValue(verifier, 43, 128) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// oauthRedirect добавляет параметры ответа к redirect_uri клиента, сохраняя его собственные
+func oauthRedirect(redirectURI string, params url.Values, state string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// oauthRedirectError возвращает пользователя клиенту с ошибкой (RFC 6749, раздел 4.1.2.1)
+func oauthRedirectError(redirectURI, state, code, description string) *AuthService.OAuthAuthorizeResponse {
+	return &AuthService.OAuthAuthorizeResponse{
+		Step: OAuthStepRedirect,
+		RedirectUri: oauthRedirect(redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+		}, state),
+	}
+}
+
+func expiresIn(expiresAt time.Time) int64 {
+	return int64(time.Until(expiresAt).Round(time.Second).Seconds())
+}
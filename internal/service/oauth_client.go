@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxClientNameLength - ограничение колонки oauth_clients.name
+const maxClientNameLength = 100
+
+func (a *authServer) CreateOAuthClient(
+	ctx context.Context,
+	req *AuthService.CreateOAuthClientRequest,
+) (
+	*AuthService.CreateOAuthClientResponse, error,
+) {
+	accessData, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > maxClientNameLength {
+		return nil, status.Error(codes.InvalidArgument, "client name is required and must be at most 100 characters")
+	}
+
+	grantTypes := req.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials:
+		default:
+			return nil, status.Error(codes.InvalidArgument, "unsupported grant type: "+grantType)
+		}
+	}
+	// клиент аутентифицируется одним способом: секретом или подписью своим ключом
+	publicKey := strings.TrimSpace(req.PublicKey)
+	if publicKey != "" {
+		if req.Confidential {
+			return nil, status.Error(codes.InvalidArgument, "client with public_key authenticates with private_key_jwt and gets no secret")
+		}
+		if _, err := jwt.ParsePublicKeyPEM([]byte(publicKey)); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "public_key must be an RSA or ECDSA public key in PEM")
+		}
+	}
+	if slices.Contains(grantTypes, GrantClientCredentials) && !req.Confidential && publicKey == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_credentials requires a confidential client")
+	}
+
+	if slices.Contains(grantTypes, GrantAuthorizationCode) && len(req.RedirectUris) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "redirect_uris are required for authorization_code")
+	}
+	for _, redirectURI := range slices.Concat(req.RedirectUris, req.PostLogoutRedirectUris) {
+		if !validRedirectURI(redirectURI) {
+			return nil, status.Error(codes.InvalidArgument, "invalid redirect uri: "+redirectURI)
+		}
+	}
+
+	for _, scope := range req.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\r\n\"\\") {
+			return nil, status.Error(codes.InvalidArgument, "invalid scope: "+scope)
+		}
+	}
+
+	client := &repo.OAuthClient{
+		ID:                     uuid.NewString(),
+		Name:                   name,
+		RedirectURIs:           req.RedirectUris,
+		PostLogoutRedirectURIs: req.PostLogoutRedirectUris,
+		GrantTypes:             grantTypes,
+		Scopes:                 req.Scopes,
+		FirstParty:             req.FirstParty,
+	}
+	if publicKey != "" {
+		client.PublicKey = &publicKey
+	}
+
+	// секрет показывается один раз, хранится только его хэш
+	var secret string
+	if req.Confidential {
+		secret, err = secure.NewOpaqueToken()
+		if err != nil {
+			a.log.Errorf("generate client secret err: %v", err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		secretHash := secure.HashToken(secret)
+		client.SecretHash = &secretHash
+	}
+
+	if err := a.repo.CreateOAuthClient(ctx, client); err != nil {
+		a.log.Errorf("create oauth client err: name = %s: %v", name, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionCreateOAuthClient, audit.OutcomeSuccess, accessData.UserId, client.ID,
+		"name", client.Name, "grant_types", strings.Join(client.GrantTypes, " "), "scopes", strings.Join(client.Scopes, " "))
+
+	return &AuthService.CreateOAuthClientResponse{
+		Client:       oauthClient(client),
+		ClientSecret: secret,
+	}, nil
+}
+
+func (a *authServer) ListOAuthClients(
+	ctx context.Context,
+	req *AuthService.ListOAuthClientsRequest,
+) (
+	*AuthService.ListOAuthClientsResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	clients, err := a.repo.ListOAuthClients(ctx)
+	if err != nil {
+		a.log.Errorf("list oauth clients err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	resp := &AuthService.ListOAuthClientsResponse{
+		Clients: make([]*AuthService.OAuthClient, 0, len(clients)),
+	}
+	for i := range clients {
+		resp.Clients = append(resp.Clients, oauthClient(&clients[i]))
+	}
+
+	return resp, nil
+}
+
+// DeleteOAuthClient удаляет клиента и завершает все открытые для него сессии
+func (a *authServer) DeleteOAuthClient(
+	ctx context.Context,
+	req *AuthService.DeleteOAuthClientRequest,
+) (
+	*AuthService.DeleteOAuthClientResponse, error,
+) {
+	accessData, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := a.repo.DeleteOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		a.log.Errorf("delete oauth client err: client_id = %s: %v", req.ClientId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !deleted {
+		return nil, status.Error(codes.NotFound, ErrOAuthClientNotFound)
+	}
+	a.syncDenylist(ctx)
+	a.auditEvent(ctx, audit.ActionDeleteOAuthClient, audit.OutcomeSuccess, accessData.UserId, req.ClientId)
+
+	return &AuthService.DeleteOAuthClientResponse{}, nil
+}
+
+func (a *authServer) ListOAuthConsents(
+	ctx context.Context,
+	req *AuthService.ListOAuthConsentsRequest,
+) (
+	*AuthService.ListOAuthConsentsResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := a.repo.ListOAuthConsents(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("list oauth consents err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	resp := &AuthService.ListOAuthConsentsResponse{
+		Consents: make([]*AuthService.OAuthConsent, 0, len(consents)),
+	}
+	for _, c := range consents {
+		resp.Consents = append(resp.Consents, &AuthService.OAuthConsent{
+			ClientId:   c.ClientID,
+			ClientName: c.ClientName,
+			Scopes:     c.Scopes,
+			CreatedAt:  timestamppb.New(c.CreatedAt),
+			UpdatedAt:  timestamppb.New(c.UpdatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+// RevokeOAuthConsent отзывает согласие пользователя и завершает сессии приложения
+func (a *authServer) RevokeOAuthConsent(
+	ctx context.Context,
+	req *AuthService.RevokeOAuthConsentRequest,
+) (
+	*AuthService.RevokeOAuthConsentResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := a.repo.DeleteOAuthConsent(ctx, accessData.UserId, req.ClientId)
+	if err != nil {
+		a.log.Errorf("revoke oauth consent err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !revoked {
+		return nil, status.Error(codes.NotFound, ErrConsentNotFound)
+	}
+	a.syncDenylist(ctx)
+	a.auditEvent(ctx, audit.ActionRevokeOAuthConsent, audit.OutcomeSuccess, accessData.UserId, req.ClientId)
+
+	return &AuthService.RevokeOAuthConsentResponse{}, nil
+}
+
+// validRedirectURI принимает абсолютный адрес без фрагмента (RFC 6749, раздел 3.1.2):
+// https, http только для локальной разработки и собственные схемы мобильных приложений
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file":
+		return false
+	}
+	return true
+}
+
+func oauthClient(client *repo.OAuthClient) *AuthService.OAuthClient {
+	return &AuthService.OAuthClient{
+		Id:                      client.ID,
+		Name:                    client.Name,
+		RedirectUris:            client.RedirectURIs,
+		PostLogoutRedirectUris:  client.PostLogoutRedirectURIs,
+		GrantTypes:              client.GrantTypes,
+		Scopes:                  client.Scopes,
+		FirstParty:              client.FirstParty,
+		Confidential:            confidentialClient(client),
+		TokenEndpointAuthMethod: tokenEndpointAuthMethod(client),
+		CreatedAt:               timestamppb.New(client.CreatedAt),
+	}
+}
+
+func tokenEndpointAuthMethod(client *repo.OAuthClient) string {
+	switch {
+	case client.PublicKey != nil:
+		return "private_key_jwt"
+	case client.SecretHash != nil:
+		return "client_secret_basic"
+	}
+	return "none"
+}
@@ -0,0 +1,464 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/secure"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// пара из RFC 7636, приложение B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{name: "rfc 7636 example", challenge: testCodeChallenge, verifier: testCodeVerifier, want: true},
+		{name: "other verifier", challenge: testCodeChallenge, verifier: strings.Repeat("a", 43), want: false},
+		{name: "plain method", challenge: testCodeVerifier, verifier: testCodeVerifier, want: false},
+		{name: "empty challenge", challenge: "", verifier: testCodeVerifier, want: false},
+		{name: "empty verifier", challenge: testCodeChallenge, verifier: "", want: false},
+		{name: "verifier too short", challenge: testCodeChallenge, verifier: strings.Repeat("a", 42), want: false},
+		{name: "verifier too long", challenge: testCodeChallenge, verifier: strings.Repeat("a", 129), want: false},
+		{name: "verifier not url safe", challenge: testCodeChallenge, verifier: testCodeVerifier[:42] + "+", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.challenge, tt.verifier); got != tt.want {
+				t.Fatalf("verifyCodeChallenge(%q, %q) = %v, want %v", tt.challenge, tt.verifier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestedScopes(t *testing.T) {
+	allowed := []string{"openid", "profile", "email"}
+
+	tests := []struct {
+		name    string
+		scope   string
+		allowed []string
+		want    []string
+		wantOK  bool
+	}{
+		{name: "empty means all allowed", scope: "", allowed: allowed, want: allowed, wantOK: true},
+		{name: "only spaces", scope: "   ", allowed: allowed, want: allowed, wantOK: true},
+		{name: "subset sorted", scope: "profile openid", allowed: allowed, want: []string{"openid", "profile"}, wantOK: true},
+		{name: "duplicates", scope: "email  email openid", allowed: allowed, want: []string{"email", "openid"}, wantOK: true},
+		{name: "not allowed", scope: "openid admin", allowed: allowed, wantOK: false},
+		{name: "case sensitive", scope: "OpenID", allowed: allowed, wantOK: false},
+		{name: "nothing allowed", scope: "openid", allowed: nil, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := requestedScopes(tt.scope, tt.allowed)
+			if ok != tt.wantOK {
+				t.Fatalf("requestedScopes(%q) ok = %v, want %v", tt.scope, ok, tt.wantOK)
+			}
+			if ok && strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("requestedScopes(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+// addOAuthClient регистрирует клиента; с секретом - конфиденциальный, без - публичный
+func (f *fakeRepo) addOAuthClient(id, secret string) *repo.OAuthClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	client := &repo.OAuthClient{
+		ID:           id,
+		Name:         id,
+		RedirectURIs: []string{"https://" + id + ".example.com/callback"},
+		GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken},
+		Scopes:       []string{ScopeProfile, ScopeEmail},
+	}
+	if secret != "" {
+		secretHash := secure.HashToken(secret)
+		client.SecretHash = &secretHash
+	}
+	f.oauthClients[id] = client
+	return client
+}
+
+// addOAuthCode выдаёт клиенту код авторизации с PKCE из RFC 7636
+func (f *fakeRepo) addOAuthCode(client *repo.OAuthClient, userID uuid.UUID, code string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.oauthCodes[secure.HashToken(code)] = &repo.OAuthCode{
+		CodeHash:            secure.HashToken(code),
+		ClientID:            client.ID,
+		UserID:              userID,
+		RedirectURI:         client.RedirectURIs[0],
+		Scope:               ScopeProfile,
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+		CreatedAt:           time.Now(),
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}
+}
+
+func exchangeCode(srv *authServer, clientID, clientSecret, code string) (*AuthService.OAuthTokenResponse, error) {
+	return srv.OAuthToken(context.Background(), &AuthService.OAuthTokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+		Code:         code,
+		CodeVerifier: testCodeVerifier,
+	})
+}
+
+func TestExchangeOAuthCodeOtherClient(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	app := f.addOAuthClient("app", "app-secret")
+	f.addOAuthClient("other", "")
+	f.addOAuthCode(app, user.ID, "code-1")
+
+	// чужой код не гасится и не открывает сессию
+	if _, err := exchangeCode(srv, "other", "", "code-1"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("exchange by another client error = %v, want InvalidArgument", err)
+	}
+	if f.oauthCodes[secure.HashToken("code-1")].UsedAt != nil {
+		t.Fatal("code was consumed by another client")
+	}
+
+	resp, err := exchangeCode(srv, "app", "app-secret", "code-1")
+	if err != nil {
+		t.Fatalf("exchange by the client error = %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.Scope != ScopeProfile {
+		t.Fatalf("exchange response = %+v", resp)
+	}
+	sessionID := *f.oauthCodes[secure.HashToken("code-1")].SessionID
+
+	// повтор чужим клиентом не отзывает сессию, повтор самим клиентом - отзывает
+	if _, err := exchangeCode(srv, "other", "", "code-1"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("replay by another client error = %v, want InvalidArgument", err)
+	}
+	if f.sessions[sessionID].RevokedAt != nil {
+		t.Fatal("replay by another client revoked the session")
+	}
+	if _, err := exchangeCode(srv, "app", "app-secret", "code-1"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("replay by the client error = %v, want InvalidArgument", err)
+	}
+	if f.sessions[sessionID].RevokedAt == nil {
+		t.Fatal("code replay did not revoke the session")
+	}
+}
+
+func TestRefreshClientToken(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	ctx := context.Background()
+	user := f.addUser("alice")
+	app := f.addOAuthClient("app", "app-secret")
+	f.addOAuthClient("other", "")
+	f.addOAuthCode(app, user.ID, "code-1")
+
+	tokens, err := exchangeCode(srv, "app", "app-secret", "code-1")
+	if err != nil {
+		t.Fatalf("exchange error = %v", err)
+	}
+
+	// токен клиента нельзя обновить через Refresh, минуя аутентификацию клиента
+	_, err = srv.Refresh(ctx, &AuthService.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Refresh() of a client token error = %v, want NotFound", err)
+	}
+
+	refresh := func(clientID, clientSecret, refreshToken string) (*AuthService.OAuthTokenResponse, error) {
+		return srv.OAuthToken(ctx, &AuthService.OAuthTokenRequest{
+			GrantType:    GrantRefreshToken,
+			ClientId:     clientID,
+			ClientSecret: clientSecret,
+			RefreshToken: refreshToken,
+		})
+	}
+	if _, err := refresh("app", "wrong-secret", tokens.RefreshToken); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("refresh with a wrong secret error = %v, want Unauthenticated", err)
+	}
+	if _, err := refresh("other", "", tokens.RefreshToken); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("refresh by another client error = %v, want InvalidArgument", err)
+	}
+
+	// отказы выше токен не погасили: клиент обновляет его сам
+	rotated, err := refresh("app", "app-secret", tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh by the client error = %v", err)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == tokens.RefreshToken || rotated.Scope != ScopeProfile {
+		t.Fatalf("refresh response = %+v", rotated)
+	}
+	if rotated.ExpiresIn <= 0 || rotated.ExpiresIn > int64(srv.cfg.System.AccessTokenTimeout.Seconds()) {
+		t.Fatalf("refresh expires_in = %d", rotated.ExpiresIn)
+	}
+
+	// повторное предъявление обменянного токена отзывает сессию
+	if _, err := refresh("app", "app-secret", tokens.RefreshToken); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("refresh token replay error = %v, want InvalidArgument", err)
+	}
+	if _, err := refresh("app", "app-secret", rotated.RefreshToken); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("refresh in a revoked session error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestRefreshFirstPartyToken(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	ctx := context.Background()
+	user := f.addUser("alice")
+
+	tokens, err := srv.issueTokens(ctx, user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+
+	rotated, err := srv.Refresh(ctx, &AuthService.RefreshRequest{RefreshToken: tokens.RefreshToken, AccessToken: tokens.AccessToken})
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("Refresh() response = %+v", rotated)
+	}
+
+	// токен сессии пользователя не обменивается через OAuthToken ни одним клиентом
+	f.addOAuthClient("app", "app-secret")
+	_, err = srv.OAuthToken(ctx, &AuthService.OAuthTokenRequest{
+		GrantType:    GrantRefreshToken,
+		ClientId:     "app",
+		ClientSecret: "app-secret",
+		RefreshToken: rotated.RefreshToken,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("OAuthToken() with a first-party token error = %v, want InvalidArgument", err)
+	}
+}
+
+// addKeyClient регистрирует сервисного клиента с private_key_jwt и возвращает его закрытый ключ
+func (f *fakeRepo) addKeyClient(t *testing.T, id string) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key := newClientKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.oauthClients[id] = &repo.OAuthClient{
+		ID:         id,
+		Name:       id,
+		PublicKey:  &publicKey,
+		GrantTypes: []string{GrantClientCredentials},
+		Scopes:     []string{ScopeProfile},
+	}
+	return key
+}
+
+func newClientKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+// assertionClaims - claims JWT клиента, которые сервер примет
+func assertionClaims(srv *authServer, clientID, tokenID string) jwtgo.MapClaims {
+	return jwtgo.MapClaims{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": srv.publicURL("/oauth/token"),
+		"jti": tokenID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+func signAssertion(t *testing.T, key *ecdsa.PrivateKey, claims jwtgo.MapClaims) string {
+	t.Helper()
+
+	assertion, err := jwtgo.NewWithClaims(jwtgo.SigningMethodES256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign assertion: %v", err)
+	}
+	return assertion
+}
+
+func clientCredentialsRequest(clientID, assertion string) *AuthService.OAuthTokenRequest {
+	return &AuthService.OAuthTokenRequest{
+		GrantType:           GrantClientCredentials,
+		ClientId:            clientID,
+		ClientAssertionType: clientAssertionTypeJWT,
+		ClientAssertion:     assertion,
+	}
+}
+
+func TestClientAssertion(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	key := f.addKeyClient(t, "svc")
+	f.addKeyClient(t, "other")
+	otherKey := newClientKey(t)
+
+	tests := []struct {
+		name string
+		// change правит запрос с верным JWT клиента svc
+		change func(req *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims)
+		// signer - ключ подписи, nil - ключ svc
+		signer *ecdsa.PrivateKey
+		want   codes.Code
+	}{
+		{name: "valid", want: codes.OK},
+		{
+			name:   "client found by iss",
+			change: func(req *AuthService.OAuthTokenRequest, _ jwtgo.MapClaims) { req.ClientId = "" },
+			want:   codes.OK,
+		},
+		{
+			name:   "issuer audience",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) { claims["aud"] = srv.cfg.System.Issuer },
+			want:   codes.OK,
+		},
+		{name: "other key", signer: otherKey, want: codes.Unauthenticated},
+		{
+			name: "issued by another client",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) {
+				claims["iss"], claims["sub"] = "other", "other"
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "wrong audience",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) {
+				claims["aud"] = "https://elsewhere.example.com"
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "expired",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "expires too late",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) {
+				claims["exp"] = time.Now().Add(2 * time.Hour).Unix()
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name:   "without jti",
+			change: func(_ *AuthService.OAuthTokenRequest, claims jwtgo.MapClaims) { delete(claims, "jti") },
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "secret instead of jwt",
+			change: func(req *AuthService.OAuthTokenRequest, _ jwtgo.MapClaims) { req.ClientSecret = "secret" },
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "wrong assertion type",
+			change: func(req *AuthService.OAuthTokenRequest, _ jwtgo.MapClaims) { req.ClientAssertionType = "jwt" },
+			want:   codes.Unauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := clientCredentialsRequest("svc", "")
+			claims := assertionClaims(srv, "svc", uuid.NewString())
+			if tt.change != nil {
+				tt.change(req, claims)
+			}
+			signer := key
+			if tt.signer != nil {
+				signer = tt.signer
+			}
+			req.ClientAssertion = signAssertion(t, signer, claims)
+
+			resp, err := srv.OAuthToken(context.Background(), req)
+			if status.Code(err) != tt.want {
+				t.Fatalf("OAuthToken() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == codes.OK && (resp.AccessToken == "" || resp.Scope != ScopeProfile) {
+				t.Fatalf("OAuthToken() response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestClientAssertionReplay(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	ctx := context.Background()
+	key := f.addKeyClient(t, "svc")
+	otherKey := f.addKeyClient(t, "other")
+
+	assertion := signAssertion(t, key, assertionClaims(srv, "svc", "jti-1"))
+	if _, err := srv.OAuthToken(ctx, clientCredentialsRequest("svc", assertion)); err != nil {
+		t.Fatalf("first use error = %v", err)
+	}
+
+	// перехваченный JWT не принимается повторно ни на /token, ни на интроспекции
+	if _, err := srv.OAuthToken(ctx, clientCredentialsRequest("svc", assertion)); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("replay error = %v, want Unauthenticated", err)
+	}
+	flushAudit(t, srv)
+	if last := f.auditEvents[len(f.auditEvents)-1]; last.Target != "svc" || last.Details["reason"] != "client_assertion_reuse" {
+		t.Fatalf("last audit event = %+v, want client_assertion_reuse", last)
+	}
+
+	introspect := func(assertion string) error {
+		_, err := srv.Introspect(ctx, &AuthService.IntrospectRequest{
+			Token:               "not-a-token",
+			ClientId:            "svc",
+			ClientAssertionType: clientAssertionTypeJWT,
+			ClientAssertion:     assertion,
+		})
+		return err
+	}
+	if err := introspect(assertion); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("replay at introspection error = %v, want Unauthenticated", err)
+	}
+	if err := introspect(signAssertion(t, key, assertionClaims(srv, "svc", "jti-2"))); err != nil {
+		t.Fatalf("new jti at introspection error = %v", err)
+	}
+
+	// jti уникален в пределах клиента: другой клиент может использовать тот же
+	otherAssertion := signAssertion(t, otherKey, assertionClaims(srv, "other", "jti-1"))
+	if _, err := srv.OAuthToken(ctx, clientCredentialsRequest("other", otherAssertion)); err != nil {
+		t.Fatalf("same jti by another client error = %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Слой OpenID Connect поверх сервера авторизации OAuth 2.0: id_token при обмене кода со scope openid,
+// discovery, userinfo и выход по запросу клиента
+
+// scope OpenID Connect (Core 1.0, раздел 5.4), клиенту их разрешают так же, как остальные
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+func (a *authServer) GetOpenIDConfiguration(
+	_ context.Context,
+	_ *AuthService.GetOpenIDConfigurationRequest,
+) (
+	*AuthService.GetOpenIDConfigurationResponse, error,
+) {
+	return &AuthService.GetOpenIDConfigurationResponse{
+		Issuer:                            a.cfg.System.Issuer,
+		AuthorizationEndpoint:             a.publicURL("/oauth/authorize"),
+		TokenEndpoint:                     a.publicURL("/oauth/token"),
+		UserinfoEndpoint:                  a.publicURL("/oauth/userinfo"),
+		JwksUri:                           a.publicURL("/.well-known/jwks.json"),
+		EndSessionEndpoint:                a.publicURL("/oauth/logout"),
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{oauthResponseTypeCode},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		CodeChallengeMethodsSupported:              []string{pkceMethodS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "sid", "azp",
+			"name", "given_name", "family_name", "preferred_username", "updated_at",
+			"email", "email_verified",
+		},
+	}, nil
+}
+
+// publicURL - внешний адрес эндпоинта HTTP API
+func (a *authServer) publicURL(path string) string {
+	return strings.TrimRight(a.cfg.System.PublicURL, "/") + path
+}
+
+// UserInfo отдаёт claims пользователя по access токену со scope openid, набор claims определяют остальные scope
+func (a *authServer) UserInfo(
+	ctx context.Context,
+	req *AuthService.UserInfoRequest,
+) (
+	*AuthService.UserInfoResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(accessData.Scope)
+	if !slices.Contains(scopes, ScopeOpenID) {
+		return nil, status.Error(codes.PermissionDenied, ErrScopeNotAllowed)
+	}
+
+	user, err := a.repo.GetUserByID(ctx, accessData.UserId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.Unauthenticated, ErrUserNotFound)
+		}
+		a.log.Errorf("get user err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return userInfo(user, scopes), nil
+}
+
+// OAuthLogout завершает сессию, для которой был выпущен id_token_hint. Истёкший id_token тоже принимается:
+// клиент часто просит выйти, когда токен уже не действует
+func (a *authServer) OAuthLogout(
+	ctx context.Context,
+	req *AuthService.OAuthLogoutRequest,
+) (
+	*AuthService.OAuthLogoutResponse, error,
+) {
+	if req.IdTokenHint == "" {
+		return nil, status.Error(codes.InvalidArgument, "id_token_hint is required")
+	}
+
+	data, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+		Token: req.IdTokenHint,
+	})
+	if err != nil || data.TokenType != jwt.TokenTypeID || data.Issuer != a.cfg.System.Issuer || data.SessionId == uuid.Nil {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidIDTokenHint)
+	}
+
+	clientID := req.ClientId
+	if clientID == "" && len(data.Audience) == 1 {
+		clientID = data.Audience[0]
+	}
+	if !slices.Contains(data.Audience, clientID) {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidIDTokenHint)
+	}
+
+	// адрес возврата проверяется до выхода, чтобы не завершить сессию и не оставить пользователя на чужой ошибке
+	if req.PostLogoutRedirectUri != "" {
+		client, err := a.repo.GetOAuthClient(ctx, clientID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, status.Error(codes.InvalidArgument, ErrOAuthClientNotFound)
+			}
+			a.log.Errorf("get oauth client err: client_id = %s: %v", clientID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		if !slices.Contains(client.PostLogoutRedirectURIs, req.PostLogoutRedirectUri) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidLogoutURI)
+		}
+	}
+
+	// повторный выход из уже завершённой сессии не ошибка
+	revoked, err := a.repo.RevokeSession(ctx, repo.RevokeSessionParams{
+		SessionID: data.SessionId,
+		UserID:    data.UserId,
+	})
+	if err != nil {
+		a.log.Errorf("revoke session err: session_id = %s: %v", data.SessionId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if revoked {
+		a.syncDenylist(ctx)
+		a.auditEvent(ctx, audit.ActionOAuthLogout, audit.OutcomeSuccess, data.UserId, data.SessionId.String(), "client_id", clientID)
+	}
+
+	resp := &AuthService.OAuthLogoutResponse{}
+	if req.PostLogoutRedirectUri != "" {
+		resp.RedirectUri = oauthRedirect(req.PostLogoutRedirectUri, nil, req.State)
+	}
+	return resp, nil
+}
+
+// newIDToken выпускает id_token для сессии, открываемой по коду авторизации
+func (a *authServer) newIDToken(ctx context.Context, code *repo.OAuthCode, sessionID uuid.UUID) (string, error) {
+	user, err := a.repo.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	authTime := code.CreatedAt
+	if code.AuthTime != nil {
+		authTime = *code.AuthTime
+	}
+
+	return a.jwt.CreateIDToken(&jwt.CreateIDTokenParams{
+		UserId:    code.UserID,
+		SessionId: sessionID,
+		ClientId:  code.ClientID,
+		Nonce:     code.Nonce,
+		AuthTime:  authTime,
+		Amr:       code.Amr,
+		Claims:    idTokenClaims(userInfo(user, strings.Fields(code.Scope))),
+	})
+}
+
+// userInfo собирает claims пользователя, разрешённые scope: profile - имя и логин, email - почта
+func userInfo(user *repo.User, scopes []string) *AuthService.UserInfoResponse {
+	info := &AuthService.UserInfoResponse{
+		Sub: user.ID.String(),
+	}
+
+	if slices.Contains(scopes, ScopeProfile) {
+		if user.FirstName != nil {
+			info.GivenName = *user.FirstName
+		}
+		if user.LastName != nil {
+			info.FamilyName = *user.LastName
+		}
+		info.Name = strings.TrimSpace(info.GivenName + " " + info.FamilyName)
+		info.PreferredUsername = user.Username
+		info.UpdatedAt = user.UpdatedAt.Unix()
+	}
+
+	if slices.Contains(scopes, ScopeEmail) {
+		info.Email = user.Email
+		info.EmailVerified = user.EmailVerifiedAt != nil
+	}
+
+	return info
+}
+
+// idTokenClaims переносит в id_token заполненные claims профиля и почты
+func idTokenClaims(info *AuthService.UserInfoResponse) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for name, value := range map[string]string{
+		"name":               info.Name,
+		"given_name":         info.GivenName,
+		"family_name":        info.FamilyName,
+		"preferred_username": info.PreferredUsername,
+	} {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+	if info.UpdatedAt != 0 {
+		claims["updated_at"] = info.UpdatedAt
+	}
+	if info.Email != "" {
+		claims["email"] = info.Email
+		claims["email_verified"] = info.EmailVerified
+	}
+	return claims
+}
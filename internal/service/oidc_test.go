@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// addOpenIDCode выдаёт код, как addOAuthCode, но с заданным scope и данными входа для id_token
+func (f *fakeRepo) addOpenIDCode(client *repo.OAuthClient, userID uuid.UUID, code, scope string, authTime time.Time) {
+	f.addOAuthCode(client, userID, code)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored := f.oauthCodes[secure.HashToken(code)]
+	stored.Scope = scope
+	stored.Nonce = "nonce-1"
+	stored.AuthTime = &authTime
+	stored.Amr = []string{amrPassword, amrOTP, amrMFA}
+}
+
+// parseIDToken разбирает id_token без проверки подписи, её проверяют тесты pkg/jwt
+func parseIDToken(t *testing.T, idToken string) jwtgo.MapClaims {
+	t.Helper()
+
+	claims := jwtgo.MapClaims{}
+	if _, _, err := new(jwtgo.Parser).ParseUnverified(idToken, claims); err != nil {
+		t.Fatalf("failed to parse id_token: %v", err)
+	}
+	return claims
+}
+
+func TestIDTokenClaims(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	app := f.addOAuthClient("app", "app-secret")
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	f.addOpenIDCode(app, user.ID, "code-1", ScopeOpenID+" "+ScopeProfile, authTime)
+
+	resp, err := exchangeCode(srv, "app", "app-secret", "code-1")
+	if err != nil {
+		t.Fatalf("exchange error = %v", err)
+	}
+	if resp.IdToken == "" {
+		t.Fatal("exchange with scope openid returned no id_token")
+	}
+
+	claims := parseIDToken(t, resp.IdToken)
+	if claims["nonce"] != "nonce-1" {
+		t.Fatalf("nonce = %v, want nonce-1", claims["nonce"])
+	}
+	if got, _ := claims["auth_time"].(float64); int64(got) != authTime.Unix() {
+		t.Fatalf("auth_time = %v, want %d", claims["auth_time"], authTime.Unix())
+	}
+	amr, _ := claims["amr"].([]interface{})
+	if len(amr) != 3 || amr[0] != amrPassword || amr[1] != amrOTP || amr[2] != amrMFA {
+		t.Fatalf("amr = %v, want [%s %s %s]", claims["amr"], amrPassword, amrOTP, amrMFA)
+	}
+	if claims["sub"] != user.ID.String() || claims["aud"] != "app" || claims["token_use"] != jwt.TokenTypeID {
+		t.Fatalf("id_token claims = %v", claims)
+	}
+	if claims["preferred_username"] != "alice" || claims["email"] != nil {
+		t.Fatalf("id_token profile claims = %v, want only profile", claims)
+	}
+
+	// без scope openid id_token не выпускается
+	f.addOpenIDCode(app, user.ID, "code-2", ScopeProfile, authTime)
+	resp, err = exchangeCode(srv, "app", "app-secret", "code-2")
+	if err != nil || resp.IdToken != "" {
+		t.Fatalf("exchange without openid = %+v, %v, want no id_token", resp, err)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	ctx := context.Background()
+	user := f.addUser("alice")
+	app := f.addOAuthClient("app", "app-secret")
+	f.addOpenIDCode(app, user.ID, "code-1", ScopeOpenID+" "+ScopeEmail, time.Now())
+	f.addOpenIDCode(app, user.ID, "code-2", ScopeProfile+" "+ScopeEmail, time.Now())
+
+	openID, err := exchangeCode(srv, "app", "app-secret", "code-1")
+	if err != nil {
+		t.Fatalf("exchange error = %v", err)
+	}
+	info, err := srv.UserInfo(ctx, &AuthService.UserInfoRequest{AccessToken: openID.AccessToken})
+	if err != nil {
+		t.Fatalf("UserInfo() error = %v", err)
+	}
+	if info.Sub != user.ID.String() || info.Email != user.Email || info.PreferredUsername != "" {
+		t.Fatalf("UserInfo() = %+v, want sub and email only", info)
+	}
+
+	// access токен без scope openid для userinfo не годится, как и обычный токен входа
+	plain, err := exchangeCode(srv, "app", "app-secret", "code-2")
+	if err != nil {
+		t.Fatalf("exchange error = %v", err)
+	}
+	tokens := login(t, srv, "alice")
+	for _, accessToken := range []string{plain.AccessToken, tokens.AccessToken} {
+		_, err := srv.UserInfo(ctx, &AuthService.UserInfoRequest{AccessToken: accessToken})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("UserInfo() without openid error = %v, want PermissionDenied", err)
+		}
+	}
+}
+
+func TestOAuthLogout(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	ctx := context.Background()
+	user := f.addUser("alice")
+	app := f.addOAuthClient("app", "app-secret")
+	app.PostLogoutRedirectURIs = []string{"https://app.example.com/logged-out"}
+	f.addOpenIDCode(app, user.ID, "code-1", ScopeOpenID, time.Now())
+
+	resp, err := exchangeCode(srv, "app", "app-secret", "code-1")
+	if err != nil {
+		t.Fatalf("exchange error = %v", err)
+	}
+	sessionID := *f.oauthCodes[secure.HashToken("code-1")].SessionID
+	claims := parseIDToken(t, resp.IdToken)
+
+	// signed - id_token той же сессии, подписанный ключом сервера, с заменой отдельных claims
+	signed := func(overrides jwtgo.MapClaims) string {
+		c := jwtgo.MapClaims{}
+		for name, value := range claims {
+			c[name] = value
+		}
+		for name, value := range overrides {
+			c[name] = value
+		}
+		key := jwt.NewKey(testSigningKey, time.Time{})
+		token := jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, c)
+		token.Header["kid"] = key.ID
+		hint, err := token.SignedString(key.PrivateKey)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return hint
+	}
+
+	tests := []struct {
+		name        string
+		hint        string
+		redirectURI string
+		want        string
+	}{
+		{name: "access token", hint: resp.AccessToken, want: ErrInvalidIDTokenHint},
+		{name: "wrong token_use", hint: signed(jwtgo.MapClaims{"token_use": jwt.TokenTypeAccess}), want: ErrInvalidIDTokenHint},
+		{name: "wrong issuer", hint: signed(jwtgo.MapClaims{"iss": "other-service"}), want: ErrInvalidIDTokenHint},
+		{name: "another client", hint: signed(jwtgo.MapClaims{"aud": "other"}), redirectURI: app.PostLogoutRedirectURIs[0], want: ErrInvalidIDTokenHint},
+		{name: "unregistered redirect", hint: resp.IdToken, redirectURI: "https://evil.example.com/", want: ErrInvalidLogoutURI},
+		{name: "login redirect", hint: resp.IdToken, redirectURI: app.RedirectURIs[0], want: ErrInvalidLogoutURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.OAuthLogout(ctx, &AuthService.OAuthLogoutRequest{
+				IdTokenHint:           tt.hint,
+				ClientId:              "app",
+				PostLogoutRedirectUri: tt.redirectURI,
+			})
+			if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != tt.want {
+				t.Fatalf("OAuthLogout() error = %v, want %q", err, tt.want)
+			}
+			if f.sessions[sessionID].RevokedAt != nil {
+				t.Fatal("rejected logout revoked the session")
+			}
+		})
+	}
+
+	logout, err := srv.OAuthLogout(ctx, &AuthService.OAuthLogoutRequest{
+		IdTokenHint:           resp.IdToken,
+		PostLogoutRedirectUri: app.PostLogoutRedirectURIs[0],
+		State:                 "state-1",
+	})
+	if err != nil {
+		t.Fatalf("OAuthLogout() error = %v", err)
+	}
+	if logout.RedirectUri != "https://app.example.com/logged-out?state=state-1" {
+		t.Fatalf("OAuthLogout() redirect = %s", logout.RedirectUri)
+	}
+	if f.sessions[sessionID].RevokedAt == nil || validate(srv, resp.AccessToken) != codes.Unauthenticated {
+		t.Fatal("OAuthLogout() did not end the session")
+	}
+	flushAudit(t, srv)
+	if last := f.auditEvents[len(f.auditEvents)-1]; last.Action != audit.ActionOAuthLogout || last.Target != sessionID.String() {
+		t.Fatalf("last audit event = %+v, want %s", last, audit.ActionOAuthLogout)
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkNewPassword проверяет сложность нового пароля и то, что он не совпадает с текущим и недавними
+func (a *authServer) checkNewPassword(ctx context.Context, userID uuid.UUID, newPassword string) error {
+	passwordValidityCheck, err := secure.IsValidPassword(newPassword)
+	if !passwordValidityCheck {
+		errMsg := "invalid password"
+		if err != nil {
+			errMsg = err.Error()
+		}
+		return status.Error(codes.InvalidArgument, errMsg)
+	}
+
+	current, err := a.repo.GetPassword(ctx, userID)
+	if err != nil {
+		a.log.Errorf("get password err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+
+	history, err := a.repo.GetPasswordHistory(ctx, userID, a.cfg.System.PasswordHistorySize)
+	if err != nil {
+		a.log.Errorf("get password history err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+
+	for _, hash := range append([]string{current}, history...) {
+		if secure.CheckPassword(hash, newPassword) == nil {
+			return status.Error(codes.InvalidArgument, ErrPasswordReused)
+		}
+	}
+	return nil
+}
+
+func (a *authServer) setPassword(ctx context.Context, userID uuid.UUID, newPassword string) error {
+	passwordHash, err := secure.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return a.repo.UpdatePassword(ctx, repo.UpdatePasswordParams{
+		UserID:       userID,
+		PasswordHash: passwordHash,
+		HistorySize:  a.cfg.System.PasswordHistorySize,
+	})
+}
+
+// RequestPasswordReset отправляет ссылку для сброса пароля. Ответ одинаковый для существующей
+// и несуществующей почты, чтобы по нему нельзя было перебирать пользователей
+func (a *authServer) RequestPasswordReset(
+	ctx context.Context,
+	req *AuthService.RequestPasswordResetRequest,
+) (
+	*AuthService.RequestPasswordResetResponse, error,
+) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	user, err := a.repo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			a.log.Errorf("get user by email err: %v", err)
+		}
+		return &AuthService.RequestPasswordResetResponse{}, nil
+	}
+
+	token, err := a.newUserToken(ctx, user.ID, repo.UserTokenPasswordReset, "", a.cfg.System.PasswordResetTimeout)
+	if err != nil {
+		a.log.Errorf("create password reset token err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.sendPasswordReset(ctx, user.Email, token); err != nil {
+		a.log.Errorf("send password reset err: user_id = %s: %v", user.ID, err)
+	}
+
+	return &AuthService.RequestPasswordResetResponse{}, nil
+}
+
+// ConfirmPasswordReset устанавливает новый пароль и завершает все сессии пользователя
+func (a *authServer) ConfirmPasswordReset(
+	ctx context.Context,
+	req *AuthService.ConfirmPasswordResetRequest,
+) (
+	*AuthService.ConfirmPasswordResetResponse, error,
+) {
+	tokenHash := secure.HashToken(req.Token)
+
+	// токен гасится только после проверки пароля, чтобы из-за неподходящего пароля не пришлось запрашивать новое письмо
+	token, err := a.repo.GetUserToken(ctx, repo.UserTokenPasswordReset, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidResetToken)
+		}
+		a.log.Errorf("get password reset token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.checkNewPassword(ctx, token.UserID, req.NewPassword); err != nil {
+		return nil, err
+	}
+
+	if _, err := a.repo.UseUserToken(ctx, repo.UserTokenPasswordReset, tokenHash); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.InvalidArgument, ErrInvalidResetToken)
+		}
+		a.log.Errorf("use password reset token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if err := a.setPassword(ctx, token.UserID, req.NewPassword); err != nil {
+		a.log.Errorf("update password err: user_id = %s: %v", token.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// тот, кто знал старый пароль, не должен остаться в системе
+	err = a.repo.RevokeUserSessions(ctx, repo.RevokeUserSessionsParams{
+		UserID:          token.UserID,
+		ExceptSessionID: uuid.Nil,
+	})
+	if err != nil {
+		a.log.Errorf("revoke sessions err: user_id = %s: %v", token.UserID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.syncDenylist(ctx)
+
+	a.securityEvent(ctx, EventPasswordReset, token.UserID)
+
+	return &AuthService.ConfirmPasswordResetResponse{}, nil
+}
+
+// ChangePassword меняет пароль по текущему паролю, при необходимости завершая остальные сессии
+func (a *authServer) ChangePassword(
+	ctx context.Context,
+	req *AuthService.ChangePasswordRequest,
+) (
+	*AuthService.ChangePasswordResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := a.repo.GetPassword(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("get password err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// с украденным access токеном пароль подбирают здесь, поэтому действует та же блокировка, что и при входе
+	if err := a.checkLoginLock(ctx, accessData.UserId); err != nil {
+		return nil, err
+	}
+	if err := secure.CheckPassword(current, req.CurrentPassword); err != nil {
+		a.loginFailed(ctx, accessData.UserId)
+		return nil, status.Error(codes.Unauthenticated, ErrInvalidPassword)
+	}
+
+	if err := a.checkNewPassword(ctx, accessData.UserId, req.NewPassword); err != nil {
+		return nil, err
+	}
+
+	if err := a.setPassword(ctx, accessData.UserId, req.NewPassword); err != nil {
+		a.log.Errorf("update password err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	if req.RevokeOtherSessions {
+		err = a.repo.RevokeUserSessions(ctx, repo.RevokeUserSessionsParams{
+			UserID:          accessData.UserId,
+			ExceptSessionID: accessData.SessionId,
+		})
+		if err != nil {
+			a.log.Errorf("revoke other sessions err: user_id = %s: %v", accessData.UserId, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		a.syncDenylist(ctx)
+	}
+
+	a.securityEvent(ctx, EventPasswordChanged, accessData.UserId, "revoke_other_sessions", req.RevokeOtherSessions)
+
+	return &AuthService.ChangePasswordResponse{}, nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+
+	// ответ тот же, что и для зарегистрированного адреса, но письмо не отправляется
+	resp, err := srv.RequestPasswordReset(context.Background(), &AuthService.RequestPasswordResetRequest{Email: "mallory@example.com"})
+	if err != nil || resp == nil {
+		t.Fatalf("RequestPasswordReset() = %v, %v", resp, err)
+	}
+	if got := len(sentMail(srv)); got != 0 {
+		t.Fatalf("sent mails = %d, want 0", got)
+	}
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	ctx := context.Background()
+	first := login(t, srv, "alice")
+	second := login(t, srv, "alice")
+
+	if _, err := srv.RequestPasswordReset(ctx, &AuthService.RequestPasswordResetRequest{Email: user.Email}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	token := mailToken(t, srv, user.Email)
+
+	req := &AuthService.ConfirmPasswordResetRequest{Token: token, NewPassword: "NewPassword456*"}
+	if _, err := srv.ConfirmPasswordReset(ctx, req); err != nil {
+		t.Fatalf("ConfirmPasswordReset() error = %v", err)
+	}
+
+	// сброс завершает все сессии: ни access, ни refresh токены больше не действуют
+	for _, tokens := range []*AuthService.LoginResponse{first, second} {
+		if code := validate(srv, tokens.AccessToken); code != codes.Unauthenticated {
+			t.Fatalf("Validate() after reset = %v, want Unauthenticated", code)
+		}
+		_, err := srv.Refresh(ctx, &AuthService.RefreshRequest{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Refresh() after reset error = %v, want Unauthenticated", err)
+		}
+	}
+
+	if _, err := srv.Login(ctx, &AuthService.LoginRequest{Username: "alice", Password: "NewPassword456*"}); err != nil {
+		t.Fatalf("Login() with the new password error = %v", err)
+	}
+
+	// ссылка из письма одноразовая
+	req.NewPassword = "OtherPassword789*"
+	_, err := srv.ConfirmPasswordReset(ctx, req)
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != ErrInvalidResetToken {
+		t.Fatalf("ConfirmPasswordReset() reuse error = %v, want %q", err, ErrInvalidResetToken)
+	}
+}
+
+func TestConfirmPasswordResetSupersededToken(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	ctx := context.Background()
+
+	// новая ссылка отменяет предыдущую
+	if _, err := srv.RequestPasswordReset(ctx, &AuthService.RequestPasswordResetRequest{Email: user.Email}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	old := mailToken(t, srv, user.Email)
+	if _, err := srv.RequestPasswordReset(ctx, &AuthService.RequestPasswordResetRequest{Email: user.Email}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+
+	_, err := srv.ConfirmPasswordReset(ctx, &AuthService.ConfirmPasswordResetRequest{Token: old, NewPassword: "NewPassword456*"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ConfirmPasswordReset() with a superseded token error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	ctx := context.Background()
+	current := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+
+	_, err := srv.ChangePassword(ctx, &AuthService.ChangePasswordRequest{
+		AccessToken:     current.AccessToken,
+		CurrentPassword: "WrongPassword1*",
+		NewPassword:     "NewPassword456*",
+	})
+	if status.Code(err) != codes.Unauthenticated || status.Convert(err).Message() != ErrInvalidPassword {
+		t.Fatalf("ChangePassword() with a wrong current password error = %v, want %q", err, ErrInvalidPassword)
+	}
+
+	_, err = srv.ChangePassword(ctx, &AuthService.ChangePasswordRequest{
+		AccessToken:         current.AccessToken,
+		CurrentPassword:     "Password123!",
+		NewPassword:         "NewPassword456*",
+		RevokeOtherSessions: true,
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+
+	// сессия, из которой сменили пароль, остаётся, остальные завершаются
+	if code := validate(srv, current.AccessToken); code != codes.OK {
+		t.Fatalf("Validate() of the current session = %v, want OK", code)
+	}
+	if code := validate(srv, other.AccessToken); code != codes.Unauthenticated {
+		t.Fatalf("Validate() of another session = %v, want Unauthenticated", code)
+	}
+	_, err = srv.Refresh(ctx, &AuthService.RefreshRequest{AccessToken: other.AccessToken, RefreshToken: other.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh() of another session error = %v, want Unauthenticated", err)
+	}
+
+	if _, err := srv.Login(ctx, &AuthService.LoginRequest{Username: "alice", Password: "NewPassword456*"}); err != nil {
+		t.Fatalf("Login() with the new password error = %v", err)
+	}
+}
+
+func TestChangePasswordKeepsOtherSessions(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	current := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+
+	_, err := srv.ChangePassword(context.Background(), &AuthService.ChangePasswordRequest{
+		AccessToken:     current.AccessToken,
+		CurrentPassword: "Password123!",
+		NewPassword:     "NewPassword456*",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if code := validate(srv, other.AccessToken); code != codes.OK {
+		t.Fatalf("Validate() of another session = %v, want OK", code)
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	ctx := context.Background()
+	tokens := login(t, srv, "alice")
+
+	// история из двух прежних паролей (PasswordHistorySize в newTestServer)
+	change := func(currentPassword, newPassword string) error {
+		_, err := srv.ChangePassword(ctx, &AuthService.ChangePasswordRequest{
+			AccessToken:     tokens.AccessToken,
+			CurrentPassword: currentPassword,
+			NewPassword:     newPassword,
+		})
+		return err
+	}
+
+	steps := []struct {
+		name    string
+		current string
+		new     string
+		reused  bool
+	}{
+		{name: "first change", current: "Password123!", new: "First123*"},
+		{name: "same as current", current: "First123*", new: "First123*", reused: true},
+		{name: "second change", current: "First123*", new: "Second456*"},
+		{name: "previous password", current: "Second456*", new: "First123*", reused: true},
+		{name: "third change", current: "Second456*", new: "Third789*"},
+		{name: "fourth change", current: "Third789*", new: "Fourth012*"},
+		{name: "password beyond history", current: "Fourth012*", new: "First123*"},
+	}
+	for _, step := range steps {
+		err := change(step.current, step.new)
+		if step.reused {
+			if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != ErrPasswordReused {
+				t.Fatalf("%s: ChangePassword() error = %v, want %q", step.name, err, ErrPasswordReused)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: ChangePassword() error = %v", step.name, err)
+		}
+	}
+}
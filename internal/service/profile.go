@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"unicode/utf8"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxNameLength - размер колонок first_name и last_name
+const maxNameLength = 100
+
+// поля профиля, которые пользователь может менять сам
+const (
+	profileFieldFirstName = "first_name"
+	profileFieldLastName  = "last_name"
+)
+
+func (a *authServer) getProfile(ctx context.Context, userID uuid.UUID) (*AuthService.User, error) {
+	user, err := a.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("get user err: user_id = %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	return userProfile(user), nil
+}
+
+func (a *authServer) GetMe(
+	ctx context.Context,
+	req *AuthService.GetMeRequest,
+) (
+	*AuthService.GetMeResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.getProfile(ctx, accessData.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthService.GetMeResponse{
+		User: user,
+	}, nil
+}
+
+// GetUser отдаёт профиль владельцу или администратору
+func (a *authServer) GetUser(
+	ctx context.Context,
+	req *AuthService.GetUserRequest,
+) (
+	*AuthService.GetUserResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := uuid.Parse(req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user id format")
+	}
+
+	if userID != accessData.UserId {
+		if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := a.getProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthService.GetUserResponse{
+		User: user,
+	}, nil
+}
+
+// UpdateProfile меняет поля профиля из update_mask
+func (a *authServer) UpdateProfile(
+	ctx context.Context,
+	req *AuthService.UpdateProfileRequest,
+) (
+	*AuthService.UpdateProfileResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	paths := req.UpdateMask.GetPaths()
+	if len(paths) == 0 {
+		paths = []string{profileFieldFirstName, profileFieldLastName}
+	}
+
+	params := repo.UpdateProfileParams{
+		UserID: accessData.UserId,
+	}
+	for _, path := range paths {
+		switch path {
+		case profileFieldFirstName:
+			params.FirstName = &req.User.FirstName
+		case profileFieldLastName:
+			params.LastName = &req.User.LastName
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "field %q cannot be updated", path)
+		}
+	}
+
+	for _, name := range []*string{params.FirstName, params.LastName} {
+		if name != nil && utf8.RuneCountInString(*name) > maxNameLength {
+			return nil, status.Errorf(codes.InvalidArgument, "name must be at most %d characters", maxNameLength)
+		}
+	}
+
+	user, err := a.repo.UpdateProfile(ctx, params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("update profile err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.UpdateProfileResponse{
+		User: userProfile(user),
+	}, nil
+}
+
+func userProfile(user *repo.User) *AuthService.User {
+	profile := &AuthService.User{
+		Id:            user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		CreatedAt:     timestamppb.New(user.CreatedAt),
+		UpdatedAt:     timestamppb.New(user.UpdatedAt),
+	}
+	if user.FirstName != nil {
+		profile.FirstName = *user.FirstName
+	}
+	if user.LastName != nil {
+		profile.LastName = *user.LastName
+	}
+	return profile
+}
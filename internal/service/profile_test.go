@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestGetUser(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	alice := f.addUser("alice")
+	f.addUser("bob")
+	admin := f.addUser("admin")
+	f.grant(admin.ID, PermissionAdmin)
+
+	tests := []struct {
+		name   string
+		caller string
+		userID string
+		want   codes.Code
+	}{
+		{name: "owner", caller: "alice", userID: alice.ID.String(), want: codes.OK},
+		{name: "another user", caller: "bob", userID: alice.ID.String(), want: codes.PermissionDenied},
+		{name: "admin", caller: "admin", userID: alice.ID.String(), want: codes.OK},
+		{name: "admin, unknown user", caller: "admin", userID: uuid.NewString(), want: codes.NotFound},
+		{name: "invalid id", caller: "bob", userID: "bob", want: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := login(t, srv, tt.caller)
+			resp, err := srv.GetUser(context.Background(), &AuthService.GetUserRequest{
+				AccessToken: tokens.AccessToken,
+				UserId:      tt.userID,
+			})
+			if status.Code(err) != tt.want {
+				t.Fatalf("GetUser() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == codes.OK && resp.User.Username != "alice" {
+				t.Fatalf("GetUser() user = %s, want alice", resp.User.Username)
+			}
+		})
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	ctx := context.Background()
+
+	update := func(firstName, lastName string, paths ...string) (*AuthService.User, error) {
+		req := &AuthService.UpdateProfileRequest{
+			AccessToken: tokens.AccessToken,
+			User:        &AuthService.User{FirstName: firstName, LastName: lastName},
+		}
+		if paths != nil {
+			req.UpdateMask = &fieldmaskpb.FieldMask{Paths: paths}
+		}
+		resp, err := srv.UpdateProfile(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.User, nil
+	}
+
+	// без маски меняются все изменяемые поля
+	user, err := update("Alice", "Smith")
+	if err != nil || user.FirstName != "Alice" || user.LastName != "Smith" {
+		t.Fatalf("UpdateProfile() = %v, %v", user, err)
+	}
+
+	// поля вне маски не меняются, даже если переданы
+	user, err = update("Alicia", "Jones", "first_name")
+	if err != nil || user.FirstName != "Alicia" || user.LastName != "Smith" {
+		t.Fatalf("UpdateProfile(first_name) = %v, %v", user, err)
+	}
+
+	// пустое значение из маски очищает поле
+	user, err = update("", "", "last_name")
+	if err != nil || user.FirstName != "Alicia" || user.LastName != "" {
+		t.Fatalf("UpdateProfile(last_name) = %v, %v", user, err)
+	}
+
+	// почту и другие поля через UpdateProfile не поменять
+	for _, path := range []string{"email", "username", "email_verified"} {
+		if _, err := update("", "", path); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("UpdateProfile(%s) error = %v, want InvalidArgument", path, err)
+		}
+	}
+
+	if _, err := update(strings.Repeat("я", maxNameLength+1), "", "first_name"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("UpdateProfile() with a long name error = %v, want InvalidArgument", err)
+	}
+
+	me, err := srv.GetMe(ctx, &AuthService.GetMeRequest{AccessToken: tokens.AccessToken})
+	if err != nil {
+		t.Fatalf("GetMe() error = %v", err)
+	}
+	if me.User.FirstName != "Alicia" || me.User.LastName != "" || me.User.Email != "alice@example.com" {
+		t.Fatalf("GetMe() user = %v", me.User)
+	}
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// PermissionAdmin - право на управление сервисом аутентификации, выдаётся ролью admin
+const PermissionAdmin = "auth:admin"
+
+// requirePermission проверяет access токен и право пользователя на все ресурсы.
+// Права читаются из БД, а не из токена, чтобы отзыв роли действовал сразу.
+// Токен с разрешениями (scope) должен включать и само право. Сервисному аккаунту право даёт только scope,
+// и оно должно быть по-прежнему разрешено клиенту
+func (a *authServer) requirePermission(ctx context.Context, accessToken, permission string) (*jwt.GetDataFromTokenResponse, error) {
+	accessData, err := a.authenticateToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// токен, выданный приложению по OAuth, действует только в пределах своих разрешений
+	if accessData.Scope != "" && !slices.Contains(strings.Fields(accessData.Scope), permission) {
+		return nil, status.Error(codes.PermissionDenied, ErrScopeNotAllowed)
+	}
+
+	if accessData.IsClient() {
+		client, err := a.serviceAccount(ctx, accessData.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		if accessData.Scope == "" || !slices.Contains(client.Scopes, permission) {
+			return nil, status.Error(codes.PermissionDenied, ErrPermissionDenied)
+		}
+		return accessData, nil
+	}
+
+	allowed, err := a.repo.HasPermission(ctx, repo.HasPermissionParams{
+		UserID:     accessData.UserId,
+		Permission: permission,
+		Resource:   repo.AllResources,
+	})
+	if err != nil {
+		a.log.Errorf("check permission err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !allowed {
+		return nil, status.Error(codes.PermissionDenied, ErrPermissionDenied)
+	}
+
+	return accessData, nil
+}
+
+func (a *authServer) Authorize(
+	ctx context.Context,
+	req *AuthService.AuthorizeRequest,
+) (
+	*AuthService.AuthorizeResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Permission == "" {
+		return nil, status.Error(codes.InvalidArgument, "permission is required")
+	}
+
+	resource := req.Resource
+	if resource == "" {
+		resource = repo.AllResources
+	}
+
+	allowed, err := a.repo.HasPermission(ctx, repo.HasPermissionParams{
+		UserID:     accessData.UserId,
+		Permission: req.Permission,
+		Resource:   resource,
+	})
+	if err != nil {
+		a.log.Errorf("check permission err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.AuthorizeResponse{
+		Allowed: allowed,
+	}, nil
+}
+
+func (a *authServer) CreateRole(
+	ctx context.Context,
+	req *AuthService.CreateRoleRequest,
+) (
+	*AuthService.CreateRoleResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "role name is required")
+	}
+
+	_, err := a.repo.CreateRole(ctx, &repo.Role{
+		Name:        req.Name,
+		Description: req.Description,
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return nil, status.Error(codes.AlreadyExists, ErrRoleAlreadyExist)
+		}
+		a.log.Errorf("create role err: name = %s: %v", req.Name, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.CreateRoleResponse{}, nil
+}
+
+func (a *authServer) DeleteRole(
+	ctx context.Context,
+	req *AuthService.DeleteRoleRequest,
+) (
+	*AuthService.DeleteRoleResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	deleted, err := a.repo.DeleteRole(ctx, req.Name)
+	if err != nil {
+		a.log.Errorf("delete role err: name = %s: %v", req.Name, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !deleted {
+		return nil, status.Error(codes.NotFound, ErrRoleNotFound)
+	}
+
+	return &AuthService.DeleteRoleResponse{}, nil
+}
+
+func (a *authServer) ListRoles(
+	ctx context.Context,
+	req *AuthService.ListRolesRequest,
+) (
+	*AuthService.ListRolesResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	roles, err := a.repo.ListRoles(ctx)
+	if err != nil {
+		a.log.Errorf("list roles err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	resp := &AuthService.ListRolesResponse{
+		Roles: make([]*AuthService.Role, 0, len(roles)),
+	}
+	for _, r := range roles {
+		resp.Roles = append(resp.Roles, &AuthService.Role{
+			Name:        r.Name,
+			Description: r.Description,
+			Permissions: r.Permissions,
+			CreatedAt:   timestamppb.New(r.CreatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (a *authServer) GrantPermission(
+	ctx context.Context,
+	req *AuthService.GrantPermissionRequest,
+) (
+	*AuthService.GrantPermissionResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	if req.Permission == "" {
+		return nil, status.Error(codes.InvalidArgument, "permission is required")
+	}
+
+	granted, err := a.repo.GrantPermission(ctx, repo.RolePermissionParams{
+		RoleName:   req.Role,
+		Permission: req.Permission,
+	})
+	if err != nil {
+		a.log.Errorf("grant permission err: role = %s: %v", req.Role, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !granted {
+		return nil, status.Error(codes.NotFound, ErrRoleNotFound)
+	}
+
+	return &AuthService.GrantPermissionResponse{}, nil
+}
+
+func (a *authServer) RevokePermission(
+	ctx context.Context,
+	req *AuthService.RevokePermissionRequest,
+) (
+	*AuthService.RevokePermissionResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	err := a.repo.RevokePermission(ctx, repo.RolePermissionParams{
+		RoleName:   req.Role,
+		Permission: req.Permission,
+	})
+	if err != nil {
+		a.log.Errorf("revoke permission err: role = %s: %v", req.Role, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.RevokePermissionResponse{}, nil
+}
+
+func (a *authServer) AssignRole(
+	ctx context.Context,
+	req *AuthService.AssignRoleRequest,
+) (
+	*AuthService.AssignRoleResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	params, err := userRoleParams(req.UserId, req.Role, req.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned, err := a.repo.AssignRole(ctx, params)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("assign role err: user_id = %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !assigned {
+		return nil, status.Error(codes.NotFound, ErrRoleNotFound)
+	}
+
+	return &AuthService.AssignRoleResponse{}, nil
+}
+
+func (a *authServer) UnassignRole(
+	ctx context.Context,
+	req *AuthService.UnassignRoleRequest,
+) (
+	*AuthService.UnassignRoleResponse, error,
+) {
+	if _, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin); err != nil {
+		return nil, err
+	}
+
+	params, err := userRoleParams(req.UserId, req.Role, req.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.repo.UnassignRole(ctx, params); err != nil {
+		a.log.Errorf("unassign role err: user_id = %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.UnassignRoleResponse{}, nil
+}
+
+func userRoleParams(userId, role, resource string) (repo.UserRoleParams, error) {
+	userID, err := uuid.Parse(userId)
+	if err != nil {
+		return repo.UserRoleParams{}, status.Error(codes.InvalidArgument, "invalid user id format")
+	}
+
+	if resource == "" {
+		resource = repo.AllResources
+	}
+
+	return repo.UserRoleParams{
+		UserID:   userID,
+		RoleName: role,
+		Resource: resource,
+	}, nil
+}
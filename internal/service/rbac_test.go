@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequirePermission(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	admin := f.addUser("admin")
+	f.grant(admin.ID, PermissionAdmin)
+	editor := f.addUser("editor")
+	f.grant(editor.ID, "docs:write")
+	f.addUser("alice")
+
+	tests := []struct {
+		name        string
+		accessToken string
+		want        codes.Code
+	}{
+		{name: "without token", accessToken: "", want: codes.Unauthenticated},
+		{name: "without permissions", accessToken: login(t, srv, "alice").AccessToken, want: codes.PermissionDenied},
+		{name: "with another permission", accessToken: login(t, srv, "editor").AccessToken, want: codes.PermissionDenied},
+		{name: "with auth:admin", accessToken: login(t, srv, "admin").AccessToken, want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.requirePermission(context.Background(), tt.accessToken, PermissionAdmin)
+			if status.Code(err) != tt.want {
+				t.Fatalf("requirePermission() code = %v, want %v", status.Code(err), tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionReadsCurrentPermissions(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	admin := f.addUser("admin")
+	f.grant(admin.ID, PermissionAdmin)
+	tokens := login(t, srv, "admin")
+
+	// права в токене остались прежними, но отзыв роли действует сразу
+	f.permissions[admin.ID] = nil
+	_, err := srv.requirePermission(context.Background(), tokens.AccessToken, PermissionAdmin)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("requirePermission() after revoking the role code = %v, want %v", status.Code(err), codes.PermissionDenied)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	editor := f.addUser("editor")
+	f.grant(editor.ID, "docs:write")
+	tokens := login(t, srv, "editor")
+
+	tests := []struct {
+		permission string
+		want       bool
+	}{
+		{permission: "docs:write", want: true},
+		{permission: PermissionAdmin, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.permission, func(t *testing.T) {
+			resp, err := srv.Authorize(context.Background(), &AuthService.AuthorizeRequest{
+				AccessToken: tokens.AccessToken,
+				Permission:  tt.permission,
+			})
+			if err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+			if resp.Allowed != tt.want {
+				t.Fatalf("Authorize() allowed = %v, want %v", resp.Allowed, tt.want)
+			}
+		})
+	}
+}
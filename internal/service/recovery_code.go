@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"slices"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newRecoveryCodes создаёт новый набор кодов восстановления взамен неиспользованных старых.
+// Коды хранятся как HMAC на ключе шифрования MFA: проверка кода - одно сравнение, а не bcrypt по каждому коду
+func (a *authServer) newRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	recoveryCodes, err := secure.GenerateRecoveryCodes(secure.RecoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, a.mfaCipher.MAC(code))
+	}
+
+	if err := a.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// checkRecoveryCode ищет код среди неиспользованных и гасит его
+func (a *authServer) checkRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	if a.mfaCipher == nil {
+		return status.Error(codes.FailedPrecondition, ErrMfaNotConfigured)
+	}
+
+	recoveryCodes, err := a.repo.GetUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		a.log.Errorf("get recovery codes err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+
+	code = secure.NormalizeRecoveryCode(code)
+	codeHash := a.mfaCipher.MAC(code)
+	i := slices.IndexFunc(recoveryCodes, func(rc repo.RecoveryCode) bool {
+		return subtle.ConstantTimeCompare([]byte(rc.CodeHash), []byte(codeHash)) == 1
+	})
+	if i < 0 {
+		return status.Error(codes.Unauthenticated, ErrInvalidRecoveryCode)
+	}
+
+	client := clientinfo.FromContext(ctx)
+	used, err := a.repo.UseRecoveryCode(ctx, repo.UseRecoveryCodeParams{
+		ID:        recoveryCodes[i].ID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+	})
+	if err != nil {
+		a.log.Errorf("use recovery code err: user_id = %s: %v", userID, err)
+		return status.Error(codes.Internal, ErrUnknown)
+	}
+	if !used {
+		return status.Error(codes.Unauthenticated, ErrInvalidRecoveryCode)
+	}
+
+	a.securityEvent(ctx, EventRecoveryCodeUsed, userID, "remaining", len(recoveryCodes)-1)
+	return nil
+}
+
+// RegenerateRecoveryCodes выдаёт новый набор кодов восстановления, старые перестают действовать
+func (a *authServer) RegenerateRecoveryCodes(
+	ctx context.Context,
+	req *AuthService.RegenerateRecoveryCodesRequest,
+) (
+	*AuthService.RegenerateRecoveryCodesResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaRequired, err := a.mfaRequired(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("failed to check mfa for user %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !mfaRequired {
+		return nil, status.Error(codes.FailedPrecondition, ErrMfaNotEnabled)
+	}
+	if a.mfaCipher == nil {
+		return nil, status.Error(codes.FailedPrecondition, ErrMfaNotConfigured)
+	}
+
+	recoveryCodes, err := a.newRecoveryCodes(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("generate recovery codes err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.RegenerateRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
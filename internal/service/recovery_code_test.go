@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckRecoveryCode(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	ctx := context.Background()
+
+	recoveryCodes, err := srv.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("newRecoveryCodes() error = %v", err)
+	}
+	if len(recoveryCodes) != secure.RecoveryCodesCount {
+		t.Fatalf("newRecoveryCodes() returned %d codes, want %d", len(recoveryCodes), secure.RecoveryCodesCount)
+	}
+
+	// в БД хранятся HMAC кодов, а не сами коды
+	stored := map[string]bool{}
+	for _, rc := range f.recoveryCodes {
+		stored[rc.CodeHash] = true
+	}
+	for _, code := range recoveryCodes {
+		if stored[code] || !stored[srv.mfaCipher.MAC(code)] {
+			t.Fatalf("recovery code %q is not stored as HMAC", code)
+		}
+	}
+
+	// код принимается в любом регистре, с пробелами и без дефиса
+	code := strings.ToUpper(strings.ReplaceAll(recoveryCodes[3], "-", " "))
+	if err := srv.checkRecoveryCode(ctx, user.ID, code); err != nil {
+		t.Fatalf("checkRecoveryCode() error = %v", err)
+	}
+	if err := srv.checkRecoveryCode(ctx, user.ID, recoveryCodes[3]); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("checkRecoveryCode() reuse error = %v, want Unauthenticated", err)
+	}
+	if err := srv.checkRecoveryCode(ctx, user.ID, "aaaaa-aaaaa"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("checkRecoveryCode() wrong code error = %v, want Unauthenticated", err)
+	}
+	if got := len(f.recoveryCodes); got != secure.RecoveryCodesCount-1 {
+		t.Fatalf("unused recovery codes = %d, want %d", got, secure.RecoveryCodesCount-1)
+	}
+}
+
+func TestVerifyMfaWithRecoveryCode(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	enableTotp(t, srv, f, user)
+	ctx := context.Background()
+
+	recoveryCodes, err := srv.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("newRecoveryCodes() error = %v", err)
+	}
+
+	// неверный код восстановления считается неудачной попыткой, как и неверный код из приложения
+	challenge := login(t, srv, "alice")
+	_, err = srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{MfaToken: challenge.MfaToken, RecoveryCode: "aaaaa-aaaaa"})
+	if status.Convert(err).Message() != ErrInvalidRecoveryCode {
+		t.Fatalf("VerifyMfa() with a wrong recovery code error = %v, want %q", err, ErrInvalidRecoveryCode)
+	}
+	data, err := srv.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{Token: challenge.MfaToken})
+	if err != nil {
+		t.Fatalf("failed to parse challenge: %v", err)
+	}
+	if got := f.loginFailures[repo.LoginFailureKey{Scope: repo.LoginScopeMfa, Subject: data.TokenId}]; got == nil || got.failures != 1 {
+		t.Fatal("wrong recovery code was not counted against the challenge")
+	}
+
+	resp, err := srv.VerifyMfa(ctx, &AuthService.VerifyMfaRequest{MfaToken: challenge.MfaToken, RecoveryCode: recoveryCodes[0]})
+	if err != nil {
+		t.Fatalf("VerifyMfa() with a recovery code error = %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatal("VerifyMfa() returned no tokens")
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/pkg/secure"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// login входит под пользователем username с паролем из fakeRepo.addUser
+func login(t *testing.T, srv *authServer, username string) *AuthService.LoginResponse {
+	t.Helper()
+
+	resp, err := srv.Login(context.Background(), &AuthService.LoginRequest{Username: username, Password: "Password123!"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	return resp
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+
+	resp, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if resp.RefreshToken == tokens.RefreshToken {
+		t.Fatal("Refresh() returned the presented refresh token")
+	}
+
+	// новый токен принадлежит той же сессии
+	if f.session(t, resp.RefreshToken).ID != f.session(t, tokens.RefreshToken).ID {
+		t.Fatal("rotated refresh token started a new session")
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+
+	rotated, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// повторное предъявление обменянного токена отзывает всю сессию
+	_, err = srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh() with a reused token code = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+	_, err = srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  rotated.AccessToken,
+		RefreshToken: rotated.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh() after reuse code = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+
+	if f.session(t, tokens.RefreshToken).RevokedAt == nil {
+		t.Fatal("session is not revoked after refresh token reuse")
+	}
+
+	// другие сессии пользователя не затронуты
+	if _, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  other.AccessToken,
+		RefreshToken: other.RefreshToken,
+	}); err != nil {
+		t.Fatalf("Refresh() in another session error = %v", err)
+	}
+}
+
+func TestRefreshTokenIsStoredHashed(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+
+	// в БД только хэш непрозрачного токена, по нему токен и находится
+	stored := f.authTokens[0]
+	if stored.refreshTokenHash == tokens.RefreshToken || stored.refreshTokenHash != secure.HashToken(tokens.RefreshToken) {
+		t.Fatalf("stored refresh token = %s, want its hash", stored.refreshTokenHash)
+	}
+
+	// access токен при обмене необязателен
+	if _, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{RefreshToken: tokens.RefreshToken}); err != nil {
+		t.Fatalf("Refresh() without an access token error = %v", err)
+	}
+	_, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{RefreshToken: stored.refreshTokenHash})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Refresh() with the stored hash code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	f.authTokens[0].RefreshExpiresAt = time.Now().Add(-time.Second)
+
+	_, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated || status.Convert(err).Message() != ErrTokenExpired {
+		t.Fatalf("Refresh() with an expired token error = %v, want %s", err, ErrTokenExpired)
+	}
+}
+
+func TestRefreshAccessTokenMismatch(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+
+	tests := []struct {
+		name string
+		req  *AuthService.RefreshRequest
+		want string
+	}{
+		{
+			// частая ошибка клиента - передать access токен вместо refresh
+			name: "access token as refresh token",
+			req:  &AuthService.RefreshRequest{AccessToken: tokens.AccessToken, RefreshToken: tokens.AccessToken},
+			want: ErrRefreshTokenExpected,
+		},
+		{
+			name: "refresh token as access token",
+			req:  &AuthService.RefreshRequest{AccessToken: tokens.RefreshToken, RefreshToken: tokens.RefreshToken},
+			want: ErrValidateJwt,
+		},
+		{
+			name: "access token of another session",
+			req:  &AuthService.RefreshRequest{AccessToken: other.AccessToken, RefreshToken: tokens.RefreshToken},
+			want: ErrValidateJwt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.Refresh(context.Background(), tt.req)
+			if status.Code(err) != codes.Unauthenticated || status.Convert(err).Message() != tt.want {
+				t.Fatalf("Refresh() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	// refresh токен нельзя предъявить вместо access токена
+	if got := validate(srv, tokens.RefreshToken); got != codes.Unauthenticated {
+		t.Fatalf("Validate() with a refresh token code = %v, want %v", got, codes.Unauthenticated)
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+
+	"newservice/internal/audit"
+
+	"github.com/google/uuid"
+)
+
+// события безопасности
+const (
+	EventRefreshTokenReuse = "refresh_token_reuse"
+	EventRecoveryCodeUsed  = "recovery_code_used"
+	EventPasskeyCloned     = "passkey_clone_warning"
+	EventPasswordReset     = "password_reset"
+	EventPasswordChanged   = "password_changed"
+	EventEmailChanged      = "email_changed"
+	EventEmailChangeCancel = "email_change_cancelled"
+	EventAccountLocked     = "account_locked"
+	EventAccountUnlocked   = "account_unlocked"
+	EventOAuthCodeReuse    = "oauth_code_reuse"
+)
+
+// события, которые говорят о возможной атаке, а не о действии самого пользователя
+var attackEvents = map[string]bool{
+	EventRefreshTokenReuse: true,
+	EventPasskeyCloned:     true,
+	EventAccountLocked:     true,
+	EventOAuthCodeReuse:    true,
+}
+
+// securityEvent фиксирует событие, которое требует внимания (возможная компрометация токенов и т.п.),
+// в логе и в журнале аудита
+func (a *authServer) securityEvent(ctx context.Context, event string, userID uuid.UUID, keysAndValues ...interface{}) {
+	a.log.Warnw("security event", append([]interface{}{"event", event, "user_id", userID.String()}, keysAndValues...)...)
+
+	outcome := audit.OutcomeSuccess
+	actorID := userID
+	if attackEvents[event] {
+		outcome = audit.OutcomeFailure
+		actorID = uuid.Nil
+	}
+	a.auditEvent(ctx, event, outcome, actorID, userID.String(), keysAndValues...)
+}
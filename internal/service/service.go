@@ -2,19 +2,25 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"github.com/google/uuid"
 
+	"slices"
 	"time"
 
 	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
 	"newservice/internal/config"
+	"newservice/internal/denylist"
 	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
 	"newservice/pkg/jwt"
+	"newservice/pkg/mailer"
 	"newservice/pkg/secure"
 	"newservice/pkg/validator"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/pkg/errors"
@@ -24,19 +30,30 @@ import (
 )
 
 type authServer struct {
-	cfg  config.AppConfig
-	repo repo.Repository
-	log  *zap.SugaredLogger
-	jwt  jwt.JWTClient
+	cfg      config.AppConfig
+	repo     repo.Repository
+	log      *zap.SugaredLogger
+	jwt      jwt.JWTClient
+	denylist *denylist.Denylist
+	// шифр секретов второго фактора, nil - MFA не настроена
+	mfaCipher *secure.Cipher
+	webauthn  *webauthn.WebAuthn
+	mailer    mailer.Mailer
+	audit     *audit.Log
 	AuthService.UnimplementedAuthServiceServer
 }
 
-func NewAuthServer(cfg config.AppConfig, repo repo.Repository, jwt jwt.JWTClient, log *zap.SugaredLogger) AuthService.AuthServiceServer {
+func NewAuthServer(cfg config.AppConfig, repo repo.Repository, jwt jwt.JWTClient, denylist *denylist.Denylist, mfaCipher *secure.Cipher, webAuthn *webauthn.WebAuthn, mailer mailer.Mailer, auditLog *audit.Log, log *zap.SugaredLogger) AuthService.AuthServiceServer {
 	return &authServer{
-		cfg:  cfg,
-		repo: repo,
-		log:  log,
-		jwt:  jwt,
+		cfg:       cfg,
+		repo:      repo,
+		log:       log,
+		jwt:       jwt,
+		denylist:  denylist,
+		mfaCipher: mfaCipher,
+		webauthn:  webAuthn,
+		mailer:    mailer,
+		audit:     auditLog,
 	}
 }
 
@@ -44,7 +61,7 @@ func (a *authServer) Register(ctx context.Context, req *AuthService.RegisterRequ
 	if err := validator.Validate(ctx, req); err != nil {
 		a.log.Errorf("validation error: %v", err)
 
-		return nil, status.Error(codes.OK, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	passwordValidityCheck, err := secure.IsValidPassword(req.Password)
@@ -56,18 +73,30 @@ func (a *authServer) Register(ctx context.Context, req *AuthService.RegisterRequ
 		return nil, status.Error(codes.InvalidArgument, errMsg)
 	}
 
+	username := normalizeUsername(req.GetUsername())
+	if username == "" || isEmailIdentifier(username) {
+		return nil, status.Error(codes.InvalidArgument, "username is required and must not contain @")
+	}
+
+	email := normalizeEmail(req.GetEmail())
+	if !validEmail(email) {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidEmail)
+	}
+
 	req.Password, _ = secure.HashPassword(req.Password)
 
-	_, err = a.repo.CreateUser(ctx, &repo.User{
-		Username:       req.GetUsername(),
+	user := &repo.User{
+		Username:       username,
 		HashedPassword: req.GetPassword(),
-		Email:          req.GetEmail(),
-	})
+		Email:          email,
+	}
+	user.ID, err = a.repo.CreateUser(ctx, user)
 	if err != nil {
 		a.log.Error("failed to create user", zap.Error(err))
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == pgerrcode.UniqueViolation {
+				a.auditEvent(ctx, audit.ActionRegister, audit.OutcomeFailure, uuid.Nil, "", "username", username, "email", email)
 				return nil, status.Error(codes.AlreadyExists, ErrUserAuthAlreadyExist)
 			}
 		}
@@ -75,6 +104,13 @@ func (a *authServer) Register(ctx context.Context, req *AuthService.RegisterRequ
 		return nil, errors.Wrap(err, "failed to create user")
 	}
 
+	a.auditEvent(ctx, audit.ActionRegister, audit.OutcomeSuccess, user.ID, user.ID.String())
+
+	// пользователь создан, письмо можно запросить повторно через ResendVerification
+	if err := a.requestEmailVerification(ctx, user); err != nil {
+		a.log.Errorf("send email verification err: user_id = %s: %v", user.ID, err)
+	}
+
 	return &AuthService.RegisterResponse{}, nil
 }
 
@@ -84,32 +120,44 @@ func (a *authServer) Login(ctx context.Context, req *AuthService.LoginRequest) (
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	user, err := a.repo.GetUserByUsername(ctx, req.GetUsername())
-	if err != nil {
-		a.log.Errorf("failed to get credentials for user %s: %v", req.GetUsername(), err)
-		return nil, status.Error(codes.NotFound, "user not found")
+	// username остался для старых клиентов
+	identifier := req.GetIdentifier()
+	if identifier == "" {
+		identifier = req.GetUsername()
 	}
 
-	if err := secure.CheckPassword(user.HashedPassword, req.GetPassword()); err != nil {
-		a.log.Errorf("invalid password for user %a: %v", req.GetUsername(), err)
-		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	user, err := a.checkCredentials(ctx, identifier, req.GetPassword())
+	if err != nil {
+		return nil, err
 	}
 
-	tokens, err := a.jwt.CreateToken(&jwt.CreateTokenParams{
-		UserId: user.ID,
-	})
-
-	authTokenParams := repo.NewAuthTokenParams{
-		UserID:           user.ID,                             // ID пользователя
-		Tokens:           *tokens,                             // Токены
-		RefreshExpiresAt: time.Now().Add(30 * 24 * time.Hour), // Устанавливаем дату истечения refresh токена
+	// при подключённом втором факторе сессия начнётся только после VerifyMfa
+	mfaRequired, err := a.mfaRequired(ctx, user.ID)
+	if err != nil {
+		a.log.Errorf("failed to check mfa for user %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if mfaRequired {
+		resp, err := a.mfaChallenge(user.ID, req.GetClientId(), req.GetDeviceName())
+		if err != nil {
+			a.log.Errorf("failed to create mfa challenge for user %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, user.ID, user.ID.String(), "method", "password", "mfa_required", true)
+		return resp, nil
 	}
 
-	err = a.repo.NewAuthToken(ctx, authTokenParams)
+	// счётчик ошибок сбрасывается только после полного входа: верный пароль
+	// не должен обнулять неудачные попытки подобрать второй фактор
+	a.loginSucceeded(ctx, user.ID)
+
+	// каждый логин начинает новую сессию устройства
+	tokens, err := a.issueTokens(ctx, user.ID, req.GetClientId(), req.GetDeviceName())
 	if err != nil {
 		a.log.Errorf("failed to add auth token for user %s: %v", user.ID, err)
 		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
+	a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, user.ID, user.ID.String(), "method", "password")
 
 	return &AuthService.LoginResponse{
 		AccessToken:  tokens.AccessToken,
@@ -117,6 +165,42 @@ func (a *authServer) Login(ctx context.Context, req *AuthService.LoginRequest) (
 	}, nil
 }
 
+// checkCredentials проверяет пароль с учётом блокировки после перебора и записывает неудачные попытки
+// в журнал аудита. Успешный вход записывает и счётчик ошибок сбрасывает вызывающий: после пароля может
+// понадобиться второй фактор, и верный пароль не должен обнулять неудачные попытки подобрать код
+func (a *authServer) checkCredentials(ctx context.Context, identifier, password string) (*repo.User, error) {
+	user, err := a.findUserByLogin(ctx, identifier)
+	if err != nil {
+		a.log.Errorf("failed to get credentials for user %s: %v", identifier, err)
+		// перебор имён тоже считается против IP
+		if lockErr := a.checkLoginLock(ctx, uuid.Nil); lockErr != nil {
+			a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeDenied, uuid.Nil, "", "identifier", identifier, "reason", "locked_out")
+			return nil, lockErr
+		}
+		a.loginFailed(ctx, uuid.Nil)
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeFailure, uuid.Nil, "", "identifier", identifier, "reason", "unknown_user")
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	// пока пароль не проверен, действующее лицо неизвестно: пользователь записывается только как цель
+	if err := a.checkLoginLock(ctx, user.ID); err != nil {
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeDenied, uuid.Nil, user.ID.String(), "reason", "locked_out")
+		return nil, err
+	}
+
+	if err := secure.CheckPassword(user.HashedPassword, password); err != nil {
+		a.log.Errorf("invalid password for user %s: %v", identifier, err)
+		a.loginFailed(ctx, user.ID)
+		a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeFailure, uuid.Nil, user.ID.String(), "reason", "invalid_password")
+		return nil, status.Error(codes.Unauthenticated, ErrInvalidCredentials)
+	}
+
+	if err := a.checkEmailVerified(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (a *authServer) Validate(
 	ctx context.Context,
 	req *AuthService.ValidateRequest,
@@ -124,37 +208,31 @@ func (a *authServer) Validate(
 	*AuthService.ValidateResponse, error,
 ) {
 
-	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
-		Token: req.AccessToken,
-	})
-
-	if err != nil || !check {
-		return nil, status.Error(codes.Unauthenticated, "JWT validation failed")
-	}
-
-	accessData, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
-		Token: req.AccessToken,
-	})
+	// проверяем подпись токена и то, что он не отозван
+	accessData, err := a.authenticateToken(ctx, req.AccessToken)
 	if err != nil {
-
-		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+		return nil, err
 	}
-
-	// проверяем наличие refresh токена в БД
-	_, err = a.repo.GetRefreshToken(ctx, repo.GetRefreshTokenParams{
-		UserID: accessData.UserId,
-	})
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	if accessData.IsClient() {
+		if _, err := a.serviceAccount(ctx, accessData.ClientId); err != nil {
+			return nil, err
 		}
+	}
 
-		return nil, status.Error(codes.Internal, ErrUnknown)
+	// сервис-получатель может потребовать, чтобы токен был выпущен именно для него
+	if req.Audience != "" && !slices.Contains(accessData.Audience, req.Audience) {
+		return nil, status.Error(codes.Unauthenticated, ErrInvalidAudience)
 	}
 
-	return &AuthService.ValidateResponse{
-		UserId: accessData.UserId.String(),
-	}, nil
+	resp := &AuthService.ValidateResponse{
+		Sub:      accessData.Subject,
+		ClientId: accessData.ClientId,
+		Scope:    accessData.Scope,
+	}
+	if !accessData.IsClient() {
+		resp.UserId = accessData.UserId.String()
+	}
+	return resp, nil
 }
 
 func (a *authServer) NewJwt(
@@ -163,6 +241,12 @@ func (a *authServer) NewJwt(
 ) (
 	*AuthService.NewJwtResponse, error,
 ) {
+	// выпуск токенов за любого пользователя - административная операция
+	accessData, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin)
+	if err != nil {
+		return nil, err
+	}
+
 	userID, err := uuid.Parse(req.UserId) // преобразуем string в uuid.UUID
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "invalid user id format")
@@ -173,18 +257,7 @@ func (a *authServer) NewJwt(
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	tokens, err := a.jwt.CreateToken(&jwt.CreateTokenParams{
-		UserId: userID,
-	})
-	if err != nil {
-		a.log.Errorf("create tokens err: user_id = %s", req.UserId)
-		return nil, status.Error(codes.Internal, ErrUnknown)
-	}
-
-	_, err = a.repo.NewRefreshToken(ctx, repo.NewRefreshTokenParams{
-		UserID: userID,
-		Token:  tokens.RefreshToken,
-	})
+	tokens, err := a.issueTokens(ctx, userID, req.GetClientId(), "")
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
@@ -193,6 +266,8 @@ func (a *authServer) NewJwt(
 		a.log.Errorf("adding a token to the database: user_id = %s", req.UserId)
 		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
+	a.auditEvent(ctx, audit.ActionNewJwt, audit.OutcomeSuccess, accessData.UserId, userID.String(),
+		"issued_by", accessData.Subject, "client_id", req.GetClientId())
 
 	return &AuthService.NewJwtResponse{
 		AccessToken:  tokens.AccessToken,
@@ -206,18 +281,29 @@ func (a *authServer) RevokeJwt(
 ) (
 	*AuthService.RevokeJwtResponse, error,
 ) {
+	// завершение всех сессий любого пользователя - административная операция,
+	// свои сессии пользователь завершает через RevokeAllOtherSessions
+	accessData, err := a.requirePermission(ctx, req.AccessToken, PermissionAdmin)
+	if err != nil {
+		return nil, err
+	}
+
 	userID, err := uuid.Parse(req.UserId) // преобразуем string в uuid.UUID
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "invalid user id format")
 	}
 
-	err = a.repo.DeleteRefreshToken(ctx, repo.DeleteRefreshTokenParams{
+	// отзываем все сессии пользователя
+	err = a.repo.RevokeUserSessions(ctx, repo.RevokeUserSessionsParams{
 		UserID: userID,
 	})
 	if err != nil {
 		a.log.Errorf("remove a token to the database: user_id = %s", req.UserId)
 		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
+	a.syncDenylist(ctx)
+	a.auditEvent(ctx, audit.ActionRevokeUserSessions, audit.OutcomeSuccess, accessData.UserId, userID.String(),
+		"revoked_by", accessData.Subject)
 	return &AuthService.RevokeJwtResponse{}, nil
 }
 
@@ -228,76 +314,145 @@ func (a *authServer) Refresh(
 	*AuthService.RefreshResponse, error,
 ) {
 
-	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
-		Token: req.RefreshToken,
-	})
-	if err != nil || !check {
-		a.log.Errorf("refresh token validation error")
-		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	// refresh токен непрозрачный, ищем его по хэшу
+	stored, err := a.repo.GetAuthTokenByRefresh(ctx, secure.HashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// частая ошибка клиента - передать access токен вместо refresh
+			if check, _ := a.jwt.ValidateToken(&jwt.ValidateTokenParams{Token: req.RefreshToken}); check {
+				return nil, status.Error(codes.Unauthenticated, ErrRefreshTokenExpected)
+			}
+			return nil, status.Error(codes.NotFound, ErrTokenNotFound)
+		}
+		a.log.Errorf("get refresh token err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
 
-	// извлекаем данные из access и refresh токенов
-	accessData, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
-		Token: req.AccessToken,
-	})
-	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	// токены сессий OAuth клиентов обновляются только через OAuthToken, где клиент предъявляет свои учётные данные
+	// (RFC 6749, раздел 6). Иначе refresh токен конфиденциального клиента можно было бы обменять без его секрета
+	if stored.ClientID != "" {
+		a.auditEvent(ctx, audit.ActionRefresh, audit.OutcomeFailure, stored.UserID, stored.SessionID.String(),
+			"reason", "client_token", "client_id", stored.ClientID)
+		return nil, status.Error(codes.NotFound, ErrTokenNotFound)
 	}
 
-	refreshData, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
-		Token: req.RefreshToken,
-	})
+	// access токен необязателен, но если передан - он должен относиться к той же сессии
+	if req.AccessToken != "" {
+		accessData, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+			Token: req.AccessToken,
+		})
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+		}
+		if accessData.TokenType != jwt.TokenTypeAccess {
+			return nil, status.Error(codes.Unauthenticated, ErrAccessTokenExpected)
+		}
+		if accessData.UserId != stored.UserID || accessData.SessionId != stored.SessionID {
+			return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+		}
+	}
+
+	tokens, err := a.rotateRefreshToken(ctx, stored)
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+		return nil, err
 	}
-	if accessData.UserId != refreshData.UserId {
+
+	return &AuthService.RefreshResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+// rotateRefreshToken обменивает refresh токен на новую пару токенов той же сессии. Повторное предъявление
+// уже обменянного токена отзывает сессию. Кому принадлежит токен, проверяет вызывающий
+func (a *authServer) rotateRefreshToken(ctx context.Context, stored *repo.AuthToken) (*jwt.CreateTokenResponse, error) {
+	if stored.RevokedAt != nil {
+		a.auditEvent(ctx, audit.ActionRefresh, audit.OutcomeFailure, stored.UserID, stored.SessionID.String(), "reason", "session_revoked")
 		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
 	}
 
-	rtToken, err := a.repo.GetRefreshToken(ctx, repo.GetRefreshTokenParams{
-		UserID: refreshData.UserId,
-	})
-	if err != nil {
-		a.log.Errorf("get refresh token err")
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, status.Error(codes.NotFound, ErrTokenNotFound)
-		}
-		return nil, status.Error(codes.Internal, ErrUnknown)
+	// повторное предъявление уже обменянного токена означает, что его кто-то украл:
+	// отзываем всю сессию, и легитимному клиенту, и злоумышленнику придётся войти заново
+	if stored.ConsumedAt != nil {
+		return nil, a.refreshTokenReused(ctx, stored)
 	}
 
-	if len(rtToken) == 0 {
-		a.log.Errorf("len(rtToken) == 0")
-		return nil, status.Error(codes.NotFound, ErrTokenNotFound)
+	if stored.RefreshExpiresAt.Before(time.Now()) {
+		a.auditEvent(ctx, audit.ActionRefresh, audit.OutcomeFailure, stored.UserID, stored.SessionID.String(), "reason", "expired")
+		return nil, status.Error(codes.Unauthenticated, ErrTokenExpired)
 	}
 
-	if rtToken[0] != req.RefreshToken {
-		a.log.Errorf("rtToken[0] != req.RefreshToken")
-		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	// создаём новые токены
+	tokens, err := a.createToken(ctx, stored.UserID, stored.SessionID, stored.ClientID, stored.Scope)
+	if err != nil {
+		a.log.Errorf("create tokens error: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
 
-	// создаём новые токены
-	tokens, err := a.jwt.CreateToken(&jwt.CreateTokenParams{
-		UserId: refreshData.UserId,
+	client := clientinfo.FromContext(ctx)
+	err = a.repo.RotateRefreshToken(ctx, repo.RotateRefreshTokenParams{
+		ConsumedID: stored.ID,
+		NewToken: repo.NewAuthTokenParams{
+			UserID:           stored.UserID,
+			SessionID:        stored.SessionID,
+			AccessTokenID:    tokens.AccessTokenId,
+			AccessExpiresAt:  tokens.AccessExpiresAt,
+			RefreshTokenHash: secure.HashToken(tokens.RefreshToken),
+			RefreshExpiresAt: time.Now().Add(a.cfg.System.RefreshTokenTimeout),
+		},
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
 	})
-
 	if err != nil {
-		a.log.Errorf("create tokens error")
+		if errors.Is(err, repo.ErrRefreshTokenReused) {
+			return nil, a.refreshTokenReused(ctx, stored)
+		}
+		a.log.Errorf("rotate refresh token err: %v", err)
 		return nil, status.Error(codes.Internal, ErrUnknown)
 	}
+	a.auditEvent(ctx, audit.ActionRefresh, audit.OutcomeSuccess, stored.UserID, stored.SessionID.String())
 
-	err = a.repo.UpdateRefreshToken(ctx, repo.UpdateRefreshTokenParams{
-		Token:     tokens.RefreshToken,
-		UpdatedAt: sql.NullTime{Time: time.Now(), Valid: true},
-		UserID:    refreshData.UserId,
-	})
+	return tokens, nil
+}
+
+// refreshTokenReused отзывает сессию (семейство токенов), в которой повторно предъявлен refresh токен
+func (a *authServer) refreshTokenReused(ctx context.Context, stored *repo.AuthToken) error {
+	a.securityEvent(ctx, EventRefreshTokenReuse, stored.UserID, "session_id", stored.SessionID.String())
 
+	_, err := a.repo.RevokeSession(ctx, repo.RevokeSessionParams{
+		SessionID: stored.SessionID,
+		UserID:    stored.UserID,
+	})
 	if err != nil {
-		a.log.Errorf("update refresh token err")
-		return nil, status.Error(codes.Internal, ErrUnknown)
+		a.log.Errorf("revoke session err: %v", err)
+		return status.Error(codes.Internal, ErrUnknown)
 	}
+	a.syncDenylist(ctx)
 
-	return &AuthService.RefreshResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
+	return status.Error(codes.Unauthenticated, ErrTokenReused)
+}
+
+func (a *authServer) GetJwks(
+	ctx context.Context,
+	req *AuthService.GetJwksRequest,
+) (
+	*AuthService.GetJwksResponse, error,
+) {
+	jwks := a.jwt.JWKS()
+
+	keys := make([]*AuthService.Jwk, 0, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		keys = append(keys, &AuthService.Jwk{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+		})
+	}
+
+	return &AuthService.GetJwksResponse{
+		Keys: keys,
 	}, nil
 }
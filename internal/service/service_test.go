@@ -0,0 +1,876 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"newservice/internal/audit"
+	"newservice/internal/config"
+	"newservice/internal/denylist"
+	"newservice/internal/repo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/mailer"
+	"newservice/pkg/secure"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// Общая обвязка тестов сервиса: репозиторий в памяти и сервер с настоящими JWT, denylist и WebAuthn.
+// Методы репозитория, которые тесту не нужны, не реализованы: вызов такого метода - паника
+
+// учётные данные ресурсного сервера - конфиденциального клиента OAuth, которому доступна интроспекция
+const (
+	testIntrospectionClient = "resource-server"
+	testIntrospectionSecret = "resource-server-secret"
+)
+
+// testMfaKey - ключ шифрования секретов TOTP для тестов
+const testMfaKey = "ZGV2LW9ubHktbWZhLWtleS1jaGFuZ2UtbWUtMzJieXQ="
+
+var (
+	testSigningKeyOnce sync.Once
+	testSigningKey     *rsa.PrivateKey
+)
+
+type fakeRepo struct {
+	repo.Repository
+
+	mu            sync.Mutex
+	lastID        int64
+	users         map[uuid.UUID]*repo.User
+	totp          map[uuid.UUID]*repo.UserTotp
+	recoveryCodes []repo.RecoveryCode
+	// прежние хэши паролей, начиная с самого свежего
+	passwordHistory map[uuid.UUID][]string
+	loginFailures   map[repo.LoginFailureKey]*loginFailure
+	sessions        map[uuid.UUID]*repo.Session
+	sessionScopes   map[uuid.UUID]string // разрешения, выданные клиенту OAuth в сессии
+	authTokens      []*fakeAuthToken
+	revoked         []repo.RevokedToken
+	auditEvents     []repo.AuditEvent
+	userTokens      []*fakeUserToken
+	passkeys        []repo.WebauthnCredential
+	ceremonies      map[uuid.UUID]repo.WebauthnChallenge
+	oauthClients    map[string]*repo.OAuthClient
+	oauthCodes      map[string]*repo.OAuthCode
+	assertions      map[repo.UseClientAssertionParams]bool
+	// права пользователей на все ресурсы
+	permissions map[uuid.UUID][]string
+}
+
+type fakeAuthToken struct {
+	repo.AuthToken
+	refreshTokenHash string
+	accessTokenID    string
+	accessExpiresAt  time.Time
+}
+
+type fakeUserToken struct {
+	repo.UserToken
+	tokenHash string
+}
+
+type loginFailure struct {
+	failures    int
+	lockedUntil *time.Time
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{
+		users:           map[uuid.UUID]*repo.User{},
+		totp:            map[uuid.UUID]*repo.UserTotp{},
+		passwordHistory: map[uuid.UUID][]string{},
+		loginFailures:   map[repo.LoginFailureKey]*loginFailure{},
+		sessions:        map[uuid.UUID]*repo.Session{},
+		sessionScopes:   map[uuid.UUID]string{},
+		permissions:     map[uuid.UUID][]string{},
+		ceremonies:      map[uuid.UUID]repo.WebauthnChallenge{},
+		oauthClients:    map[string]*repo.OAuthClient{},
+		oauthCodes:      map[string]*repo.OAuthCode{},
+		assertions:      map[repo.UseClientAssertionParams]bool{},
+	}
+}
+
+// nextID - следующий id записи, как у BIGSERIAL
+func (f *fakeRepo) nextID() int64 {
+	f.lastID++
+	return f.lastID
+}
+
+func (f *fakeRepo) addUser(username string) *repo.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	hash, _ := secure.HashPassword("Password123!")
+	user := &repo.User{
+		ID:             uuid.New(),
+		Username:       username,
+		HashedPassword: hash,
+		Email:          username + "@example.com",
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	f.users[user.ID] = user
+	return user
+}
+
+// grant выдаёт пользователю право на все ресурсы
+func (f *fakeRepo) grant(userID uuid.UUID, permission string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.permissions[userID] = append(f.permissions[userID], permission)
+}
+
+func (f *fakeRepo) HasPermission(_ context.Context, params repo.HasPermissionParams) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Contains(f.permissions[params.UserID], params.Permission), nil
+}
+
+func (f *fakeRepo) GetUserAuthorization(_ context.Context, userID uuid.UUID) (*repo.UserAuthorization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &repo.UserAuthorization{Permissions: slices.Clone(f.permissions[userID])}, nil
+}
+
+func (f *fakeRepo) CreateUser(_ context.Context, user *repo.User) (uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.Username == user.Username || u.Email == user.Email {
+			return uuid.Nil, &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+		}
+	}
+	created := *user
+	created.ID = uuid.New()
+	created.CreatedAt = time.Now()
+	created.UpdatedAt = time.Now()
+	f.users[created.ID] = &created
+	return created.ID, nil
+}
+
+func (f *fakeRepo) GetUserByID(_ context.Context, userID uuid.UUID) (*repo.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, ok := f.users[userID]; ok {
+		return user, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) GetUserByEmail(_ context.Context, email string) (*repo.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) GetUserByUsername(_ context.Context, username string) (*repo.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, user := range f.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) GetPassword(_ context.Context, userID uuid.UUID) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, ok := f.users[userID]
+	if !ok {
+		return "", pgx.ErrNoRows
+	}
+	return user.HashedPassword, nil
+}
+
+func (f *fakeRepo) UpdatePassword(_ context.Context, params repo.UpdatePasswordParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, ok := f.users[params.UserID]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	history := append([]string{user.HashedPassword}, f.passwordHistory[params.UserID]...)
+	f.passwordHistory[params.UserID] = history[:min(len(history), params.HistorySize)]
+	user.HashedPassword = params.PasswordHash
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+func (f *fakeRepo) GetPasswordHistory(_ context.Context, userID uuid.UUID, limit int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	history := f.passwordHistory[userID]
+	return slices.Clone(history[:min(len(history), limit)]), nil
+}
+
+func (f *fakeRepo) UpdateProfile(_ context.Context, params repo.UpdateProfileParams) (*repo.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, ok := f.users[params.UserID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	// пустая строка очищает поле, как NULLIF в запросе
+	update := func(field **string, value *string) {
+		if value == nil {
+			return
+		}
+		if *value == "" {
+			*field = nil
+			return
+		}
+		v := *value
+		*field = &v
+	}
+	update(&user.FirstName, params.FirstName)
+	update(&user.LastName, params.LastName)
+	user.UpdatedAt = time.Now()
+
+	updated := *user
+	return &updated, nil
+}
+
+func (f *fakeRepo) MarkEmailVerified(_ context.Context, userID uuid.UUID, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, ok := f.users[userID]
+	if !ok || user.Email != email {
+		return false, nil
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return true, nil
+}
+
+func (f *fakeRepo) ChangeEmail(_ context.Context, userID uuid.UUID, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID != userID && u.Email == email {
+			return &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+		}
+	}
+	user, ok := f.users[userID]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	now := time.Now()
+	user.Email = email
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+func (f *fakeRepo) CreateUserToken(_ context.Context, params repo.NewUserTokenParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// новый токен отменяет неиспользованные токены того же назначения
+	if !params.KeepExisting {
+		f.deleteUserTokens(params.UserID, params.Purpose)
+	}
+	f.userTokens = append(f.userTokens, &fakeUserToken{
+		UserToken: repo.UserToken{
+			ID:        f.nextID(),
+			UserID:    params.UserID,
+			Purpose:   params.Purpose,
+			Payload:   params.Payload,
+			CreatedAt: time.Now(),
+			ExpiresAt: params.ExpiresAt,
+		},
+		tokenHash: params.TokenHash,
+	})
+	return nil
+}
+
+func (f *fakeRepo) DeleteUserTokens(_ context.Context, userID uuid.UUID, purpose string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleteUserTokens(userID, purpose)
+	return nil
+}
+
+func (f *fakeRepo) deleteUserTokens(userID uuid.UUID, purpose string) {
+	f.userTokens = slices.DeleteFunc(f.userTokens, func(token *fakeUserToken) bool {
+		return token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil
+	})
+}
+
+func (f *fakeRepo) GetUserToken(_ context.Context, purpose, tokenHash string) (*repo.UserToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.userTokens {
+		if token.tokenHash == tokenHash && token.Purpose == purpose && token.UsedAt == nil && token.ExpiresAt.After(time.Now()) {
+			found := token.UserToken
+			return &found, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) UseUserToken(_ context.Context, purpose, tokenHash string) (*repo.UserToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.userTokens {
+		if token.tokenHash == tokenHash && token.Purpose == purpose && token.UsedAt == nil && token.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			token.UsedAt = &now
+			used := token.UserToken
+			return &used, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) GetOAuthClient(_ context.Context, clientID string) (*repo.OAuthClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	client, ok := f.oauthClients[clientID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return client, nil
+}
+
+func (f *fakeRepo) GetOAuthCode(_ context.Context, codeHash string) (*repo.OAuthCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code, ok := f.oauthCodes[codeHash]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	stored := *code
+	return &stored, nil
+}
+
+func (f *fakeRepo) UseOAuthCode(_ context.Context, params repo.UseOAuthCodeParams) (*repo.OAuthCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code, ok := f.oauthCodes[params.CodeHash]
+	if !ok || code.ClientID != params.ClientID || code.UsedAt != nil {
+		return nil, pgx.ErrNoRows
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	code.SessionID = &params.SessionID
+	stored := *code
+	return &stored, nil
+}
+
+func (f *fakeRepo) UseClientAssertion(_ context.Context, params repo.UseClientAssertionParams) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := repo.UseClientAssertionParams{ClientID: params.ClientID, TokenID: params.TokenID}
+	if f.assertions[key] {
+		return false, nil
+	}
+	f.assertions[key] = true
+	return true, nil
+}
+
+func (f *fakeRepo) GetTotp(_ context.Context, userID uuid.UUID) (*repo.UserTotp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if userTotp, ok := f.totp[userID]; ok {
+		return userTotp, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) UseTotpStep(_ context.Context, userID uuid.UUID, step int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	userTotp, ok := f.totp[userID]
+	if !ok || step <= userTotp.LastUsedStep {
+		return false, nil
+	}
+	userTotp.LastUsedStep = step
+	return true, nil
+}
+
+func (f *fakeRepo) ReplaceRecoveryCodes(_ context.Context, userID uuid.UUID, codeHashes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.recoveryCodes = slices.DeleteFunc(f.recoveryCodes, func(rc repo.RecoveryCode) bool {
+		return rc.UserID == userID
+	})
+	for _, hash := range codeHashes {
+		f.recoveryCodes = append(f.recoveryCodes, repo.RecoveryCode{
+			ID:        f.nextID(),
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+func (f *fakeRepo) GetUnusedRecoveryCodes(_ context.Context, userID uuid.UUID) ([]repo.RecoveryCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var codes []repo.RecoveryCode
+	for _, rc := range f.recoveryCodes {
+		if rc.UserID == userID {
+			codes = append(codes, rc)
+		}
+	}
+	return codes, nil
+}
+
+func (f *fakeRepo) UseRecoveryCode(_ context.Context, params repo.UseRecoveryCodeParams) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, rc := range f.recoveryCodes {
+		if rc.ID == params.ID {
+			f.recoveryCodes = append(f.recoveryCodes[:i], f.recoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRepo) CreateWebauthnChallenge(_ context.Context, challenge repo.WebauthnChallenge) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ceremonies[challenge.ID] = challenge
+	return nil
+}
+
+func (f *fakeRepo) TakeWebauthnChallenge(_ context.Context, id uuid.UUID, purpose string) (*repo.WebauthnChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	challenge, ok := f.ceremonies[id]
+	if !ok || challenge.Purpose != purpose || challenge.ExpiresAt.Before(time.Now()) {
+		return nil, pgx.ErrNoRows
+	}
+	delete(f.ceremonies, id)
+	return &challenge, nil
+}
+
+func (f *fakeRepo) CreateWebauthnCredential(_ context.Context, credential repo.WebauthnCredential) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.passkeys {
+		if bytes.Equal(c.ID, credential.ID) {
+			return &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+		}
+	}
+	credential.CreatedAt = time.Now()
+	f.passkeys = append(f.passkeys, credential)
+	return nil
+}
+
+func (f *fakeRepo) GetWebauthnCredentials(_ context.Context, userID uuid.UUID) ([]repo.WebauthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var credentials []repo.WebauthnCredential
+	for _, c := range f.passkeys {
+		if c.UserID == userID {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+func (f *fakeRepo) UpdateWebauthnCredentialUsage(_ context.Context, credential repo.WebauthnCredential) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.passkeys {
+		if bytes.Equal(f.passkeys[i].ID, credential.ID) {
+			now := time.Now()
+			f.passkeys[i].SignCount = credential.SignCount
+			f.passkeys[i].Flags = credential.Flags
+			f.passkeys[i].LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepo) RecordLoginFailure(_ context.Context, key repo.LoginFailureKey, _ time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counter, ok := f.loginFailures[key]
+	if !ok {
+		counter = &loginFailure{}
+		f.loginFailures[key] = counter
+	}
+	counter.failures++
+	return counter.failures, nil
+}
+
+func (f *fakeRepo) LockLogin(_ context.Context, key repo.LoginFailureKey, until time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if counter, ok := f.loginFailures[key]; ok && (counter.lockedUntil == nil || counter.lockedUntil.Before(until)) {
+		counter.lockedUntil = &until
+	}
+	return nil
+}
+
+func (f *fakeRepo) GetLoginLock(_ context.Context, keys ...repo.LoginFailureKey) (*time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lockedUntil *time.Time
+	for _, key := range keys {
+		counter, ok := f.loginFailures[key]
+		if !ok || counter.lockedUntil == nil || counter.lockedUntil.Before(time.Now()) {
+			continue
+		}
+		if lockedUntil == nil || counter.lockedUntil.After(*lockedUntil) {
+			lockedUntil = counter.lockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+func (f *fakeRepo) ResetLoginFailures(_ context.Context, key repo.LoginFailureKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.loginFailures, key)
+	return nil
+}
+
+func (f *fakeRepo) CreateSession(_ context.Context, params repo.NewSessionParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sessions[params.ID] = &repo.Session{
+		ID:         params.ID,
+		UserID:     params.UserID,
+		ClientID:   params.ClientID,
+		DeviceName: params.DeviceName,
+		IP:         params.IP,
+		UserAgent:  params.UserAgent,
+		CreatedAt:  time.Now(),
+		LastUsedAt: time.Now(),
+	}
+	f.sessionScopes[params.ID] = params.Scope
+	return nil
+}
+
+func (f *fakeRepo) GetSession(_ context.Context, sessionID uuid.UUID) (*repo.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[sessionID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	stored := *session
+	return &stored, nil
+}
+
+func (f *fakeRepo) ListSessions(_ context.Context, userID uuid.UUID) ([]repo.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var sessions []repo.Session
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (f *fakeRepo) RevokeSession(_ context.Context, params repo.RevokeSessionParams) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[params.SessionID]
+	if !ok || session.UserID != params.UserID || session.RevokedAt != nil {
+		return false, nil
+	}
+	f.revokeSession(session)
+	return true, nil
+}
+
+func (f *fakeRepo) RevokeUserSessions(_ context.Context, params repo.RevokeUserSessionsParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, session := range f.sessions {
+		if session.UserID == params.UserID && session.ID != params.ExceptSessionID && session.RevokedAt == nil {
+			f.revokeSession(session)
+		}
+	}
+	return nil
+}
+
+// revokeSession отзывает сессию вместе с её токенами, access токены попадают в denylist
+func (f *fakeRepo) revokeSession(session *repo.Session) {
+	now := time.Now()
+	session.RevokedAt = &now
+	for _, token := range f.authTokens {
+		if token.SessionID == session.ID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			f.revoked = append(f.revoked, repo.RevokedToken{TokenID: token.accessTokenID, ExpiresAt: token.accessExpiresAt})
+		}
+	}
+}
+
+// session - сессия, в которой выпущен refresh токен
+func (f *fakeRepo) session(t *testing.T, refreshToken string) *repo.Session {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, stored := range f.authTokens {
+		if stored.refreshTokenHash == secure.HashToken(refreshToken) {
+			return f.sessions[stored.SessionID]
+		}
+	}
+	t.Fatal("token is not stored")
+	return nil
+}
+
+func (f *fakeRepo) NewAuthToken(_ context.Context, params repo.NewAuthTokenParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addAuthToken(params)
+	return nil
+}
+
+func (f *fakeRepo) addAuthToken(params repo.NewAuthTokenParams) {
+	var clientID string
+	if session, ok := f.sessions[params.SessionID]; ok {
+		clientID = session.ClientID
+	}
+	f.authTokens = append(f.authTokens, &fakeAuthToken{
+		AuthToken: repo.AuthToken{
+			ID:               int64(len(f.authTokens) + 1),
+			UserID:           params.UserID,
+			SessionID:        params.SessionID,
+			ClientID:         clientID,
+			Scope:            f.sessionScopes[params.SessionID],
+			RefreshExpiresAt: params.RefreshExpiresAt,
+			CreatedAt:        time.Now(),
+		},
+		refreshTokenHash: params.RefreshTokenHash,
+		accessTokenID:    params.AccessTokenID,
+		accessExpiresAt:  params.AccessExpiresAt,
+	})
+}
+
+func (f *fakeRepo) GetAuthTokenByRefresh(_ context.Context, refreshTokenHash string) (*repo.AuthToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.authTokens {
+		if token.refreshTokenHash == refreshTokenHash {
+			stored := token.AuthToken
+			return &stored, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeRepo) RotateRefreshToken(_ context.Context, params repo.RotateRefreshTokenParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.authTokens {
+		if token.ID != params.ConsumedID {
+			continue
+		}
+		if token.ConsumedAt != nil {
+			return repo.ErrRefreshTokenReused
+		}
+		now := time.Now()
+		token.ConsumedAt = &now
+		f.addAuthToken(params.NewToken)
+		return nil
+	}
+	return pgx.ErrNoRows
+}
+
+func (f *fakeRepo) RevokeToken(_ context.Context, token repo.RevokedToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.revoked = append(f.revoked, token)
+	return nil
+}
+
+func (f *fakeRepo) GetRevokedTokens(context.Context, time.Time) ([]repo.RevokedToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]repo.RevokedToken(nil), f.revoked...), nil
+}
+
+func (f *fakeRepo) DeleteExpiredRevokedTokens(context.Context) error {
+	return nil
+}
+
+// sentMail - письма, которые отправил сервер
+func sentMail(srv *authServer) []mailer.Message {
+	return srv.mailer.(*mailer.MemoryMailer).Messages()
+}
+
+// mailToken достаёт токен из ссылки в последнем письме на адрес to
+func mailToken(t *testing.T, srv *authServer, to string) string {
+	t.Helper()
+
+	messages := sentMail(srv)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != to {
+			continue
+		}
+		link := regexp.MustCompile(`https?://\S+`).FindString(messages[i].Body)
+		u, err := url.Parse(link)
+		if err != nil || u.Query().Get("token") == "" {
+			t.Fatalf("no link with a token in the mail: %q", messages[i].Body)
+		}
+		return u.Query().Get("token")
+	}
+	t.Fatalf("no mail sent to %s", to)
+	return ""
+}
+
+func (f *fakeRepo) AppendAuditEvents(_ context.Context, events []*repo.AuditEvent, seal func(prevHash string, event *repo.AuditEvent) string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, event := range events {
+		var prevHash string
+		if n := len(f.auditEvents); n > 0 {
+			prevHash = f.auditEvents[n-1].Hash
+		}
+		event.ID = int64(len(f.auditEvents) + 1)
+		event.PrevHash = prevHash
+		event.Hash = seal(prevHash, event)
+		f.auditEvents = append(f.auditEvents, *event)
+	}
+	return nil
+}
+
+// flushAudit дожидается записи событий аудита, которые сервер поставил в очередь
+func flushAudit(t *testing.T, srv *authServer) {
+	t.Helper()
+
+	if err := srv.audit.Flush(context.Background()); err != nil {
+		t.Fatalf("failed to flush audit log: %v", err)
+	}
+}
+
+// newTestServer собирает сервер поверх репозитория в памяти
+func newTestServer(t *testing.T, f *fakeRepo) *authServer {
+	t.Helper()
+
+	var cfg config.AppConfig
+	cfg.System.AccessTokenTimeout = 15 * time.Minute
+	cfg.System.RefreshTokenTimeout = time.Hour
+	cfg.System.Issuer = "auth-service"
+	cfg.System.Audience = []string{"api"}
+	cfg.System.ClockSkew = 30 * time.Second
+	cfg.System.MfaChallengeTimeout = 5 * time.Minute
+	cfg.System.TotpIssuer = "auth-service"
+	cfg.System.WebauthnTimeout = 5 * time.Minute
+	cfg.System.PasswordResetTimeout = time.Hour
+	cfg.System.AppURL = "http://localhost:3000"
+	cfg.System.EmailVerificationTimeout = 24 * time.Hour
+	cfg.System.EmailChangeTimeout = 24 * time.Hour
+	cfg.System.EmailChangeCancelTimeout = 7 * 24 * time.Hour
+	cfg.System.PasswordHistorySize = 2
+	cfg.System.LoginMaxFailures = 5
+	cfg.System.LoginIPMaxFailures = 50
+	cfg.System.LoginLockoutBase = 30 * time.Second
+	cfg.System.LoginLockoutMax = time.Hour
+	cfg.System.LoginFailureWindow = time.Hour
+
+	testSigningKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		testSigningKey = key
+	})
+	keySet, err := jwt.NewKeySet(context.Background(), jwt.KeySourceFunc(func(context.Context) ([]jwt.Key, error) {
+		return []jwt.Key{jwt.NewKey(testSigningKey, time.Now().Add(-time.Hour))}, nil
+	}), cfg.System.AccessTokenTimeout)
+	if err != nil {
+		t.Fatalf("failed to load signing keys: %v", err)
+	}
+	jwtClient := jwt.NewJWTClient(keySet, cfg.System.AccessTokenTimeout, cfg.System.Issuer,
+		jwt.Audiences{Default: cfg.System.Audience}, cfg.System.ClockSkew)
+
+	mfaCipher, err := secure.NewCipher(testMfaKey)
+	if err != nil {
+		t.Fatalf("failed to create mfa cipher: %v", err)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "auth-service",
+		RPOrigins:     []string{"http://localhost:8080"},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.System.WebauthnTimeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.System.WebauthnTimeout},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create webauthn: %v", err)
+	}
+
+	log := zap.NewNop().Sugar()
+	auditLog := audit.New(f, nil, log)
+	t.Cleanup(auditLog.Close)
+	return NewAuthServer(cfg, f, jwtClient, denylist.New(f), mfaCipher, webAuthn, mailer.NewMemoryMailer(), auditLog, log).(*authServer)
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+	"newservice/pkg/clientinfo"
+	"newservice/pkg/jwt"
+	"newservice/pkg/secure"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// issueTokens начинает новую сессию устройства и выдаёт для неё пару токенов
+func (a *authServer) issueTokens(ctx context.Context, userID uuid.UUID, clientID, deviceName string) (*jwt.CreateTokenResponse, error) {
+	return a.openSession(ctx, repo.NewSessionParams{
+		ID:         uuid.New(),
+		UserID:     userID,
+		ClientID:   clientID,
+		DeviceName: deviceName,
+	})
+}
+
+// openSession создаёт сессию с заданными параметрами и выдаёт для неё пару токенов,
+// адрес и user agent берутся из запроса
+func (a *authServer) openSession(ctx context.Context, params repo.NewSessionParams) (*jwt.CreateTokenResponse, error) {
+	client := clientinfo.FromContext(ctx)
+	params.IP = client.IP
+	params.UserAgent = client.UserAgent
+
+	if err := a.repo.CreateSession(ctx, params); err != nil {
+		return nil, err
+	}
+
+	tokens, err := a.createToken(ctx, params.UserID, params.ID, params.ClientID, params.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.repo.NewAuthToken(ctx, repo.NewAuthTokenParams{
+		UserID:           params.UserID,
+		SessionID:        params.ID,
+		AccessTokenID:    tokens.AccessTokenId,
+		AccessExpiresAt:  tokens.AccessExpiresAt,
+		RefreshTokenHash: secure.HashToken(tokens.RefreshToken),
+		RefreshExpiresAt: time.Now().Add(a.cfg.System.RefreshTokenTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// createToken выпускает пару токенов сессии, встраивая в access токен актуальные роли и права пользователя
+// и статус подтверждения почты
+func (a *authServer) createToken(ctx context.Context, userID, sessionID uuid.UUID, clientID, scope string) (*jwt.CreateTokenResponse, error) {
+	user, err := a.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	authz, err := a.repo.GetUserAuthorization(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.jwt.CreateToken(&jwt.CreateTokenParams{
+		UserId:        userID,
+		SessionId:     sessionID,
+		ClientId:      clientID,
+		Scope:         scope,
+		Roles:         authz.Roles,
+		Permissions:   authz.Permissions,
+		EmailVerified: user.EmailVerifiedAt != nil,
+	})
+}
+
+// authenticate проверяет access токен пользователя: подпись, registered claims, тип токена и отсутствие в denylist.
+// Отзыв сессии попадает в denylist, поэтому в БД за сессией не ходим
+func (a *authServer) authenticate(ctx context.Context, accessToken string) (*jwt.GetDataFromTokenResponse, error) {
+	accessData, err := a.authenticateToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if accessData.SessionId == uuid.Nil {
+		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	}
+	return accessData, nil
+}
+
+// authenticateToken проверяет access токен пользователя или сервисного аккаунта. Для сервисного аккаунта
+// вызывающий дополнительно проверяет клиента через serviceAccount
+func (a *authServer) authenticateToken(_ context.Context, accessToken string) (*jwt.GetDataFromTokenResponse, error) {
+	check, err := a.jwt.ValidateToken(&jwt.ValidateTokenParams{
+		Token:     accessToken,
+		TokenType: jwt.TokenTypeAccess,
+	})
+	if errors.Is(err, jwt.ErrWrongTokenType) {
+		return nil, status.Error(codes.Unauthenticated, ErrAccessTokenExpected)
+	}
+	if err != nil || !check {
+		return nil, status.Error(codes.Unauthenticated, "JWT validation failed")
+	}
+
+	accessData, err := a.jwt.GetDataFromToken(&jwt.GetDataFromTokenParams{
+		Token: accessToken,
+	})
+	if err != nil || accessData.TokenId == "" || (accessData.SessionId == uuid.Nil && !accessData.IsClient()) {
+		return nil, status.Error(codes.Unauthenticated, ErrValidateJwt)
+	}
+
+	if a.denylist.IsRevoked(accessData.TokenId) {
+		return nil, status.Error(codes.Unauthenticated, ErrTokenRevoked)
+	}
+
+	return accessData, nil
+}
+
+// serviceAccount находит клиента, от имени которого выпущен токен, и проверяет, что он всё ещё сервисный аккаунт.
+// Токены клиента не привязаны к сессии, поэтому удаление клиента отзывает их только так
+func (a *authServer) serviceAccount(ctx context.Context, clientID string) (*repo.OAuthClient, error) {
+	client, err := a.repo.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.Unauthenticated, ErrTokenRevoked)
+		}
+		a.log.Errorf("get oauth client err: client_id = %s: %v", clientID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !slices.Contains(client.GrantTypes, GrantClientCredentials) {
+		return nil, status.Error(codes.Unauthenticated, ErrTokenRevoked)
+	}
+	return client, nil
+}
+
+// syncDenylist сразу подтягивает в кэш access токены, отозванные вместе с сессиями,
+// остальные реплики увидят их при следующей синхронизации
+func (a *authServer) syncDenylist(ctx context.Context) {
+	if err := a.denylist.Sync(ctx); err != nil {
+		a.log.Errorf("failed to sync token denylist: %v", err)
+	}
+}
+
+func (a *authServer) ListSessions(
+	ctx context.Context,
+	req *AuthService.ListSessionsRequest,
+) (
+	*AuthService.ListSessionsResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := a.repo.ListSessions(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("list sessions err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	resp := &AuthService.ListSessionsResponse{
+		Sessions: make([]*AuthService.Session, 0, len(sessions)),
+	}
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, &AuthService.Session{
+			Id:         s.ID.String(),
+			DeviceName: s.DeviceName,
+			Ip:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  timestamppb.New(s.CreatedAt),
+			LastUsedAt: timestamppb.New(s.LastUsedAt),
+			Current:    s.ID == accessData.SessionId,
+		})
+	}
+
+	return resp, nil
+}
+
+func (a *authServer) RevokeSession(
+	ctx context.Context,
+	req *AuthService.RevokeSessionRequest,
+) (
+	*AuthService.RevokeSessionResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := uuid.Parse(req.SessionId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid session id format")
+	}
+
+	revoked, err := a.repo.RevokeSession(ctx, repo.RevokeSessionParams{
+		SessionID: sessionID,
+		UserID:    accessData.UserId,
+	})
+	if err != nil {
+		a.log.Errorf("revoke session err: session_id = %s: %v", sessionID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	if !revoked {
+		return nil, status.Error(codes.NotFound, ErrSessionNotFound)
+	}
+	a.syncDenylist(ctx)
+	a.auditEvent(ctx, audit.ActionRevokeSession, audit.OutcomeSuccess, accessData.UserId, sessionID.String())
+
+	return &AuthService.RevokeSessionResponse{}, nil
+}
+
+func (a *authServer) RevokeAllOtherSessions(
+	ctx context.Context,
+	req *AuthService.RevokeAllOtherSessionsRequest,
+) (
+	*AuthService.RevokeAllOtherSessionsResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.repo.RevokeUserSessions(ctx, repo.RevokeUserSessionsParams{
+		UserID:          accessData.UserId,
+		ExceptSessionID: accessData.SessionId,
+	})
+	if err != nil {
+		a.log.Errorf("revoke other sessions err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.syncDenylist(ctx)
+	a.auditEvent(ctx, audit.ActionRevokeOtherSessions, audit.OutcomeSuccess, accessData.UserId, accessData.UserId.String(),
+		"current_session_id", accessData.SessionId.String())
+
+	return &AuthService.RevokeAllOtherSessionsResponse{}, nil
+}
+
+// RevokeAccessToken отзывает один access токен до истечения его срока
+func (a *authServer) RevokeAccessToken(
+	ctx context.Context,
+	req *AuthService.RevokeAccessTokenRequest,
+) (
+	*AuthService.RevokeAccessTokenResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.denylist.Revoke(ctx, accessData.TokenId, accessData.ExpiresAt); err != nil {
+		a.log.Errorf("revoke access token err: jti = %s: %v", accessData.TokenId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionRevokeAccessToken, audit.OutcomeSuccess, accessData.UserId, accessData.TokenId)
+
+	return &AuthService.RevokeAccessTokenResponse{}, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validate проверяет access токен и возвращает код ответа Validate
+func validate(srv *authServer, accessToken string) codes.Code {
+	_, err := srv.Validate(context.Background(), &AuthService.ValidateRequest{AccessToken: accessToken})
+	return status.Code(err)
+}
+
+func TestListSessions(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	f.addUser("bob")
+	current := login(t, srv, "alice")
+	login(t, srv, "alice")
+	login(t, srv, "bob")
+
+	resp, err := srv.ListSessions(context.Background(), &AuthService.ListSessionsRequest{AccessToken: current.AccessToken})
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(resp.Sessions) != 2 {
+		t.Fatalf("ListSessions() sessions = %d, want 2", len(resp.Sessions))
+	}
+	for _, s := range resp.Sessions {
+		want := s.Id == f.session(t, current.RefreshToken).ID.String()
+		if s.Current != want {
+			t.Fatalf("session %s current = %v, want %v", s.Id, s.Current, want)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	f.addUser("bob")
+	current := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+	foreign := login(t, srv, "bob")
+
+	// чужую сессию отозвать нельзя
+	_, err := srv.RevokeSession(context.Background(), &AuthService.RevokeSessionRequest{
+		AccessToken: current.AccessToken,
+		SessionId:   f.session(t, foreign.RefreshToken).ID.String(),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeSession() of another user code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+	if got := validate(srv, foreign.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of another user's session code = %v, want %v", got, codes.OK)
+	}
+
+	if _, err := srv.RevokeSession(context.Background(), &AuthService.RevokeSessionRequest{
+		AccessToken: current.AccessToken,
+		SessionId:   f.session(t, other.RefreshToken).ID.String(),
+	}); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if got := validate(srv, other.AccessToken); got != codes.Unauthenticated {
+		t.Fatalf("Validate() of a revoked session code = %v, want %v", got, codes.Unauthenticated)
+	}
+	_, err = srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  other.AccessToken,
+		RefreshToken: other.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh() of a revoked session code = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+	if got := validate(srv, current.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of the current session code = %v, want %v", got, codes.OK)
+	}
+}
+
+func TestRevokeAllOtherSessions(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	f.addUser("alice")
+	f.addUser("bob")
+	current := login(t, srv, "alice")
+	others := []*AuthService.LoginResponse{login(t, srv, "alice"), login(t, srv, "alice")}
+	foreign := login(t, srv, "bob")
+
+	if _, err := srv.RevokeAllOtherSessions(context.Background(), &AuthService.RevokeAllOtherSessionsRequest{
+		AccessToken: current.AccessToken,
+	}); err != nil {
+		t.Fatalf("RevokeAllOtherSessions() error = %v", err)
+	}
+
+	for _, other := range others {
+		if got := validate(srv, other.AccessToken); got != codes.Unauthenticated {
+			t.Fatalf("Validate() of another session code = %v, want %v", got, codes.Unauthenticated)
+		}
+	}
+	// сессия, из которой пришёл запрос, и сессии других пользователей остаются
+	if got := validate(srv, current.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of the caller's session code = %v, want %v", got, codes.OK)
+	}
+	if _, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  current.AccessToken,
+		RefreshToken: current.RefreshToken,
+	}); err != nil {
+		t.Fatalf("Refresh() of the caller's session error = %v", err)
+	}
+	if got := validate(srv, foreign.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of another user's session code = %v, want %v", got, codes.OK)
+	}
+}
+
+func TestRevokeJwt(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	admin := f.addUser("admin")
+	f.grant(admin.ID, PermissionAdmin)
+	alice := f.addUser("alice")
+	adminTokens := login(t, srv, "admin")
+	current := login(t, srv, "alice")
+	other := login(t, srv, "alice")
+
+	tests := []struct {
+		name        string
+		accessToken string
+		want        codes.Code
+	}{
+		{name: "without token", accessToken: "", want: codes.Unauthenticated},
+		// свои сессии пользователь завершает через RevokeAllOtherSessions
+		{name: "own sessions without auth:admin", accessToken: current.AccessToken, want: codes.PermissionDenied},
+		{name: "admin", accessToken: adminTokens.AccessToken, want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := srv.RevokeJwt(context.Background(), &AuthService.RevokeJwtRequest{
+				AccessToken: tt.accessToken,
+				UserId:      alice.ID.String(),
+			})
+			if status.Code(err) != tt.want {
+				t.Fatalf("RevokeJwt() code = %v, want %v", status.Code(err), tt.want)
+			}
+		})
+	}
+
+	// завершены все сессии пользователя
+	for _, tokens := range []*AuthService.LoginResponse{current, other} {
+		if got := validate(srv, tokens.AccessToken); got != codes.Unauthenticated {
+			t.Fatalf("Validate() after RevokeJwt() code = %v, want %v", got, codes.Unauthenticated)
+		}
+	}
+	if got := validate(srv, adminTokens.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of the admin's session code = %v, want %v", got, codes.OK)
+	}
+
+	// в журнале видно, какой администратор завершил сессии
+	flushAudit(t, srv)
+	last := f.auditEvents[len(f.auditEvents)-1]
+	if last.Action != audit.ActionRevokeUserSessions || last.ActorID == nil || *last.ActorID != admin.ID || last.Target != alice.ID.String() {
+		t.Fatalf("last audit event = %+v, want %s by the admin", last, audit.ActionRevokeUserSessions)
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	// вторая реплика со своим кэшем denylist поверх той же БД
+	replica := newTestServer(t, f)
+	f.addUser("alice")
+	tokens := login(t, srv, "alice")
+
+	if _, err := srv.RevokeAccessToken(context.Background(), &AuthService.RevokeAccessTokenRequest{
+		AccessToken: tokens.AccessToken,
+	}); err != nil {
+		t.Fatalf("RevokeAccessToken() error = %v", err)
+	}
+	if got := validate(srv, tokens.AccessToken); got != codes.Unauthenticated {
+		t.Fatalf("Validate() of a revoked token code = %v, want %v", got, codes.Unauthenticated)
+	}
+
+	// другая реплика узнаёт об отзыве при синхронизации
+	if got := validate(replica, tokens.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() on a replica before sync code = %v, want %v", got, codes.OK)
+	}
+	if err := replica.denylist.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := validate(replica, tokens.AccessToken); got != codes.Unauthenticated {
+		t.Fatalf("Validate() on a replica after sync code = %v, want %v", got, codes.Unauthenticated)
+	}
+
+	// отзывается только сам токен, сессия продолжает работать
+	refreshed, err := srv.Refresh(context.Background(), &AuthService.RefreshRequest{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := validate(srv, refreshed.AccessToken); got != codes.OK {
+		t.Fatalf("Validate() of a new access token code = %v, want %v", got, codes.OK)
+	}
+}
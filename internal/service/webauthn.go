@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/audit"
+	"newservice/internal/repo"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// назначение challenge церемонии WebAuthn
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+// webauthnUser - пользователь в терминах библиотеки WebAuthn, user handle - байты его UUID
+type webauthnUser struct {
+	user        *repo.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (a *authServer) loadWebauthnUser(ctx context.Context, user *repo.User) (*webauthnUser, error) {
+	stored, err := a.repo.GetWebauthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, c := range stored {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+
+	return &webauthnUser{
+		user:        user,
+		credentials: credentials,
+	}, nil
+}
+
+// saveCeremony сохраняет состояние церемонии в БД, чтобы её можно было завершить на любой реплике
+func (a *authServer) saveCeremony(ctx context.Context, userID *uuid.UUID, purpose string, session *webauthn.SessionData) (uuid.UUID, error) {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	err = a.repo.CreateWebauthnChallenge(ctx, repo.WebauthnChallenge{
+		ID:          id,
+		UserID:      userID,
+		Purpose:     purpose,
+		SessionData: sessionData,
+		ExpiresAt:   time.Now().Add(a.cfg.System.WebauthnTimeout),
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+// takeCeremony забирает состояние церемонии, повторно завершить её нельзя
+func (a *authServer) takeCeremony(ctx context.Context, ceremonyID, purpose string) (*repo.WebauthnChallenge, *webauthn.SessionData, error) {
+	id, err := uuid.Parse(ceremonyID)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "invalid ceremony id format")
+	}
+
+	challenge, err := a.repo.TakeWebauthnChallenge(ctx, id, purpose)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, status.Error(codes.NotFound, ErrCeremonyNotFound)
+		}
+		a.log.Errorf("take webauthn challenge err: %v", err)
+		return nil, nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.SessionData, &session); err != nil {
+		a.log.Errorf("decode webauthn session err: %v", err)
+		return nil, nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	return challenge, &session, nil
+}
+
+func (a *authServer) BeginWebauthnRegistration(
+	ctx context.Context,
+	req *AuthService.BeginWebauthnRegistrationRequest,
+) (
+	*AuthService.BeginWebauthnRegistrationResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.repo.GetUserByID(ctx, accessData.UserId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, ErrUserNotFound)
+		}
+		a.log.Errorf("get user err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	waUser, err := a.loadWebauthnUser(ctx, user)
+	if err != nil {
+		a.log.Errorf("load webauthn credentials err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	// уже зарегистрированные ключи аутентификатор не должен создавать повторно
+	creation, session, err := a.webauthn.BeginRegistration(waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		a.log.Errorf("begin webauthn registration err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	ceremonyID, err := a.saveCeremony(ctx, &user.ID, ceremonyRegistration, session)
+	if err != nil {
+		a.log.Errorf("save webauthn challenge err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	options, err := json.Marshal(creation)
+	if err != nil {
+		a.log.Errorf("encode webauthn options err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.BeginWebauthnRegistrationResponse{
+		CeremonyId: ceremonyID.String(),
+		Options:    options,
+	}, nil
+}
+
+func (a *authServer) FinishWebauthnRegistration(
+	ctx context.Context,
+	req *AuthService.FinishWebauthnRegistrationRequest,
+) (
+	*AuthService.FinishWebauthnRegistrationResponse, error,
+) {
+	accessData, err := a.authenticate(ctx, req.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, session, err := a.takeCeremony(ctx, req.CeremonyId, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != accessData.UserId {
+		return nil, status.Error(codes.NotFound, ErrCeremonyNotFound)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, ErrPasskeyVerification)
+	}
+
+	user, err := a.repo.GetUserByID(ctx, accessData.UserId)
+	if err != nil {
+		a.log.Errorf("get user err: user_id = %s: %v", accessData.UserId, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	waUser, err := a.loadWebauthnUser(ctx, user)
+	if err != nil {
+		a.log.Errorf("load webauthn credentials err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	credential, err := a.webauthn.CreateCredential(waUser, *session, parsed)
+	if err != nil {
+		a.log.Infof("webauthn registration failed: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.InvalidArgument, ErrPasskeyVerification)
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	err = a.repo.CreateWebauthnCredential(ctx, repo.WebauthnCredential{
+		ID:              credential.ID,
+		UserID:          user.ID,
+		Name:            req.Name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		Flags:           int16(credential.Flags.ProtocolValue()),
+		SignCount:       int64(credential.Authenticator.SignCount),
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return nil, status.Error(codes.AlreadyExists, ErrPasskeyAlreadyExist)
+		}
+		a.log.Errorf("save webauthn credential err: user_id = %s: %v", user.ID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.FinishWebauthnRegistrationResponse{
+		CredentialId: base64.RawURLEncoding.EncodeToString(credential.ID),
+	}, nil
+}
+
+// BeginWebauthnLogin начинает вход по ключу доступа. Без имени пользователя выбор учётной записи
+// остаётся за аутентификатором (discoverable credentials)
+func (a *authServer) BeginWebauthnLogin(
+	ctx context.Context,
+	req *AuthService.BeginWebauthnLoginRequest,
+) (
+	*AuthService.BeginWebauthnLoginResponse, error,
+) {
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		userID    *uuid.UUID
+		err       error
+	)
+
+	if req.Username != "" {
+		user, err := a.findUserByLogin(ctx, req.Username)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		waUser, err := a.loadWebauthnUser(ctx, user)
+		if err != nil {
+			a.log.Errorf("load webauthn credentials err: user_id = %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		if len(waUser.credentials) == 0 {
+			return nil, status.Error(codes.FailedPrecondition, ErrNoPasskeys)
+		}
+
+		assertion, session, err = a.webauthn.BeginLogin(waUser, webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			a.log.Errorf("begin webauthn login err: user_id = %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+		userID = &user.ID
+	} else {
+		assertion, session, err = a.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			a.log.Errorf("begin webauthn login err: %v", err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+	}
+
+	ceremonyID, err := a.saveCeremony(ctx, userID, ceremonyLogin, session)
+	if err != nil {
+		a.log.Errorf("save webauthn challenge err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	options, err := json.Marshal(assertion)
+	if err != nil {
+		a.log.Errorf("encode webauthn options err: %v", err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	return &AuthService.BeginWebauthnLoginResponse{
+		CeremonyId: ceremonyID.String(),
+		Options:    options,
+	}, nil
+}
+
+// FinishWebauthnLogin проверяет подпись аутентификатора и выдаёт ту же пару токенов, что и Login.
+// Ключ доступа с проверкой пользователя (UV) сам по себе двухфакторный, поэтому TOTP не запрашивается
+func (a *authServer) FinishWebauthnLogin(
+	ctx context.Context,
+	req *AuthService.FinishWebauthnLoginRequest,
+) (
+	*AuthService.FinishWebauthnLoginResponse, error,
+) {
+	challenge, session, err := a.takeCeremony(ctx, req.CeremonyId, ceremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, ErrPasskeyVerification)
+	}
+
+	var (
+		waUser     *webauthnUser
+		credential *webauthn.Credential
+	)
+	if challenge.UserID != nil {
+		var user *repo.User
+		user, err = a.repo.GetUserByID(ctx, *challenge.UserID)
+		if err != nil {
+			a.log.Errorf("get user err: user_id = %s: %v", *challenge.UserID, err)
+			return nil, status.Error(codes.Unauthenticated, ErrPasskeyVerification)
+		}
+
+		waUser, err = a.loadWebauthnUser(ctx, user)
+		if err != nil {
+			a.log.Errorf("load webauthn credentials err: user_id = %s: %v", user.ID, err)
+			return nil, status.Error(codes.Internal, ErrUnknown)
+		}
+
+		credential, err = a.webauthn.ValidateLogin(waUser, *session, parsed)
+	} else {
+		// учётную запись определяет user handle, который вернул аутентификатор
+		credential, err = a.webauthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+
+			user, err := a.repo.GetUserByID(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			waUser, err = a.loadWebauthnUser(ctx, user)
+			return waUser, err
+		}, *session, parsed)
+	}
+	if err != nil || waUser == nil || credential == nil {
+		a.log.Infof("webauthn login failed: %v", err)
+		return nil, status.Error(codes.Unauthenticated, ErrPasskeyVerification)
+	}
+
+	userID := waUser.user.ID
+
+	if err := a.checkEmailVerified(waUser.user); err != nil {
+		return nil, err
+	}
+
+	// счётчик подписей не вырос - у ключа может быть копия
+	if credential.Authenticator.CloneWarning {
+		a.securityEvent(ctx, EventPasskeyCloned, userID,
+			"credential_id", base64.RawURLEncoding.EncodeToString(credential.ID))
+		return nil, status.Error(codes.Unauthenticated, ErrPasskeyVerification)
+	}
+
+	err = a.repo.UpdateWebauthnCredentialUsage(ctx, repo.WebauthnCredential{
+		ID:        credential.ID,
+		SignCount: int64(credential.Authenticator.SignCount),
+		Flags:     int16(parsed.Response.AuthenticatorData.Flags),
+	})
+	if err != nil {
+		a.log.Errorf("update webauthn credential err: user_id = %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+
+	tokens, err := a.issueTokens(ctx, userID, req.ClientId, req.DeviceName)
+	if err != nil {
+		a.log.Errorf("failed to add auth token for user %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, ErrUnknown)
+	}
+	a.auditEvent(ctx, audit.ActionLogin, audit.OutcomeSuccess, userID, userID.String(), "method", "passkey")
+
+	return &AuthService.FinishWebauthnLoginResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
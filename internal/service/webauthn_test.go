@@ -0,0 +1,445 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	AuthService "newservice/grpc/genproto"
+	"newservice/internal/repo"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testOrigin - origin, который браузер подставляет в clientDataJSON
+const testOrigin = "http://localhost:8080"
+
+// softAuthenticator - программный аутентификатор: один ключ ES256 без аттестации ("none"),
+// подписи с явным счётчиком, чтобы проверять реакцию на клон
+type softAuthenticator struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	credID []byte
+	rpHash [32]byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		t.Fatalf("failed to generate credential id: %v", err)
+	}
+	return &softAuthenticator{
+		t:      t,
+		key:    key,
+		credID: credID,
+		rpHash: sha256.Sum256([]byte("localhost")),
+	}
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// optionsChallenge достаёт challenge из параметров, которые сервер отдаёт браузеру
+func (s *softAuthenticator) optionsChallenge(options []byte) string {
+	s.t.Helper()
+
+	var parsed struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &parsed); err != nil || parsed.PublicKey.Challenge == "" {
+		s.t.Fatalf("invalid webauthn options %s: %v", options, err)
+	}
+	return parsed.PublicKey.Challenge
+}
+
+func (s *softAuthenticator) clientData(ceremonyType, challenge string) []byte {
+	clientData, _ := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	return clientData
+}
+
+// create отвечает на navigator.credentials.create
+func (s *softAuthenticator) create(options []byte) []byte {
+	s.t.Helper()
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: s.key.X.FillBytes(make([]byte, 32)),
+		-3: s.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	// флаги UP | UV | AT, счётчик 0, нулевой AAGUID
+	authData := append([]byte{}, s.rpHash[:]...)
+	authData = append(authData, 0x45, 0, 0, 0, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(s.credID)))
+	authData = append(authData, s.credID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode attestation: %v", err)
+	}
+
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    b64url(s.credID),
+		"rawId": b64url(s.credID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64url(s.clientData("webauthn.create", s.optionsChallenge(options))),
+			"attestationObject": b64url(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+	return credential
+}
+
+// get отвечает на navigator.credentials.get подписью с заданным счётчиком
+func (s *softAuthenticator) get(options []byte, userID uuid.UUID, signCount uint32) []byte {
+	s.t.Helper()
+
+	// флаги UP | UV
+	authData := append([]byte{}, s.rpHash[:]...)
+	authData = append(authData, 0x05)
+	authData = binary.BigEndian.AppendUint32(authData, signCount)
+
+	clientData := s.clientData("webauthn.get", s.optionsChallenge(options))
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		s.t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    b64url(s.credID),
+		"rawId": b64url(s.credID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64url(clientData),
+			"authenticatorData": b64url(authData),
+			"signature":         b64url(signature),
+			"userHandle":        b64url(userID[:]),
+		},
+	})
+	return credential
+}
+
+// registerPasskey проходит регистрацию ключа от имени вошедшего пользователя
+func registerPasskey(t *testing.T, srv *authServer, user *repo.User, authenticator *softAuthenticator) {
+	t.Helper()
+	ctx := context.Background()
+
+	tokens, err := srv.issueTokens(ctx, user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+
+	begin, err := srv.BeginWebauthnRegistration(ctx, &AuthService.BeginWebauthnRegistrationRequest{
+		AccessToken: tokens.AccessToken,
+	})
+	if err != nil {
+		t.Fatalf("BeginWebauthnRegistration() error = %v", err)
+	}
+
+	finish, err := srv.FinishWebauthnRegistration(ctx, &AuthService.FinishWebauthnRegistrationRequest{
+		AccessToken: tokens.AccessToken,
+		CeremonyId:  begin.CeremonyId,
+		Credential:  authenticator.create(begin.Options),
+		Name:        "laptop",
+	})
+	if err != nil {
+		t.Fatalf("FinishWebauthnRegistration() error = %v", err)
+	}
+	if finish.CredentialId != b64url(authenticator.credID) {
+		t.Fatalf("FinishWebauthnRegistration() credential id = %q, want %q", finish.CredentialId, b64url(authenticator.credID))
+	}
+}
+
+// loginWithPasskey проходит вход по ключу и возвращает ответ FinishWebauthnLogin
+func loginWithPasskey(
+	t *testing.T, srv *authServer, username string, userID uuid.UUID, authenticator *softAuthenticator, signCount uint32,
+) (*AuthService.FinishWebauthnLoginResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	begin, err := srv.BeginWebauthnLogin(ctx, &AuthService.BeginWebauthnLoginRequest{Username: username})
+	if err != nil {
+		t.Fatalf("BeginWebauthnLogin() error = %v", err)
+	}
+	return srv.FinishWebauthnLogin(ctx, &AuthService.FinishWebauthnLoginRequest{
+		CeremonyId: begin.CeremonyId,
+		Credential: authenticator.get(begin.Options, userID, signCount),
+	})
+}
+
+func TestWebauthnRegistration(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, srv, user, authenticator)
+
+	if len(f.passkeys) != 1 {
+		t.Fatalf("stored passkeys = %d, want 1", len(f.passkeys))
+	}
+	passkey := f.passkeys[0]
+	if passkey.UserID != user.ID || passkey.Name != "laptop" || passkey.AttestationType != "none" {
+		t.Fatalf("stored passkey = %+v", passkey)
+	}
+
+	// повторная регистрация того же ключа: аутентификатор обязан его исключить,
+	// а если не исключил - сервер не должен завести дубликат
+	tokens, err := srv.issueTokens(context.Background(), user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+	begin, err := srv.BeginWebauthnRegistration(context.Background(), &AuthService.BeginWebauthnRegistrationRequest{
+		AccessToken: tokens.AccessToken,
+	})
+	if err != nil {
+		t.Fatalf("BeginWebauthnRegistration() error = %v", err)
+	}
+	var options struct {
+		PublicKey struct {
+			ExcludeCredentials []struct {
+				ID string `json:"id"`
+			} `json:"excludeCredentials"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(begin.Options, &options); err != nil {
+		t.Fatalf("invalid webauthn options: %v", err)
+	}
+	if len(options.PublicKey.ExcludeCredentials) != 1 || options.PublicKey.ExcludeCredentials[0].ID != b64url(authenticator.credID) {
+		t.Fatalf("excludeCredentials = %+v, want the registered passkey", options.PublicKey.ExcludeCredentials)
+	}
+
+	_, err = srv.FinishWebauthnRegistration(context.Background(), &AuthService.FinishWebauthnRegistrationRequest{
+		AccessToken: tokens.AccessToken,
+		CeremonyId:  begin.CeremonyId,
+		Credential:  authenticator.create(begin.Options),
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("FinishWebauthnRegistration() duplicate error = %v, want AlreadyExists", err)
+	}
+}
+
+func TestWebauthnRegistrationOtherUserCeremony(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	alice := f.addUser("alice")
+	mallory := f.addUser("mallory")
+	ctx := context.Background()
+
+	aliceTokens, err := srv.issueTokens(ctx, alice.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+	malloryTokens, err := srv.issueTokens(ctx, mallory.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+
+	begin, err := srv.BeginWebauthnRegistration(ctx, &AuthService.BeginWebauthnRegistrationRequest{
+		AccessToken: aliceTokens.AccessToken,
+	})
+	if err != nil {
+		t.Fatalf("BeginWebauthnRegistration() error = %v", err)
+	}
+
+	// чужую церемонию завершить нельзя
+	_, err = srv.FinishWebauthnRegistration(ctx, &AuthService.FinishWebauthnRegistrationRequest{
+		AccessToken: malloryTokens.AccessToken,
+		CeremonyId:  begin.CeremonyId,
+		Credential:  newSoftAuthenticator(t).create(begin.Options),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("FinishWebauthnRegistration() error = %v, want NotFound", err)
+	}
+	if len(f.passkeys) != 0 {
+		t.Fatalf("stored passkeys = %d, want 0", len(f.passkeys))
+	}
+}
+
+func TestWebauthnLogin(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, srv, user, authenticator)
+
+	tests := []struct {
+		name     string
+		username string
+	}{
+		{name: "by username", username: "alice"},
+		{name: "by email", username: "Alice@Example.com"},
+		{name: "discoverable", username: ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signCount := uint32(i + 1)
+			resp, err := loginWithPasskey(t, srv, tt.username, user.ID, authenticator, signCount)
+			if err != nil {
+				t.Fatalf("FinishWebauthnLogin() error = %v", err)
+			}
+			if resp.AccessToken == "" || resp.RefreshToken == "" {
+				t.Fatal("FinishWebauthnLogin() returned no tokens")
+			}
+			if got := f.passkeys[0].SignCount; got != int64(signCount) {
+				t.Fatalf("stored sign count = %d, want %d", got, signCount)
+			}
+		})
+	}
+}
+
+func TestWebauthnLoginWrongKey(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, srv, user, authenticator)
+
+	// тот же credential id, но подпись другим ключом
+	forged := newSoftAuthenticator(t)
+	forged.credID = authenticator.credID
+
+	_, err := loginWithPasskey(t, srv, "alice", user.ID, forged, 1)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("FinishWebauthnLogin() error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestWebauthnCeremonyOneTime(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, srv, user, authenticator)
+	ctx := context.Background()
+
+	begin, err := srv.BeginWebauthnLogin(ctx, &AuthService.BeginWebauthnLoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("BeginWebauthnLogin() error = %v", err)
+	}
+	req := &AuthService.FinishWebauthnLoginRequest{
+		CeremonyId: begin.CeremonyId,
+		Credential: authenticator.get(begin.Options, user.ID, 1),
+	}
+	if _, err := srv.FinishWebauthnLogin(ctx, req); err != nil {
+		t.Fatalf("FinishWebauthnLogin() error = %v", err)
+	}
+
+	// перехваченный ответ нельзя предъявить повторно, даже с новым счётчиком
+	if _, err := srv.FinishWebauthnLogin(ctx, req); status.Code(err) != codes.NotFound {
+		t.Fatalf("FinishWebauthnLogin() replay error = %v, want NotFound", err)
+	}
+	req.Credential = authenticator.get(begin.Options, user.ID, 2)
+	if _, err := srv.FinishWebauthnLogin(ctx, req); status.Code(err) != codes.NotFound {
+		t.Fatalf("FinishWebauthnLogin() reused ceremony error = %v, want NotFound", err)
+	}
+
+	// challenge регистрации не годится для входа
+	tokens, err := srv.issueTokens(ctx, user.ID, "", "")
+	if err != nil {
+		t.Fatalf("failed to issue tokens: %v", err)
+	}
+	registration, err := srv.BeginWebauthnRegistration(ctx, &AuthService.BeginWebauthnRegistrationRequest{
+		AccessToken: tokens.AccessToken,
+	})
+	if err != nil {
+		t.Fatalf("BeginWebauthnRegistration() error = %v", err)
+	}
+	_, err = srv.FinishWebauthnLogin(ctx, &AuthService.FinishWebauthnLoginRequest{
+		CeremonyId: registration.CeremonyId,
+		Credential: authenticator.get(registration.Options, user.ID, 3),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("FinishWebauthnLogin() with registration ceremony error = %v, want NotFound", err)
+	}
+}
+
+func TestWebauthnCloneWarning(t *testing.T) {
+	f := newFakeRepo()
+	srv := newTestServer(t, f)
+	user := f.addUser("alice")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, srv, user, authenticator)
+
+	if _, err := loginWithPasskey(t, srv, "alice", user.ID, authenticator, 5); err != nil {
+		t.Fatalf("FinishWebauthnLogin() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		signCount uint32
+	}{
+		{name: "same counter", signCount: 5},
+		{name: "lower counter", signCount: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := len(f.sessions)
+
+			resp, err := loginWithPasskey(t, srv, "alice", user.ID, authenticator, tt.signCount)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("FinishWebauthnLogin() = %v, %v, want Unauthenticated", resp, err)
+			}
+			if len(f.sessions) != sessions {
+				t.Fatal("FinishWebauthnLogin() created a session for a cloned passkey")
+			}
+			if got := f.passkeys[0].SignCount; got != 5 {
+				t.Fatalf("stored sign count = %d, want 5", got)
+			}
+
+			flushAudit(t, srv)
+			last := f.auditEvents[len(f.auditEvents)-1]
+			if last.Action != EventPasskeyCloned || last.Target != user.ID.String() {
+				t.Fatalf("last audit event = %s %s, want %s", last.Action, last.Target, EventPasskeyCloned)
+			}
+		})
+	}
+
+	// ключ без счётчика (всегда 0) предупреждения не вызывает
+	f2 := newFakeRepo()
+	srv2 := newTestServer(t, f2)
+	user2 := f2.addUser("bob")
+	counterless := newSoftAuthenticator(t)
+	registerPasskey(t, srv2, user2, counterless)
+	for i := 0; i < 2; i++ {
+		if _, err := loginWithPasskey(t, srv2, "bob", user2.ID, counterless, 0); err != nil {
+			t.Fatalf("FinishWebauthnLogin() without counter error = %v", err)
+		}
+	}
+}
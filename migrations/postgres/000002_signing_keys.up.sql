@@ -0,0 +1,8 @@
+-- ключи подписи JWT для источника JWT_KEYS_SOURCE=db
+CREATE TABLE signing_keys (
+    id          SERIAL PRIMARY KEY,
+    private_key TEXT        NOT NULL, -- PEM, PKCS1 или PKCS8
+    activate_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    retired_at  TIMESTAMPTZ, -- принудительный вывод ключа, например при компрометации
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
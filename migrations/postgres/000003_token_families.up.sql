@@ -0,0 +1,12 @@
+-- семейства refresh токенов: каждый логин начинает семейство, каждый refresh помечает предыдущий токен использованным
+ALTER TABLE auth_tokens
+    ADD COLUMN family_id   UUID,
+    ADD COLUMN consumed_at TIMESTAMPTZ,
+    ADD COLUMN revoked_at  TIMESTAMPTZ;
+
+-- уже выданные токены становятся отдельными семействами
+UPDATE auth_tokens SET family_id = gen_random_uuid() WHERE family_id IS NULL;
+
+ALTER TABLE auth_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_auth_tokens_family_id ON auth_tokens (family_id);
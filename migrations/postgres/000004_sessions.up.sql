@@ -0,0 +1,25 @@
+-- сессии устройств: семейство refresh токенов становится сессией
+CREATE TABLE sessions (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID         NOT NULL,
+    device_name  VARCHAR(100) NOT NULL DEFAULT '',
+    ip           TEXT         NOT NULL DEFAULT '',
+    user_agent   TEXT         NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+-- переносим уже существующие семейства в сессии
+INSERT INTO sessions (id, user_id, created_at, last_used_at, revoked_at)
+SELECT family_id, user_id, MIN(created_at), MAX(updated_at), MAX(revoked_at)
+FROM auth_tokens
+GROUP BY family_id, user_id;
+
+ALTER TABLE auth_tokens RENAME COLUMN family_id TO session_id;
+ALTER INDEX idx_auth_tokens_family_id RENAME TO idx_auth_tokens_session_id;
+
+ALTER TABLE auth_tokens
+    ADD CONSTRAINT fk_auth_tokens_session FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE;
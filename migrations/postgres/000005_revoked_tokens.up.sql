@@ -0,0 +1,12 @@
+-- отозванные access токены (denylist), запись нужна только до истечения токена
+CREATE TABLE revoked_tokens (
+    jti        TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_tokens_created_at ON revoked_tokens (created_at);
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+-- jti текущего access токена сессии, чтобы при отзыве сессии отозвать и его
+ALTER TABLE auth_tokens ADD COLUMN access_jti TEXT;
@@ -0,0 +1,2 @@
+-- клиент (приложение), для которого открыта сессия, определяет aud выдаваемых токенов
+ALTER TABLE sessions ADD COLUMN client_id VARCHAR(100) NOT NULL DEFAULT '';
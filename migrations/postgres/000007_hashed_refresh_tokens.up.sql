@@ -0,0 +1,12 @@
+-- refresh токены хранятся только в виде SHA-256, access токены не хранятся вовсе
+ALTER TABLE auth_tokens ADD COLUMN refresh_token_hash TEXT;
+
+-- хэш считается от строки токена целиком, поэтому уже выданные JWT refresh токены продолжают работать
+UPDATE auth_tokens SET refresh_token_hash = encode(sha256(convert_to(refresh_token, 'UTF8')), 'hex');
+
+ALTER TABLE auth_tokens ALTER COLUMN refresh_token_hash SET NOT NULL;
+ALTER TABLE auth_tokens ADD CONSTRAINT auth_tokens_refresh_token_hash_key UNIQUE (refresh_token_hash);
+
+ALTER TABLE auth_tokens
+    DROP COLUMN refresh_token,
+    DROP COLUMN access_token;
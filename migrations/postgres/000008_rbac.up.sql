@@ -0,0 +1,39 @@
+-- роли и права доступа
+CREATE TABLE roles (
+    id          SERIAL PRIMARY KEY,
+    name        VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT         NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- право - строка вида documents:read
+CREATE TABLE permissions (
+    id   SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL
+);
+
+CREATE TABLE role_permissions (
+    role_id       INT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id INT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- назначение роли пользователю, resource = '*' - на все ресурсы
+CREATE TABLE user_roles (
+    user_id    UUID         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id    INT          NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    resource   VARCHAR(255) NOT NULL DEFAULT '*',
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id, resource)
+);
+
+CREATE INDEX idx_user_roles_role_id ON user_roles (role_id);
+
+-- роль администратора сервиса, первого администратора назначают вручную:
+-- INSERT INTO user_roles (user_id, role_id) SELECT '<user id>', id FROM roles WHERE name = 'admin';
+INSERT INTO roles (name, description) VALUES ('admin', 'Administration of the auth service');
+INSERT INTO permissions (name) VALUES ('auth:admin');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'auth:admin';
@@ -0,0 +1,21 @@
+-- секреты TOTP, зашифрованные AES-GCM ключом MFA_ENCRYPTION_KEY
+CREATE TABLE user_totp (
+    user_id        UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret         BYTEA       NOT NULL,
+    confirmed_at   TIMESTAMPTZ,          -- NULL, пока пользователь не подтвердил подключение кодом
+    last_used_step BIGINT      NOT NULL DEFAULT 0, -- защита от повторного использования кода
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- счётчики неудачных попыток, общие для всех реплик сервиса
+CREATE TABLE login_failures (
+    scope          VARCHAR(10)  NOT NULL, -- по чему считаются попытки, см. repo.LoginScope*
+    subject        VARCHAR(100) NOT NULL, -- значение в рамках scope
+    failures       INT          NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ  NOT NULL,
+    locked_until   TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
+
+-- очистка устаревших счётчиков
+CREATE INDEX idx_login_failures_last_failed_at ON login_failures (last_failed_at);
@@ -0,0 +1,12 @@
+-- одноразовые коды восстановления доступа при потере второго фактора, хранятся как HMAC-SHA256 на ключе MFA_ENCRYPTION_KEY
+CREATE TABLE recovery_codes (
+    id              BIGSERIAL PRIMARY KEY,
+    user_id         UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash       TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at         TIMESTAMPTZ,
+    used_ip         VARCHAR(45),
+    used_user_agent TEXT
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,27 @@
+-- ключи доступа (passkeys) WebAuthn
+CREATE TABLE webauthn_credentials (
+    id               BYTEA PRIMARY KEY,              -- credential id, выданный аутентификатором
+    user_id          UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             VARCHAR(255) NOT NULL DEFAULT '',
+    public_key       BYTEA       NOT NULL,            -- COSE ключ
+    attestation_type VARCHAR(50) NOT NULL DEFAULT '',
+    transports       TEXT[]      NOT NULL DEFAULT '{}',
+    aaguid           BYTEA,
+    flags            SMALLINT    NOT NULL DEFAULT 0,  -- флаги authenticator data (UP, UV, BE, BS)
+    sign_count       BIGINT      NOT NULL DEFAULT 0,  -- счётчик подписей для обнаружения клонированных ключей
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+-- незавершённые церемонии регистрации и входа, challenge одноразовый
+CREATE TABLE webauthn_challenges (
+    id           UUID PRIMARY KEY,
+    user_id      UUID REFERENCES users (id) ON DELETE CASCADE, -- NULL при входе по passkey без имени пользователя
+    purpose      VARCHAR(20) NOT NULL,                          -- registration или login
+    session_data JSONB       NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
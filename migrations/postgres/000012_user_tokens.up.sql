@@ -0,0 +1,13 @@
+-- одноразовые токены из писем (сброс пароля и т.п.), хранится только SHA-256 хэш
+CREATE TABLE user_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    VARCHAR(30) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    payload    TEXT        NOT NULL DEFAULT '', -- данные, которые применяются при использовании токена
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens (user_id, purpose);
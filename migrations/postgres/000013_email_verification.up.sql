@@ -0,0 +1,5 @@
+-- подтверждение почты: новые пользователи начинают с неподтверждённым адресом,
+-- уже зарегистрированные считаются подтверждёнными, чтобы не заблокировать им вход
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+UPDATE users SET email_verified_at = created_at;
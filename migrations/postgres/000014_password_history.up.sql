@@ -0,0 +1,9 @@
+-- предыдущие хэши паролей, чтобы не давать вернуться к недавнему паролю
+CREATE TABLE password_history (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    password_hash TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_password_history_user_id ON password_history (user_id, created_at DESC);
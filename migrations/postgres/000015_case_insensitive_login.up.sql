@@ -0,0 +1,12 @@
+-- имя пользователя и почта уникальны без учёта регистра: Alice и alice - один пользователь.
+-- Если в базе уже есть такие дубликаты, создание индексов упадёт и их нужно разрешить вручную
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username));
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+
+-- поиск по username теперь идёт через lower(username)
+DROP INDEX IF EXISTS idx_users_username;
+
+-- новые значения сохраняются в нижнем регистре, приводим к нему и старые
+UPDATE users
+SET username = lower(username), email = lower(email)
+WHERE username <> lower(username) OR email <> lower(email);
@@ -0,0 +1,36 @@
+-- журнал аудита действий, важных для безопасности. Записи связаны в цепочку:
+-- hash каждой записи считается от её полей и hash предыдущей, так что изменение или удаление
+-- записи задним числом обнаруживается при проверке цепочки
+CREATE TABLE audit_events (
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ  NOT NULL,
+    actor_id    UUID,                            -- кто совершил действие, NULL - неизвестный клиент
+    action      VARCHAR(50)  NOT NULL,
+    target      VARCHAR(200) NOT NULL DEFAULT '', -- над чем совершено действие: пользователь, сессия, роль
+    ip          VARCHAR(100) NOT NULL DEFAULT '',
+    user_agent  TEXT         NOT NULL DEFAULT '',
+    outcome     VARCHAR(20)  NOT NULL,           -- success, failure или denied
+    request_id  VARCHAR(100) NOT NULL DEFAULT '',
+    details     JSONB        NOT NULL DEFAULT '{}',
+    prev_hash   VARCHAR(64)  NOT NULL,
+    hash        VARCHAR(64)  NOT NULL
+);
+
+CREATE INDEX idx_audit_events_actor_id ON audit_events (actor_id, id);
+CREATE INDEX idx_audit_events_action ON audit_events (action, id);
+CREATE INDEX idx_audit_events_occurred_at ON audit_events (occurred_at);
+
+-- журнал только дополняется
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,45 @@
+-- клиенты OAuth 2.0: у публичных клиентов (SPA, мобильные приложения) секрета нет, хранится только SHA-256 секрета
+CREATE TABLE oauth_clients (
+    id            VARCHAR(100) PRIMARY KEY,
+    name          VARCHAR(100) NOT NULL,
+    secret_hash   VARCHAR(64),
+    redirect_uris TEXT[]       NOT NULL DEFAULT '{}',
+    grant_types   TEXT[]       NOT NULL DEFAULT '{}',
+    scopes        TEXT[]       NOT NULL DEFAULT '{}', -- разрешения, которые клиент может запросить
+    first_party   BOOLEAN      NOT NULL DEFAULT FALSE, -- собственные приложения не спрашивают согласия пользователя
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- коды авторизации живут минуту и используются один раз. session_id - сессия, открытая по коду:
+-- при повторном предъявлении кода она отзывается
+CREATE TABLE oauth_authorization_codes (
+    code_hash             VARCHAR(64) PRIMARY KEY,
+    client_id             VARCHAR(100) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id               UUID         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri          TEXT         NOT NULL,
+    scope                 TEXT         NOT NULL DEFAULT '',
+    code_challenge        VARCHAR(128) NOT NULL,
+    code_challenge_method VARCHAR(10)  NOT NULL,
+    created_at            TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at            TIMESTAMPTZ  NOT NULL,
+    used_at               TIMESTAMPTZ,
+    session_id            UUID
+);
+
+CREATE INDEX idx_oauth_authorization_codes_expires_at ON oauth_authorization_codes (expires_at);
+
+-- согласие пользователя на доступ клиента к разрешениям
+CREATE TABLE oauth_consents (
+    user_id    UUID         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id  VARCHAR(100) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scopes     TEXT[]       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE INDEX idx_oauth_consents_client_id ON oauth_consents (client_id);
+
+-- разрешения, выданные сессии, переходят в access токены при обновлении
+ALTER TABLE sessions ADD COLUMN scope TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+-- OpenID Connect: адреса, на которые клиент может вернуть пользователя после выхода
+ALTER TABLE oauth_clients ADD COLUMN post_logout_redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+-- данные входа, которые попадают в id_token при обмене кода: nonce из запроса авторизации,
+-- время входа и способы аутентификации (amr, RFC 8176)
+ALTER TABLE oauth_authorization_codes ADD COLUMN nonce     TEXT   NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN auth_time TIMESTAMPTZ;
+ALTER TABLE oauth_authorization_codes ADD COLUMN amr       TEXT[] NOT NULL DEFAULT '{}';
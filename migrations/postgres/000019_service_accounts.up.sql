@@ -0,0 +1,14 @@
+-- сервисные аккаунты - клиенты с client_credentials. Вместо секрета клиент может зарегистрировать
+-- публичный ключ (PEM) и подписывать им JWT для аутентификации (private_key_jwt, RFC 7523)
+ALTER TABLE oauth_clients ADD COLUMN public_key TEXT;
+
+-- jti предъявленных подписанных JWT клиентов, чтобы один и тот же JWT нельзя было предъявить дважды.
+-- Запись нужна только до истечения JWT
+CREATE TABLE oauth_client_assertions (
+    client_id  VARCHAR(100) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    jti        TEXT         NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+CREATE INDEX idx_oauth_client_assertions_expires_at ON oauth_client_assertions (expires_at);
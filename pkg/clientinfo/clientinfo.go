@@ -0,0 +1,76 @@
+package clientinfo
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Информация о клиенте запроса: адрес, user agent и идентификатор запроса.
+// Адрес определяет перехватчик (UnaryServerInterceptor или HTTPMiddleware): x-forwarded-for
+// учитывается, только если соединение пришло от доверенного прокси
+
+type Info struct {
+	IP        string
+	UserAgent string
+	RequestID string // из x-request-id, если его передал клиент или балансировщик
+}
+
+type infoKey struct{}
+
+// NewContext сохраняет в контексте информацию о клиенте, которую определил перехватчик
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// FromContext возвращает информацию о клиенте. Если перехватчика не было, адрес берётся
+// из соединения: заголовкам, которые прислал сам клиент, верить нельзя
+func FromContext(ctx context.Context) Info {
+	if info, ok := ctx.Value(infoKey{}).(Info); ok {
+		return info
+	}
+
+	info := fromMetadata(ctx)
+	info.IP = peerIP(ctx)
+	return info
+}
+
+func fromMetadata(ctx context.Context) Info {
+	var info Info
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+		info.UserAgent = userAgent[0]
+	}
+	if requestID := md.Get("x-request-id"); len(requestID) > 0 {
+		info.RequestID = requestID[0]
+	}
+	return info
+}
+
+func peerIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return hostIP(p.Addr.String())
+	}
+	return ""
+}
+
+// hostIP отрезает порт от адреса соединения
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ipOf разбирает адрес, IPv4 в IPv6 приводится к IPv4
+func ipOf(value string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
@@ -0,0 +1,210 @@
+package clientinfo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func mustProxies(t *testing.T, values ...string) TrustedProxies {
+	t.Helper()
+
+	proxies, err := ParseTrustedProxies(values)
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies(%v) error = %v", values, err)
+	}
+	return proxies
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "10.0.0.0/8"},
+		{value: "192.168.1.10"},
+		{value: "::ffff:10.0.0.0/104"},
+		{value: "fd00::/8"},
+		{value: "2001:db8::1"},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "proxy.local", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			_, err := ParseTrustedProxies([]string{tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrustedProxies(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+
+	var proxies TrustedProxies
+	if err := proxies.Decode(" 10.0.0.0/8, 192.168.1.10 ,"); err != nil || len(proxies) != 2 {
+		t.Fatalf("Decode() = %v, %v, want 2 networks", proxies, err)
+	}
+	if err := proxies.Decode(""); err != nil || len(proxies) != 0 {
+		t.Fatalf("Decode(\"\") = %v, %v, want no networks", proxies, err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := mustProxies(t, "10.0.0.0/8", "192.168.1.10", "fd00::/8")
+
+	tests := []struct {
+		name      string
+		proxies   TrustedProxies
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{
+			name:   "direct connection",
+			remote: "203.0.113.7:5123",
+			want:   "203.0.113.7",
+		},
+		{
+			name:      "forwarded from untrusted peer is ignored",
+			remote:    "203.0.113.7:5123",
+			forwarded: []string{"198.51.100.1"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "no trusted proxies configured",
+			proxies:   TrustedProxies{},
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"198.51.100.1"},
+			want:      "10.0.0.2",
+		},
+		{
+			name:      "one trusted proxy",
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"198.51.100.1"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "client cannot prepend a fake address",
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"1.2.3.4, 198.51.100.1"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "chain of trusted proxies",
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"1.2.3.4, 198.51.100.1, 192.168.1.10", "10.1.1.1"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "all hops trusted",
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"10.3.3.3, 10.1.1.1"},
+			want:      "10.3.3.3",
+		},
+		{
+			name:      "garbage stops the walk",
+			remote:    "10.0.0.2:5123",
+			forwarded: []string{"198.51.100.1, not-an-ip, 10.1.1.1"},
+			want:      "10.1.1.1",
+		},
+		{
+			name:      "trusted proxy without header",
+			remote:    "10.0.0.2:5123",
+			forwarded: nil,
+			want:      "10.0.0.2",
+		},
+		{
+			name:      "ipv6 proxy",
+			remote:    "[fd00::1]:5123",
+			forwarded: []string{"2001:db8::7"},
+			want:      "2001:db8::7",
+		},
+		{
+			name:      "ipv4-mapped proxy address",
+			remote:    "[::ffff:10.0.0.2]:5123",
+			forwarded: []string{"::ffff:198.51.100.1"},
+			want:      "198.51.100.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := proxies
+			if tt.proxies != nil {
+				p = tt.proxies
+			}
+			if got := p.ClientIP(tt.remote, tt.forwarded); got != tt.want {
+				t.Fatalf("ClientIP(%q, %q) = %q, want %q", tt.remote, tt.forwarded, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromContextIgnoresForwardedWithoutInterceptor(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-forwarded-for", "1.2.3.4",
+		"user-agent", "test-agent",
+		"x-request-id", "req-1",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 5123}})
+
+	info := FromContext(ctx)
+	if info.IP != "203.0.113.7" || info.UserAgent != "test-agent" || info.RequestID != "req-1" {
+		t.Fatalf("FromContext() = %+v", info)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-forwarded-for", "1.2.3.4, 198.51.100.1",
+		"user-agent", "test-agent",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5123}})
+
+	var got Info
+	_, err := UnaryServerInterceptor(mustProxies(t, "10.0.0.0/8"))(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			got = FromContext(ctx)
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatalf("interceptor error = %v", err)
+	}
+	if got.IP != "198.51.100.1" || got.UserAgent != "test-agent" {
+		t.Fatalf("FromContext() = %+v", got)
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	var got Info
+	handler := HTTPMiddleware(mustProxies(t, "10.0.0.0/8"), http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		remote string
+		want   string
+	}{
+		{name: "trusted proxy", remote: "10.0.0.2:5123", want: "198.51.100.1"},
+		{name: "untrusted peer", remote: "203.0.113.7:5123", want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Add("X-Forwarded-For", "1.2.3.4")
+			r.Header.Add("X-Forwarded-For", "198.51.100.1")
+			r.Header.Set("User-Agent", "test-agent")
+			r.Header.Set("X-Request-Id", "req-1")
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got.IP != tt.want || got.UserAgent != "test-agent" || got.RequestID != "req-1" {
+				t.Fatalf("FromContext() = %+v, want IP %s", got, tt.want)
+			}
+		})
+	}
+}
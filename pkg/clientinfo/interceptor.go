@@ -0,0 +1,33 @@
+package clientinfo
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor определяет адрес клиента gRPC запроса. Должен стоять первым в цепочке,
+// до ограничения частоты запросов
+func UnaryServerInterceptor(proxies TrustedProxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		info := fromMetadata(ctx)
+		info.IP = proxies.ClientIP(peerIP(ctx), md.Get("x-forwarded-for"))
+		return handler(NewContext(ctx, info), req)
+	}
+}
+
+// HTTPMiddleware определяет адрес клиента HTTP запроса
+func HTTPMiddleware(proxies TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := Info{
+			IP:        proxies.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For")),
+			UserAgent: r.UserAgent(),
+			RequestID: r.Header.Get("X-Request-Id"),
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), info)))
+	})
+}
@@ -0,0 +1,95 @@
+package clientinfo
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies - сети балансировщиков и прокси, которые дописывают адрес клиента в x-forwarded-for.
+// Пустой список - сервис принимает соединения напрямую и заголовок не учитывается
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies разбирает сети в нотации CIDR, одиночный адрес - сеть из одного адреса
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, ok := ipOf(value)
+			if !ok {
+				return nil, fmt.Errorf("invalid trusted proxy %q: expected IP or CIDR", value)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Decode разбирает список сетей через запятую, например "10.0.0.0/8,192.168.1.10". Нужен для envconfig
+func (p *TrustedProxies) Decode(value string) error {
+	proxies, err := ParseTrustedProxies(strings.Split(value, ","))
+	if err != nil {
+		return err
+	}
+	*p = proxies
+	return nil
+}
+
+func (p TrustedProxies) Contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP определяет адрес клиента. remote - адрес соединения, forwarded - значения x-forwarded-for.
+// Каждый прокси дописывает адрес, от которого получил запрос, в конец списка, поэтому список
+// проходится справа налево, пока адреса принадлежат доверенным прокси. Первый недоверенный
+// адрес - клиент: всё левее него мог написать сам клиент
+func (p TrustedProxies) ClientIP(remote string, forwarded []string) string {
+	client := hostIP(remote)
+	addr, ok := ipOf(client)
+	if !ok {
+		return client
+	}
+	client = addr.String()
+	if !p.Contains(addr) {
+		return client
+	}
+
+	var hops []string
+	for _, value := range forwarded {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := ipOf(strings.TrimSpace(hops[i]))
+		if !ok {
+			// мусор в заголовке: дальше по цепочке верить нечему
+			return client
+		}
+
+		client = hop.String()
+		if !p.Contains(hop) {
+			return client
+		}
+	}
+	return client
+}
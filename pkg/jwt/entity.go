@@ -1,23 +1,154 @@
 package jwt
 
-import "github.com/google/uuid"
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// тип токена (claim token_use): refresh токены непрозрачные, JWT выпускаются только как access,
+// а старые JWT refresh токены с token_use=refresh не принимаются вместо access
+const (
+	TokenTypeAccess = "access"
+	TokenTypeMfa    = "mfa" // незавершённый вход, обменивается на пару токенов после проверки второго фактора
+
+	// шаги входа на странице авторизации OAuth: пароль проверен, нужен второй фактор / пользователь вошёл
+	TokenTypeOAuthMfa   = "oauth_mfa"
+	TokenTypeOAuthLogin = "oauth_login"
+
+	TokenTypeID = "id" // id_token OpenID Connect, получатель - клиент, вместо access не принимается
+)
+
+// ErrWrongTokenType - токен валиден, но не того типа, который ожидался
+var ErrWrongTokenType = errors.New("wrong token type")
 
 type GetDataFromTokenParams struct { // используются для извлечения данных из JWT-токена после валидации, сам токен
 	Token string
 }
 
-type GetDataFromTokenResponse struct { // результат (ID пользователя, закодированный в токене)
-	UserId uuid.UUID `json:"userId"`
+type GetDataFromTokenResponse struct { // результат (данные, закодированные в токене)
+	UserId        uuid.UUID `json:"sub"`
+	Subject       string    `json:"-"` // sub как есть: у токенов клиента это идентификатор клиента, а UserId пустой
+	SessionId     uuid.UUID `json:"sid"`
+	TokenId       string    `json:"jti"`
+	TokenType     string    `json:"token_use"`
+	ClientId      string    `json:"client_id"`
+	Scope         string    `json:"scope"`
+	Roles         []string  `json:"roles"`
+	Permissions   []string  `json:"permissions"`
+	DeviceName    string    `json:"device_name"` // только у challenge токенов
+	Amr           []string  `json:"amr"`         // у токена входа OAuth и id_token
+	EmailVerified bool      `json:"email_verified"`
+	Issuer        string    `json:"iss"`
+	Audience      []string  `json:"aud"`
+	IssuedAt      time.Time `json:"iat"`
+	ExpiresAt     time.Time `json:"exp"`
+}
+
+// IsClient - токен клиента (сервисного аккаунта), выпущенный без пользователя и сессии
+func (d *GetDataFromTokenResponse) IsClient() bool {
+	return d.UserId == uuid.Nil && d.SessionId == uuid.Nil && d.Subject != "" && d.Subject == d.ClientId
 }
-type CreateTokenParams struct { // генерация новой пары токенов (access + refresh)
-	UserId uuid.UUID `json:"userId"` // входные параметры (ID пользователя)
+
+type CreateTokenParams struct { // генерация новой пары токенов (access JWT + непрозрачный refresh)
+	UserId        uuid.UUID `json:"sub"`            // входные параметры (ID пользователя)
+	SessionId     uuid.UUID `json:"sid"`            // сессия устройства, к которой относятся токены
+	ClientId      string    `json:"client_id"`      // клиент, для которого выпускается токен, определяет aud
+	Scope         string    `json:"scope"`          // разрешения через пробел, необязательно
+	Roles         []string  `json:"roles"`          // роли пользователя, назначенные на все ресурсы
+	Permissions   []string  `json:"permissions"`    // права, которые дают эти роли
+	EmailVerified bool      `json:"email_verified"` // подтверждена ли почта пользователя
+}
+
+// CreateClientTokenParams - access токен клиента без пользователя (OAuth client credentials)
+type CreateClientTokenParams struct {
+	ClientId string // становится sub и определяет aud
+	Scope    string // разрешения через пробел
+}
+
+// CreateIDTokenParams - id_token OpenID Connect (Core 1.0, раздел 2)
+type CreateIDTokenParams struct {
+	UserId    uuid.UUID
+	SessionId uuid.UUID              // sid, по нему клиент просит завершить сессию при выходе
+	ClientId  string                 // получатель токена
+	Nonce     string                 // из запроса авторизации, необязательно
+	AuthTime  time.Time              // когда пользователь вошёл
+	Amr       []string               // способы аутентификации (RFC 8176)
+	Claims    map[string]interface{} // claims профиля и почты, на которые согласился пользователь
+}
+
+// VerifyClientAssertionParams - подписанный клиентом JWT для аутентификации (private_key_jwt, RFC 7523, раздел 3)
+type VerifyClientAssertionParams struct {
+	Assertion string
+	PublicKey string   // PEM ключ, зарегистрированный клиентом
+	ClientId  string   // должен совпадать с iss и sub
+	Audience  []string // допустимые aud: адрес /token и издатель
+}
+
+// ClientAssertion - проверенный JWT клиента: по jti его нельзя предъявить повторно до истечения срока
+type ClientAssertion struct {
+	TokenId   string
+	ExpiresAt time.Time
 }
 
 type CreateTokenResponse struct { // сгенерированные токены
-	AccessToken  string
-	RefreshToken string
+	AccessToken     string
+	RefreshToken    string
+	AccessTokenId   string    // jti access токена, нужен для его отзыва
+	AccessExpiresAt time.Time // время истечения access токена
 }
 
-type ValidateTokenParams struct { // проверяет валидность токена (не истёк ли, корректная ли подпись)
-	Token string
+// CreateChallengeTokenParams - короткоживущий токен промежуточного шага входа.
+// Получатель такого токена - сам сервис (aud = iss), другие сервисы его не примут
+type CreateChallengeTokenParams struct {
+	UserId     uuid.UUID
+	TokenType  string // назначение токена, например TokenTypeMfa
+	ClientId   string // клиент и устройство, для которых будут выпущены токены после завершения входа
+	DeviceName string
+	Amr        []string // способы аутентификации, которыми пользователь уже вошёл
+	ExpiresIn  time.Duration
+}
+
+type CreateChallengeTokenResponse struct {
+	Token     string
+	TokenId   string
+	ExpiresAt time.Time
+}
+
+type ValidateTokenParams struct { // проверяет валидность токена (подпись, срок действия, издатель и получатель)
+	Token     string
+	Audience  string // если пусто - подходит любой из известных получателей
+	TokenType string // ожидаемый тип токена, если пусто - тип не проверяется
+}
+
+// Audiences - получатели (aud) выпускаемых токенов: по умолчанию и отдельно для каждого клиента
+type Audiences struct {
+	Default  []string
+	ByClient map[string][]string
+}
+
+// For возвращает получателей токена для клиента
+func (a Audiences) For(clientId string) []string {
+	if aud, ok := a.ByClient[clientId]; ok {
+		return aud
+	}
+	return a.Default
+}
+
+// Contains проверяет, что получатель известен сервису
+func (a Audiences) Contains(audience string) bool {
+	for _, aud := range a.Default {
+		if aud == audience {
+			return true
+		}
+	}
+	for _, auds := range a.ByClient {
+		for _, aud := range auds {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -1,14 +1,14 @@
 package jwt
 
 import (
+	"crypto/ecdsa"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
+	"newservice/pkg/secure"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
@@ -16,122 +16,438 @@ import (
 
 type JWTClient interface {
 	CreateToken(params *CreateTokenParams) (*CreateTokenResponse, error)
+	CreateChallengeToken(params *CreateChallengeTokenParams) (*CreateChallengeTokenResponse, error)
+	CreateClientToken(params *CreateClientTokenParams) (*CreateTokenResponse, error)
+	CreateIDToken(params *CreateIDTokenParams) (string, error)
+	VerifyClientAssertion(params *VerifyClientAssertionParams) (*ClientAssertion, error)
 	ValidateToken(params *ValidateTokenParams) (bool, error)
 	GetDataFromToken(params *GetDataFromTokenParams) (*GetDataFromTokenResponse, error)
+	JWKS() JWKS
 }
 
 type jwtClient struct {
-	privateKey       *rsa.PrivateKey
-	publicKey        *rsa.PublicKey
-	accessTokenTime  time.Duration
-	refreshTokenTime time.Duration
+	keys            *KeySet
+	accessTokenTime time.Duration
+	issuer          string
+	audiences       Audiences
+	leeway          time.Duration // допустимое расхождение часов при проверке exp, nbf и iat
 }
 
-func NewJWTClient( // функция-конструктор, принимает RSA ключи и длительности токенов, возвращает экземпляр jwtClient
-	privateKey *rsa.PrivateKey,
-	publicKey *rsa.PublicKey,
+func NewJWTClient( // функция-конструктор, принимает набор RSA ключей, время жизни access токена и параметры registered claims
+	keys *KeySet,
 	accessTokenTime time.Duration,
-	refreshTokenTime time.Duration,
+	issuer string,
+	audiences Audiences,
+	leeway time.Duration,
 ) *jwtClient {
 	return &jwtClient{
-		privateKey:       privateKey,
-		publicKey:        publicKey,
-		accessTokenTime:  accessTokenTime,
-		refreshTokenTime: refreshTokenTime,
+		keys:            keys,
+		accessTokenTime: accessTokenTime,
+		issuer:          issuer,
+		audiences:       audiences,
+		leeway:          leeway,
 	}
 }
 
 func (a *jwtClient) CreateToken(params *CreateTokenParams) (*CreateTokenResponse, error) {
 
-	accessToken, err := a.newToken(params, a.accessTokenTime)
+	accessTokenId := uuid.NewString()
+	accessExpiresAt := time.Now().Add(a.accessTokenTime)
+
+	accessToken, err := a.newToken(params, accessTokenId, accessExpiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create access token: %w", err)
 	}
 
-	refreshToken, err := a.newToken(params, a.refreshTokenTime)
+	// refresh токен непрозрачный: его проверяет только этот сервис по хэшу в БД
+	refreshToken, err := secure.NewOpaqueToken()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create refresh token: %w", err)
 	}
 
 	return &CreateTokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:     accessToken,
+		RefreshToken:    refreshToken,
+		AccessTokenId:   accessTokenId,
+		AccessExpiresAt: accessExpiresAt,
 	}, nil
 }
 
-func (a *jwtClient) ValidateToken(params *ValidateTokenParams) (bool, error) {
-	token, err := jwt.Parse(params.Token, func(token *jwt.Token) (interface{}, error) {
-		return a.publicKey, nil
-	})
+// CreateClientToken выпускает access токен клиента, который действует от своего имени, а не от имени пользователя.
+// Refresh токена нет: клиент просто запрашивает новый токен
+func (a *jwtClient) CreateClientToken(params *CreateClientTokenParams) (*CreateTokenResponse, error) {
+	key := a.keys.Current()
+	now := time.Now()
+	tokenId := uuid.NewString()
+	expiresAt := now.Add(a.accessTokenTime)
+
+	claims := jwt.MapClaims{
+		"iss":       a.issuer,
+		"sub":       params.ClientId,
+		"aud":       a.audiences.For(params.ClientId),
+		"exp":       expiresAt.Unix(),
+		"nbf":       now.Unix(),
+		"iat":       now.Unix(),
+		"jti":       tokenId,
+		"token_use": TokenTypeAccess,
+		"client_id": params.ClientId,
+	}
+	if params.Scope != "" {
+		claims["scope"] = params.Scope
+	}
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = "at+jwt"
+	token.Claims = claims
+
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signed string from token: %w", err)
+	}
+
+	return &CreateTokenResponse{
+		AccessToken:     tokenString,
+		AccessTokenId:   tokenId,
+		AccessExpiresAt: expiresAt,
+	}, nil
+}
+
+// CreateIDToken выпускает id_token для клиента OpenID Connect. Живёт столько же, сколько access токен
+func (a *jwtClient) CreateIDToken(params *CreateIDTokenParams) (string, error) {
+	key := a.keys.Current()
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for name, value := range params.Claims {
+		claims[name] = value
+	}
+	claims["iss"] = a.issuer
+	claims["sub"] = params.UserId.String()
+	claims["aud"] = params.ClientId
+	claims["azp"] = params.ClientId
+	claims["exp"] = now.Add(a.accessTokenTime).Unix()
+	claims["iat"] = now.Unix()
+	claims["jti"] = uuid.NewString()
+	claims["token_use"] = TokenTypeID
+	claims["sid"] = params.SessionId.String()
+	claims["auth_time"] = params.AuthTime.Unix()
+	if params.Nonce != "" {
+		claims["nonce"] = params.Nonce
+	}
+	if len(params.Amr) > 0 {
+		claims["amr"] = params.Amr
+	}
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = key.ID
+	token.Claims = claims
 
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create signed string from token: %w", err)
+	}
+	return tokenString, nil
+}
+
+func (a *jwtClient) CreateChallengeToken(params *CreateChallengeTokenParams) (*CreateChallengeTokenResponse, error) {
+	key := a.keys.Current()
+	now := time.Now()
+	tokenId := uuid.NewString()
+	expiresAt := now.Add(params.ExpiresIn)
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = key.ID
+	token.Claims = jwt.MapClaims{
+		"iss":         a.issuer,
+		"sub":         params.UserId.String(),
+		"aud":         a.issuer,
+		"exp":         expiresAt.Unix(),
+		"nbf":         now.Unix(),
+		"iat":         now.Unix(),
+		"jti":         tokenId, // после использования токен попадает в denylist
+		"token_use":   params.TokenType,
+		"client_id":   params.ClientId,
+		"device_name": params.DeviceName,
+	}
+	if len(params.Amr) > 0 {
+		token.Claims.(jwt.MapClaims)["amr"] = params.Amr
+	}
+
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signed string from token: %w", err)
+	}
+
+	return &CreateChallengeTokenResponse{
+		Token:     tokenString,
+		TokenId:   tokenId,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (a *jwtClient) ValidateToken(params *ValidateTokenParams) (bool, error) {
+	claims, err := a.parse(params.Token)
 	if err != nil {
 		return false, err
 	}
 
-	if _, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		expirationTime := token.Claims.(jwt.MapClaims)["exp"].(float64)
-		if int64(expirationTime) > time.Now().Unix() {
-			return true, nil
-		}
+	if err := a.validateClaims(claims, params.Audience); err != nil {
+		return false, err
 	}
-	return false, err
+
+	if params.TokenType != "" && claims["token_use"] != params.TokenType {
+		return false, fmt.Errorf("%w: %s token expected", ErrWrongTokenType, params.TokenType)
+	}
+	return true, nil
 }
 
+// GetDataFromToken проверяет только подпись: срок действия не важен, например, для истёкшего access токена в Refresh
 func (a *jwtClient) GetDataFromToken(params *GetDataFromTokenParams) (*GetDataFromTokenResponse, error) {
-	token, err := jwt.Parse(params.Token, func(token *jwt.Token) (interface{}, error) {
-		return a.publicKey, nil
-	})
+	claims, err := a.parse(params.Token)
 	if err != nil {
-		if err.Error() != "Token is expired" {
-			return nil, err
-		}
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		userIdStr, ok := claims["userId"].(string) // Получаем как строку из токена
-		if !ok {
-			log.Error().Msg("failed to cast userId to string")
-			return nil, fmt.Errorf("invalid token claims")
-		}
+	// sub появился позже, старые токены содержат только userId
+	userIdStr, ok := claims["sub"].(string)
+	if !ok {
+		userIdStr, ok = claims["userId"].(string)
+	}
+	if !ok {
+		log.Error().Msg("failed to cast sub to string")
+		return nil, fmt.Errorf("invalid token claims")
+	}
 
-		// Парсим строку в uuid.UUID
-		userId, err := uuid.Parse(userIdStr)
+	clientId, _ := claims["client_id"].(string)
+
+	// Парсим строку в uuid.UUID. У токенов клиента без пользователя нет сессии, а sub - идентификатор клиента,
+	// который тоже может быть uuid, поэтому UserId у них пустой
+	var userId uuid.UUID
+	if _, hasSession := claims["sid"]; hasSession || userIdStr != clientId {
+		userId, err = uuid.Parse(userIdStr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid userId format in token: %w", err)
 		}
+	}
 
-		return &GetDataFromTokenResponse{
-			UserId: userId, // Возвращаем как uuid.UUID
-		}, nil
+	// токены, выпущенные до появления сессий, sid не содержат
+	var sessionId uuid.UUID
+	if sessionIdStr, ok := claims["sid"].(string); ok {
+		sessionId, err = uuid.Parse(sessionIdStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sid format in token: %w", err)
+		}
 	}
-	return nil, errors.New("invalid signing method")
+
+	tokenId, _ := claims["jti"].(string)
+	tokenType, _ := claims["token_use"].(string)
+	scope, _ := claims["scope"].(string)
+	deviceName, _ := claims["device_name"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+	issuer, _ := claims["iss"].(string)
+
+	return &GetDataFromTokenResponse{
+		UserId:        userId, // Возвращаем как uuid.UUID
+		Subject:       userIdStr,
+		SessionId:     sessionId,
+		TokenId:       tokenId,
+		TokenType:     tokenType,
+		ClientId:      clientId,
+		Scope:         scope,
+		Roles:         stringsClaim(claims, "roles"),
+		Permissions:   stringsClaim(claims, "permissions"),
+		DeviceName:    deviceName,
+		Amr:           stringsClaim(claims, "amr"),
+		EmailVerified: emailVerified,
+		Issuer:        issuer,
+		Audience:      audience(claims),
+		IssuedAt:      timeClaim(claims, "iat"),
+		ExpiresAt:     timeClaim(claims, "exp"),
+	}, nil
 }
 
-func (a *jwtClient) CreateTokenId(params *CreateTokenParams) (string, error) {
+// maxClientAssertionLifetime ограничивает срок JWT клиента, а с ним и время хранения его jti
+const maxClientAssertionLifetime = time.Hour
 
-	privateKey, err := readPrivateKey()
+// VerifyClientAssertion проверяет JWT, которым клиент аутентифицируется вместо секрета: подпись ключом клиента,
+// iss и sub равны client_id, aud - этот сервис, exp обязателен и недалеко в будущем, jti обязателен
+func (a *jwtClient) VerifyClientAssertion(params *VerifyClientAssertionParams) (*ClientAssertion, error) {
+	publicKey, err := ParsePublicKeyPEM([]byte(params.PublicKey))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	accessToken := jwt.New(jwt.SigningMethodRS256)
 
-	claims := accessToken.Claims.(jwt.MapClaims)
-	claims["userId"] = params.UserId
-	accessTokenString, err := accessToken.SignedString(privateKey)
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(params.Assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		// алгоритм должен соответствовать типу ключа, иначе подпись можно подделать (например, HMAC публичным ключом)
+		switch publicKey.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+				return publicKey, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return publicKey, nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid client assertion")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != params.ClientId {
+		return nil, errors.New("client assertion issuer must be the client")
+	}
+	if sub, _ := claims["sub"].(string); sub != params.ClientId {
+		return nil, errors.New("client assertion subject must be the client")
+	}
+
+	now := time.Now()
+	expiresAt := timeClaim(claims, "exp")
+	if expiresAt.IsZero() || expiresAt.Before(now.Add(-a.leeway)) {
+		return nil, errors.New("client assertion is expired")
+	}
+	if expiresAt.After(now.Add(maxClientAssertionLifetime + a.leeway)) {
+		return nil, errors.New("client assertion expires too far in the future")
+	}
+	if !claims.VerifyNotBefore(now.Add(a.leeway).Unix(), false) {
+		return nil, errors.New("client assertion is not valid yet")
+	}
+
+	tokenId, _ := claims["jti"].(string)
+	if tokenId == "" {
+		return nil, errors.New("client assertion must contain jti")
+	}
+
+	for _, aud := range audience(claims) {
+		for _, expected := range params.Audience {
+			if aud == expected {
+				return &ClientAssertion{
+					TokenId:   tokenId,
+					ExpiresAt: expiresAt,
+				}, nil
+			}
+		}
+	}
+	return nil, errors.New("invalid client assertion audience")
+}
+
+// ClientAssertionIssuer достаёт iss из JWT клиента без проверки подписи, чтобы найти ключ клиента,
+// когда client_id не передан отдельно. Подпись и iss проверяет VerifyClientAssertion
+func ClientAssertionIssuer(assertion string) string {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, claims); err != nil {
+		return ""
+	}
+	iss, _ := claims["iss"].(string)
+	return iss
+}
+
+// JWKS отдаёт публичные ключи, которыми можно проверить выпущенные токены
+func (a *jwtClient) JWKS() JWKS {
+	return a.keys.JWKS()
+}
+
+// parse проверяет подпись токена, registered claims проверяются отдельно в validateClaims
+func (a *jwtClient) parse(tokenString string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(tokenString, claims, a.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
 	}
-	return accessTokenString, nil
+	return claims, nil
 }
 
-func (a *jwtClient) newToken(params *CreateTokenParams, lt time.Duration) (string, error) {
+// validateClaims проверяет exp, nbf, iat с учётом leeway, издателя и получателя токена
+func (a *jwtClient) validateClaims(claims jwt.MapClaims, expectedAudience string) error {
+	now := time.Now()
+
+	if !claims.VerifyExpiresAt(now.Add(-a.leeway).Unix(), true) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(a.leeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(a.leeway).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyIssuer(a.issuer, true) {
+		return errors.New("invalid token issuer")
+	}
+
+	for _, aud := range audience(claims) {
+		if expectedAudience != "" && aud == expectedAudience {
+			return nil
+		}
+		if expectedAudience == "" && a.audiences.Contains(aud) {
+			return nil
+		}
+	}
+	return errors.New("invalid token audience")
+}
+
+// keyFunc выбирает ключ проверки подписи по kid из заголовка токена
+func (a *jwtClient) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		// токены, выпущенные до появления kid, подписаны текущим ключом
+		return a.keys.Current().PublicKey, nil
+	}
+
+	publicKey, ok := a.keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	return publicKey, nil
+}
+
+func (a *jwtClient) newToken(params *CreateTokenParams, tokenId string, expiresAt time.Time) (string, error) {
+	key := a.keys.Current()
+	now := time.Now()
+
 	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = "at+jwt" // RFC 9068
 	token.Claims = jwt.MapClaims{
-		"exp":    time.Now().Add(lt).Unix(),
-		"userId": params.UserId.String(),
+		"iss":            a.issuer,
+		"sub":            params.UserId.String(),
+		"aud":            a.audiences.For(params.ClientId),
+		"exp":            expiresAt.Unix(),
+		"nbf":            now.Unix(),
+		"iat":            now.Unix(),
+		"jti":            tokenId, // по jti токен можно отозвать до истечения срока
+		"token_use":      TokenTypeAccess,
+		"sid":            params.SessionId.String(),
+		"client_id":      params.ClientId,
+		"email_verified": params.EmailVerified,
+		"userId":         params.UserId.String(), // дублирует sub для сервисов, которые читают старый claim
+	}
+	if params.Scope != "" {
+		token.Claims.(jwt.MapClaims)["scope"] = params.Scope
+	}
+	if len(params.Roles) > 0 {
+		token.Claims.(jwt.MapClaims)["roles"] = params.Roles
+	}
+	if len(params.Permissions) > 0 {
+		token.Claims.(jwt.MapClaims)["permissions"] = params.Permissions
 	}
 
-	tokenString, err := token.SignedString(a.privateKey)
+	tokenString, err := token.SignedString(key.PrivateKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to create signed string from token: %w", err)
 	}
@@ -139,30 +455,33 @@ func (a *jwtClient) newToken(params *CreateTokenParams, lt time.Duration) (strin
 	return tokenString, nil
 }
 
-func readPrivateKey() (*rsa.PrivateKey, error) {
-	privateKeyBytes, err := os.ReadFile("private.pem")
-	if err != nil {
-		return nil, err
+// audience читает aud, который по RFC 7519 может быть строкой или массивом строк
+func audience(claims jwt.MapClaims) []string {
+	if aud, ok := claims["aud"].(string); ok {
+		return []string{aud}
 	}
+	return stringsClaim(claims, "aud")
+}
 
-	block, _ := pem.Decode(privateKeyBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing the private key")
+// stringsClaim читает claim-массив строк
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	values, ok := claims[name].([]interface{})
+	if !ok {
+		return nil
 	}
 
-	// пробуем распарсить сначала как PKCS1
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err == nil {
-		// Если получилось, возвращаем privateKey
-		return privateKey, nil
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
 	}
+	return strs
+}
 
-	// если не получилось, пробуем как PKCS8
-	privateKeyInterface, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %v", err)
+func timeClaim(claims jwt.MapClaims, name string) time.Time {
+	if v, ok := claims[name].(float64); ok {
+		return time.Unix(int64(v), 0)
 	}
-
-	// преобразуем и возвращаем как *rsa.PrivateKey
-	return privateKeyInterface.(*rsa.PrivateKey), nil
+	return time.Time{}
 }
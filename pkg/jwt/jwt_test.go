@@ -0,0 +1,289 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// newTestKey - ключ, активный с момента activateAt
+func newTestKey(t *testing.T, activateAt time.Time) Key {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return NewKey(privateKey, activateAt)
+}
+
+// newTestKeySet - набор из заданных ключей с хранением предыдущих сутки
+func newTestKeySet(t *testing.T, keys ...Key) *KeySet {
+	t.Helper()
+
+	set, err := NewKeySet(context.Background(), KeySourceFunc(func(context.Context) ([]Key, error) {
+		return keys, nil
+	}), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	return set
+}
+
+// newTestClient - клиент с издателем auth-service, получателем api и leeway в полминуты
+func newTestClient(t *testing.T, keys ...Key) *jwtClient {
+	t.Helper()
+
+	return NewJWTClient(newTestKeySet(t, keys...), time.Minute, "auth-service",
+		Audiences{Default: []string{"api"}, ByClient: map[string][]string{"mobile": {"mobile-api"}}}, 30*time.Second)
+}
+
+// signTestToken подписывает claims ключом key; пустой kid - токен без kid в заголовке
+func signTestToken(t *testing.T, key Key, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestKeyLookup(t *testing.T) {
+	now := time.Now()
+	current := newTestKey(t, now.Add(-time.Hour))
+	previous := newTestKey(t, now.Add(-2*time.Hour))
+	unknown := newTestKey(t, time.Time{})
+	client := newTestClient(t, current, previous)
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "auth-service",
+			"aud": "api",
+			"sub": uuid.NewString(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "current key", token: signTestToken(t, current, current.ID, claims()), want: true},
+		{name: "previous key", token: signTestToken(t, previous, previous.ID, claims()), want: true},
+		{name: "no kid signed by current key", token: signTestToken(t, current, "", claims()), want: true},
+		{name: "no kid signed by previous key", token: signTestToken(t, previous, "", claims()), want: false},
+		{name: "unknown kid", token: signTestToken(t, unknown, unknown.ID, claims()), want: false},
+		{name: "kid of another key", token: signTestToken(t, unknown, current.ID, claims()), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := client.ValidateToken(&ValidateTokenParams{Token: tt.token})
+			if got != tt.want {
+				t.Fatalf("ValidateToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTokenKid(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+	client := newTestClient(t, key)
+
+	tokens, err := client.CreateToken(&CreateTokenParams{UserId: uuid.New()})
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens.AccessToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	if token.Header["kid"] != key.ID {
+		t.Fatalf("kid = %v, want %s", token.Header["kid"], key.ID)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	current := newTestKey(t, now.Add(-time.Hour))
+	previous := newTestKey(t, now.Add(-2*time.Hour))
+	set := newTestKeySet(t, current, previous)
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() keys = %d, want 2", len(jwks.Keys))
+	}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || k.Alg != "RS256" || k.Use != "sig" {
+			t.Fatalf("JWKS() key = %+v", k)
+		}
+		if k.Kid != current.ID && k.Kid != previous.ID {
+			t.Fatalf("JWKS() unexpected kid %s", k.Kid)
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+
+	// kid не зависит от того, как получен ключ, и различается у разных ключей
+	if Thumbprint(key.PublicKey) != key.ID {
+		t.Fatal("Thumbprint() differs from the key id")
+	}
+	if other := newTestKey(t, time.Time{}); other.ID == key.ID {
+		t.Fatal("different keys have the same thumbprint")
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+	client := newTestClient(t, key)
+	now := time.Now()
+
+	// claims - корректный набор registered claims с заменой отдельных значений; nil удаляет claim
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss": "auth-service",
+			"sub": uuid.NewString(),
+			"aud": []string{"api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Unix(),
+			"iat": now.Unix(),
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+				continue
+			}
+			c[name] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		audience string
+		want     bool
+	}{
+		{name: "valid", claims: claims(nil), want: true},
+		{name: "wrong issuer", claims: claims(jwt.MapClaims{"iss": "other-service"}), want: false},
+		{name: "missing issuer", claims: claims(jwt.MapClaims{"iss": nil}), want: false},
+		{name: "audience as string", claims: claims(jwt.MapClaims{"aud": "api"}), want: true},
+		{name: "client audience", claims: claims(jwt.MapClaims{"aud": []string{"mobile-api"}}), want: true},
+		{name: "one of several audiences", claims: claims(jwt.MapClaims{"aud": []string{"billing", "api"}}), want: true},
+		{name: "unknown audience", claims: claims(jwt.MapClaims{"aud": []string{"billing"}}), want: false},
+		{name: "missing audience", claims: claims(jwt.MapClaims{"aud": nil}), want: false},
+		{name: "expected audience", claims: claims(nil), audience: "api", want: true},
+		{name: "another expected audience", claims: claims(nil), audience: "mobile-api", want: false},
+		{name: "expired", claims: claims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), want: false},
+		{name: "expired within leeway", claims: claims(jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}), want: true},
+		{name: "missing exp", claims: claims(jwt.MapClaims{"exp": nil}), want: false},
+		{name: "not valid yet", claims: claims(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}), want: false},
+		{name: "not valid yet within leeway", claims: claims(jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()}), want: true},
+		{name: "missing nbf", claims: claims(jwt.MapClaims{"nbf": nil}), want: true},
+		{name: "issued in the future", claims: claims(jwt.MapClaims{"iat": now.Add(time.Minute).Unix()}), want: false},
+		{name: "issued in the future within leeway", claims: claims(jwt.MapClaims{"iat": now.Add(10 * time.Second).Unix()}), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := client.ValidateToken(&ValidateTokenParams{
+				Token:    signTestToken(t, key, key.ID, tt.claims),
+				Audience: tt.audience,
+			})
+			if got != tt.want {
+				t.Fatalf("ValidateToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTokenClaims(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+	client := newTestClient(t, key)
+	userID, sessionID := uuid.New(), uuid.New()
+
+	tokens, err := client.CreateToken(&CreateTokenParams{UserId: userID, SessionId: sessionID, ClientId: "mobile"})
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	if ok, err := client.ValidateToken(&ValidateTokenParams{Token: tokens.AccessToken, Audience: "mobile-api"}); !ok {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	data, err := client.GetDataFromToken(&GetDataFromTokenParams{Token: tokens.AccessToken})
+	if err != nil {
+		t.Fatalf("GetDataFromToken() error = %v", err)
+	}
+	if data.UserId != userID || data.SessionId != sessionID || data.ClientId != "mobile" {
+		t.Fatalf("GetDataFromToken() = %+v", data)
+	}
+	if data.Issuer != "auth-service" || len(data.Audience) != 1 || data.Audience[0] != "mobile-api" {
+		t.Fatalf("GetDataFromToken() iss = %s, aud = %v", data.Issuer, data.Audience)
+	}
+	if data.TokenId != tokens.AccessTokenId || data.ExpiresAt.Unix() != tokens.AccessExpiresAt.Unix() {
+		t.Fatal("GetDataFromToken() jti or exp differ from the issued token")
+	}
+}
+
+func TestValidateTokenType(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+	client := newTestClient(t, key)
+
+	tokens, err := client.CreateToken(&CreateTokenParams{UserId: uuid.New(), SessionId: uuid.New()})
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	// signed - валидный по registered claims токен с заданным token_use; nil - без claim
+	signed := func(tokenUse interface{}) string {
+		claims := jwt.MapClaims{
+			"iss": "auth-service",
+			"aud": "api",
+			"sub": uuid.NewString(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		if tokenUse != nil {
+			claims["token_use"] = tokenUse
+		}
+		return signTestToken(t, key, key.ID, claims)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		tokenType string
+		want      bool
+	}{
+		{name: "access token", token: tokens.AccessToken, tokenType: TokenTypeAccess, want: true},
+		{name: "JWT refresh token as access", token: signed("refresh"), tokenType: TokenTypeAccess, want: false},
+		{name: "without token_use", token: signed(nil), tokenType: TokenTypeAccess, want: false},
+		{name: "type not checked", token: signed("refresh"), tokenType: "", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.ValidateToken(&ValidateTokenParams{Token: tt.token, TokenType: tt.tokenType})
+			if got != tt.want {
+				t.Fatalf("ValidateToken() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && !errors.Is(err, ErrWrongTokenType) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, ErrWrongTokenType)
+			}
+		})
+	}
+
+	// refresh токен непрозрачный и не проходит как JWT
+	if ok, _ := client.ValidateToken(&ValidateTokenParams{Token: tokens.RefreshToken}); ok {
+		t.Fatal("ValidateToken() accepted an opaque refresh token")
+	}
+}
@@ -0,0 +1,211 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key - ключевая пара с идентификатором (kid), который попадает в заголовок токена.
+// ActivateAt - момент, с которого ключ становится ключом подписи; до этого он только публикуется в JWKS,
+// чтобы проверяющие сервисы успели его закэшировать
+type Key struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+	ActivateAt time.Time
+}
+
+// NewKey создаёт ключ из приватного RSA ключа, kid вычисляется как JWK thumbprint (RFC 7638)
+func NewKey(privateKey *rsa.PrivateKey, activateAt time.Time) Key {
+	return Key{
+		ID:         Thumbprint(&privateKey.PublicKey),
+		PrivateKey: privateKey,
+		PublicKey:  &privateKey.PublicKey,
+		ActivateAt: activateAt,
+	}
+}
+
+// KeySet - набор ключей JWT-клиента с ротацией:
+//   - текущий ключ подписи - самый поздний из уже активированных;
+//   - ключи с ActivateAt в будущем публикуются заранее и становятся текущими по расписанию;
+//   - предыдущие ключи остаются ключами проверки ещё retention после активации следующего ключа,
+//     чтобы не инвалидировать уже выданные токены, после чего выводятся из набора
+type KeySet struct {
+	mu        sync.RWMutex
+	source    KeySource
+	retention time.Duration
+	keys      []Key // отсортированы по ActivateAt
+}
+
+// NewKeySet создаёт набор ключей, загружаемых из source. retention должен быть не меньше
+// максимального времени жизни выдаваемых токенов
+func NewKeySet(ctx context.Context, source KeySource, retention time.Duration) (*KeySet, error) {
+	s := &KeySet{
+		source:    source,
+		retention: retention,
+	}
+
+	if err := s.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload перечитывает ключи из источника. При ошибке продолжает работать старый набор
+func (s *KeySet) Reload(ctx context.Context) error {
+	keys, err := s.source.LoadKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load keys: %w", err)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].ActivateAt.Before(keys[j].ActivateAt)
+	})
+
+	if _, ok := currentIndex(keys, time.Now()); !ok {
+		return errors.New("no active signing key")
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Watch перечитывает ключи каждые interval и по сигналу из reload, пока не отменён ctx.
+// Нулевой или отрицательный interval выключает таймер: ключи перечитываются только по сигналу
+func (s *KeySet) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal, onError func(error)) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-reload:
+		}
+
+		if err := s.Reload(ctx); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// Current возвращает ключ, которым подписываются новые токены
+func (s *KeySet) Current() Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, _ := currentIndex(s.keys, time.Now())
+	return s.keys[i]
+}
+
+// Lookup ищет публичный ключ проверки по kid
+func (s *KeySet) Lookup(kid string) (*rsa.PublicKey, bool) {
+	for _, k := range s.verificationKeys() {
+		if k.ID == kid {
+			return k.PublicKey, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS возвращает публичные ключи проверки в формате JSON Web Key Set (RFC 7517)
+func (s *KeySet) JWKS() JWKS {
+	keys := s.verificationKeys()
+
+	set := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, NewJWK(k.ID, k.PublicKey))
+	}
+	return set
+}
+
+// verificationKeys - текущий ключ, запланированные ключи и ещё не выведенные предыдущие
+func (s *KeySet) verificationKeys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	current, _ := currentIndex(s.keys, now)
+
+	keys := make([]Key, 0, len(s.keys))
+	for i, k := range s.keys {
+		if i < current && s.keys[i+1].ActivateAt.Add(s.retention).Before(now) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// currentIndex ищет последний активированный ключ с приватной частью
+func currentIndex(keys []Key, now time.Time) (int, bool) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].PrivateKey != nil && !keys[i].ActivateAt.After(now) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// JWK - публичный RSA ключ в формате JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS - документ со списком ключей, отдаётся по /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(kid string, publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// Thumbprint вычисляет JWK thumbprint публичного ключа по RFC 7638
+func Thumbprint(publicKey *rsa.PublicKey) string {
+	jwk := NewJWK("", publicKey)
+
+	// RFC 7638 требует только обязательные поля в лексикографическом порядке
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   jwk.E,
+		Kty: jwk.Kty,
+		N:   jwk.N,
+	})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCurrentIndex(t *testing.T) {
+	now := time.Now()
+	signing := &rsa.PrivateKey{} // для выбора ключа важно только наличие приватной части
+
+	key := func(activateAt time.Duration, privateKey *rsa.PrivateKey) Key {
+		return Key{PrivateKey: privateKey, ActivateAt: now.Add(activateAt)}
+	}
+
+	tests := []struct {
+		name   string
+		keys   []Key
+		want   int
+		wantOK bool
+	}{
+		{name: "empty", keys: nil, wantOK: false},
+		{name: "single active", keys: []Key{key(-time.Hour, signing)}, want: 0, wantOK: true},
+		{name: "activated right now", keys: []Key{key(-time.Hour, signing), key(0, signing)}, want: 1, wantOK: true},
+		{name: "latest activated", keys: []Key{key(-2*time.Hour, signing), key(-time.Hour, signing)}, want: 1, wantOK: true},
+		{name: "scheduled key is not current yet", keys: []Key{key(-time.Hour, signing), key(time.Hour, signing)}, want: 0, wantOK: true},
+		{name: "key without private part", keys: []Key{key(-time.Hour, signing), key(-time.Minute, nil)}, want: 0, wantOK: true},
+		{name: "only scheduled keys", keys: []Key{key(time.Hour, signing)}, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := currentIndex(tt.keys, now)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Fatalf("currentIndex() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKeySetRetention(t *testing.T) {
+	now := time.Now()
+	retired := newTestKey(t, now.Add(-72*time.Hour))
+	previous := newTestKey(t, now.Add(-30*time.Hour))
+	current := newTestKey(t, now.Add(-time.Hour))
+	scheduled := newTestKey(t, now.Add(time.Hour))
+
+	// retired сменён previous больше суток назад, previous сменён current час назад
+	set := newTestKeySet(t, current, scheduled, retired, previous)
+
+	if got := set.Current().ID; got != current.ID {
+		t.Fatalf("Current() = %s, want the latest activated key", got)
+	}
+
+	tests := []struct {
+		name string
+		key  Key
+		want bool
+	}{
+		{name: "retired", key: retired, want: false},
+		{name: "previous", key: previous, want: true},
+		{name: "current", key: current, want: true},
+		{name: "scheduled", key: scheduled, want: true},
+	}
+	published := map[string]bool{}
+	for _, k := range set.JWKS().Keys {
+		published[k.Kid] = true
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := set.Lookup(tt.key.ID); ok != tt.want {
+				t.Fatalf("Lookup() = %v, want %v", ok, tt.want)
+			}
+			if published[tt.key.ID] != tt.want {
+				t.Fatalf("published in JWKS = %v, want %v", published[tt.key.ID], tt.want)
+			}
+		})
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	now := time.Now()
+	first := newTestKey(t, now.Add(-time.Hour))
+	second := newTestKey(t, now.Add(-time.Minute))
+
+	var (
+		keys    = []Key{first}
+		loadErr error
+	)
+	set, err := NewKeySet(context.Background(), KeySourceFunc(func(context.Context) ([]Key, error) {
+		return keys, loadErr
+	}), time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	keys = []Key{first, second}
+	if err := set.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if set.Current().ID != second.ID {
+		t.Fatal("Reload() did not switch to the new key")
+	}
+
+	// неудачная загрузка и набор без активного ключа оставляют прежние ключи
+	loadErr = errors.New("source is unavailable")
+	if err := set.Reload(context.Background()); err == nil {
+		t.Fatal("Reload() with a failing source error = nil")
+	}
+	loadErr = nil
+	keys = []Key{newTestKey(t, now.Add(time.Hour))}
+	if err := set.Reload(context.Background()); err == nil {
+		t.Fatal("Reload() without an active key error = nil")
+	}
+	if set.Current().ID != second.ID {
+		t.Fatal("failed Reload() replaced the key set")
+	}
+}
+
+func TestNewKeySetWithoutActiveKey(t *testing.T) {
+	scheduled := newTestKey(t, time.Now().Add(time.Hour))
+	_, err := NewKeySet(context.Background(), KeySourceFunc(func(context.Context) ([]Key, error) {
+		return []Key{scheduled}, nil
+	}), time.Hour)
+	if err == nil {
+		t.Fatal("NewKeySet() without an active key error = nil")
+	}
+}
+
+// waitLoads ждёт, пока источник ключей прочитают want раз
+func waitLoads(t *testing.T, loads *atomic.Int32, want int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for loads.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("keys loaded %d times, want %d", loads.Load(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	key := newTestKey(t, time.Time{})
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// reloadsByTimer - ключи перечитываются без сигнала
+		reloadsByTimer bool
+	}{
+		{name: "zero interval", interval: 0, reloadsByTimer: false},
+		{name: "negative interval", interval: -time.Second, reloadsByTimer: false},
+		{name: "positive interval", interval: 5 * time.Millisecond, reloadsByTimer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loads atomic.Int32
+			set, err := NewKeySet(context.Background(), KeySourceFunc(func(context.Context) ([]Key, error) {
+				loads.Add(1)
+				return []Key{key}, nil
+			}), time.Hour)
+			if err != nil {
+				t.Fatalf("NewKeySet() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			reload := make(chan os.Signal, 1)
+			done := make(chan struct{})
+			go func() {
+				set.Watch(ctx, tt.interval, reload, func(err error) { t.Errorf("reload error = %v", err) })
+				close(done)
+			}()
+
+			if tt.reloadsByTimer {
+				waitLoads(t, &loads, 3)
+			} else {
+				time.Sleep(20 * time.Millisecond)
+				if got := loads.Load(); got != 1 {
+					t.Fatalf("keys loaded %d times without a signal, want 1", got)
+				}
+				reload <- os.Interrupt
+				waitLoads(t, &loads, 2)
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Watch() did not stop after the context was canceled")
+			}
+		})
+	}
+}
+
+func writeKeyFile(t *testing.T, dir, name string) Key {
+	t.Helper()
+
+	key := newTestKey(t, time.Time{})
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.PrivateKey)})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	return key
+}
+
+func TestDirKeySource(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKeyFile(t, dir, "20260101T000000Z.pem")
+	second := writeKeyFile(t, dir, "20260201T120000Z.pem")
+
+	keys, err := NewDirKeySource(dir).LoadKeys(context.Background())
+	if err != nil {
+		t.Fatalf("LoadKeys() error = %v", err)
+	}
+	want := map[string]time.Time{
+		first.ID:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		second.ID: time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("LoadKeys() keys = %d, want %d", len(keys), len(want))
+	}
+	for _, k := range keys {
+		if !k.ActivateAt.Equal(want[k.ID]) {
+			t.Fatalf("key %s activates at %v, want %v", k.ID, k.ActivateAt, want[k.ID])
+		}
+	}
+
+	// без времени активации в имени скопированный ключ стал бы ключом подписи раньше, чем его опубликуют реплики
+	writeKeyFile(t, dir, "new-key.pem")
+	if _, err := NewDirKeySource(dir).LoadKeys(context.Background()); err == nil {
+		t.Fatal("LoadKeys() with a key file without activation time error = nil")
+	}
+}
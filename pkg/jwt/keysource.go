@@ -0,0 +1,90 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KeySource - источник ключей подписи (файл, каталог, база данных)
+type KeySource interface {
+	LoadKeys(ctx context.Context) ([]Key, error)
+}
+
+// KeySourceFunc позволяет использовать обычную функцию как KeySource
+type KeySourceFunc func(ctx context.Context) ([]Key, error)
+
+func (f KeySourceFunc) LoadKeys(ctx context.Context) ([]Key, error) {
+	return f(ctx)
+}
+
+// activateAtLayout - формат имени файла ключа в каталоге: 20261017T000000Z.pem
+const activateAtLayout = "20060102T150405Z"
+
+type fileKeySource struct {
+	path string
+}
+
+// NewFileKeySource - один ключ из PEM файла, активный сразу (прежнее поведение с private.pem)
+func NewFileKeySource(path string) KeySource {
+	return &fileKeySource{path: path}
+}
+
+func (s *fileKeySource) LoadKeys(_ context.Context) ([]Key, error) {
+	privateKeyBytes, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %v", err)
+	}
+
+	privateKey, err := ParsePrivateKeyPEM(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Key{NewKey(privateKey, time.Time{})}, nil
+}
+
+type dirKeySource struct {
+	dir string
+}
+
+// NewDirKeySource - все *.pem файлы каталога. Время активации берётся из имени файла в формате activateAtLayout.
+// Время изменения файла не подходит: скопированный ключ сразу стал бы ключом подписи, а другие реплики
+// ещё не опубликовали бы его в JWKS. Для ротации без простоя новый ключ кладётся в каталог с временем
+// активации не раньше, чем через период перечитывания ключей
+func NewDirKeySource(dir string) KeySource {
+	return &dirKeySource{dir: dir}
+}
+
+func (s *dirKeySource) LoadKeys(_ context.Context) ([]Key, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(paths))
+	for _, path := range paths {
+		privateKeyBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+		}
+
+		privateKey, err := ParsePrivateKeyPEM(privateKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: %w", path, err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		activateAt, err := time.Parse(activateAtLayout, name)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: name must be the activation time in format %s", path, activateAtLayout)
+		}
+
+		keys = append(keys, NewKey(privateKey, activateAt))
+	}
+
+	return keys, nil
+}
@@ -1,19 +1,15 @@
 package jwt
 
 import (
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"os"
 )
 
-func ReadPrivateKey() (*rsa.PrivateKey, error) {
-	privateKeyBytes, err := os.ReadFile("private.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %v", err)
-	}
-
+// ParsePrivateKeyPEM разбирает RSA ключ в формате PKCS1 или PKCS8
+func ParsePrivateKeyPEM(privateKeyBytes []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privateKeyBytes)
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode PEM block containing the private key")
@@ -25,28 +21,36 @@ func ReadPrivateKey() (*rsa.PrivateKey, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse private key: %v", err)
 		}
-		privateKey = privateKeyInterface.(*rsa.PrivateKey)
+
+		var ok bool
+		privateKey, ok = privateKeyInterface.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key is not an RSA key")
+		}
 	}
 
 	return privateKey, nil
 }
 
-func ReadPublicKey() (*rsa.PublicKey, error) {
-	publicKeyBytes, err := os.ReadFile("public.pem")
-	if err != nil {
-		return nil, err
+// ParsePublicKeyPEM разбирает публичный RSA или ECDSA ключ клиента в формате PKIX или PKCS1 (только RSA)
+func ParsePublicKeyPEM(publicKeyBytes []byte) (interface{}, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing the public key")
 	}
 
-	block, _ := pem.Decode(publicKeyBytes)
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return publicKey, nil
 	}
 
-	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse public key")
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
 	}
 
-	return publicKey, nil
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("public key is neither RSA nor ECDSA")
 }
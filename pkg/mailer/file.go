@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fileMailer складывает письма в каталог вместо отправки, для локального запуска
+type fileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create mail dir: %w", err)
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (m *fileMailer) Send(_ context.Context, msg Message) error {
+	name := time.Now().UTC().Format("20060102T150405Z") + "-" + uuid.NewString() + ".eml"
+
+	var b strings.Builder
+	b.WriteString("To: " + msg.To + "\n")
+	b.WriteString("Subject: " + msg.Subject + "\n")
+	b.WriteString("\n")
+	b.WriteString(msg.Body)
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o640); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package mailer
+
+import (
+	"context"
+)
+
+// Message - текстовое письмо
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer отправляет письма пользователям (сброс пароля, подтверждение почты и т.п.)
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrQueueFull - очередь писем переполнена, письмо не принято
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue отправляет письма в фоне: Send только ставит письмо в очередь и не ждёт SMTP.
+// Ошибки доставки попадают в лог
+type Queue struct {
+	next     Mailer
+	messages chan Message
+	log      *zap.SugaredLogger
+	wg       sync.WaitGroup
+}
+
+func NewQueue(next Mailer, size int, log *zap.SugaredLogger) *Queue {
+	q := &Queue{
+		next:     next,
+		messages: make(chan Message, size),
+		log:      log,
+	}
+
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+
+	for msg := range q.messages {
+		// запрос, который поставил письмо, к этому времени уже завершён
+		if err := q.next.Send(context.Background(), msg); err != nil {
+			q.log.Errorf("send mail err: subject = %q: %v", msg.Subject, err)
+		}
+	}
+}
+
+func (q *Queue) Send(_ context.Context, msg Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close дожидается отправки писем, которые уже в очереди. После Close вызывать Send нельзя
+func (q *Queue) Close() {
+	close(q.messages)
+	q.wg.Wait()
+}
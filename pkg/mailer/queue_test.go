@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// blockingMailer ждёт сигнала перед каждой отправкой, как медленный SMTP-сервер
+type blockingMailer struct {
+	release chan struct{}
+	sent    *MemoryMailer
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg Message) error {
+	<-m.release
+	return m.sent.Send(ctx, msg)
+}
+
+func TestQueue(t *testing.T) {
+	slow := &blockingMailer{release: make(chan struct{}), sent: NewMemoryMailer()}
+	q := NewQueue(slow, 2, zap.NewNop().Sugar())
+
+	// Send не ждёт доставки
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Send(context.Background(), Message{To: "alice@example.com", Subject: "first"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send() blocked on delivery")
+	}
+
+	// первое письмо уже у отправителя, ещё два помещаются в очередь, четвёртое - нет
+	for deadline := time.Now().Add(time.Second); len(q.messages) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("queue worker did not pick up the message")
+		}
+	}
+	for _, subject := range []string{"second", "third"} {
+		if err := q.Send(context.Background(), Message{To: "alice@example.com", Subject: subject}); err != nil {
+			t.Fatalf("Send(%s) error = %v", subject, err)
+		}
+	}
+	if err := q.Send(context.Background(), Message{To: "alice@example.com", Subject: "fourth"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Send() on full queue error = %v, want ErrQueueFull", err)
+	}
+
+	// Close доставляет всё, что успели поставить
+	close(slow.release)
+	q.Close()
+	messages := slow.sent.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("delivered %d messages, want 3", len(messages))
+	}
+	for i, subject := range []string{"first", "second", "third"} {
+		if messages[i].Subject != subject {
+			t.Fatalf("message %d subject = %q, want %q", i, messages[i].Subject, subject)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"newservice/pkg/clientinfo"
+
+	"go.uber.org/zap"
+)
+
+// HTTPMiddleware ограничивает частоту HTTP запросов теми же правилами и корзинами, что и gRPC:
+// methods сопоставляет "МЕТОД /путь" с полным именем метода gRPC, который за ним стоит, поэтому
+// вызов метода по HTTP и по gRPC тратит одни и те же токены. Запросы на остальные пути
+// делят одну корзину с правилом Default. Имя пользователя берётся из поля формы identifier.
+// Адрес клиента должен определить clientinfo.HTTPMiddleware
+func HTTPMiddleware(limiter Limiter, rules Rules, methods map[string]string, log *zap.SugaredLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := methods[r.Method+" "+r.URL.Path]
+		if !ok {
+			method = "http"
+		}
+
+		var username string
+		if r.Method == http.MethodPost && rules.For(method).PerUser.Enabled() {
+			username = normalizeUsername(r.PostFormValue("identifier"))
+		}
+
+		ip := clientinfo.FromContext(r.Context()).IP
+		if allowed, retryAfter := check(r.Context(), limiter, rules, method, ip, username, log); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, ErrRateLimited, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
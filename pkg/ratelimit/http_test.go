@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"newservice/pkg/clientinfo"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testLoginMethod = "/auth.AuthService/Login"
+
+type loginRequest struct {
+	identifier string
+}
+
+func (r loginRequest) GetIdentifier() string {
+	return r.identifier
+}
+
+func newTestHTTPHandler(limiter Limiter, rules Rules) http.Handler {
+	methods := map[string]string{"POST /login": testLoginMethod}
+	handler := HTTPMiddleware(limiter, rules, methods, zap.NewNop().Sugar(), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return clientinfo.HTTPMiddleware(nil, handler)
+}
+
+func postLogin(handler http.Handler, remote, identifier string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"identifier": {identifier}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remote
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	rules := Rules{
+		ByMethod: map[string]Rule{
+			testLoginMethod: {PerIP: Limit{Rate: 1, Burst: 2}, PerUser: Limit{Rate: 1, Burst: 3}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		// запросы (адрес, имя) до проверяемого
+		before []string
+		remote string
+		user   string
+		want   int
+	}{
+		{name: "first request", remote: "203.0.113.1:1", user: "alice", want: http.StatusNoContent},
+		{name: "per ip", before: []string{"203.0.113.1:1|alice", "203.0.113.1:1|bob"}, remote: "203.0.113.1:1", user: "carol", want: http.StatusTooManyRequests},
+		{name: "other ip", before: []string{"203.0.113.1:1|alice", "203.0.113.1:1|bob"}, remote: "203.0.113.2:1", user: "carol", want: http.StatusNoContent},
+		{name: "per user any case", before: []string{"203.0.113.1:1|alice", "203.0.113.2:1|ALICE", "203.0.113.3:1| Alice"}, remote: "203.0.113.4:1", user: "alice", want: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestHTTPHandler(NewMemoryLimiter(), rules)
+			for _, req := range tt.before {
+				remote, user, _ := strings.Cut(req, "|")
+				postLogin(handler, remote, user)
+			}
+
+			w := postLogin(handler, tt.remote, tt.user)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+				t.Fatalf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestHTTPMiddlewareSharesBucketsWithGRPC(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	rules := Rules{
+		ByMethod: map[string]Rule{
+			testLoginMethod: {PerUser: Limit{Rate: 1, Burst: 2}},
+		},
+	}
+	handler := newTestHTTPHandler(limiter, rules)
+	interceptor := UnaryServerInterceptor(limiter, rules, zap.NewNop().Sugar())
+	info := &grpc.UnaryServerInfo{FullMethod: testLoginMethod}
+	ok := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+
+	// два входа через gRPC исчерпывают лимит и для формы входа
+	for i := 0; i < 2; i++ {
+		if _, err := interceptor(context.Background(), loginRequest{identifier: "alice"}, info, ok); err != nil {
+			t.Fatalf("gRPC request %d error = %v", i+1, err)
+		}
+	}
+	if w := postLogin(handler, "203.0.113.1:1", "alice"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("HTTP status = %d, want 429", w.Code)
+	}
+
+	_, err := interceptor(context.Background(), loginRequest{identifier: "alice"}, info, ok)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("gRPC error = %v, want ResourceExhausted", err)
+	}
+	if delay := retryDelay(err); delay <= 0 || delay > time.Second {
+		t.Fatalf("RetryInfo delay = %v, want (0, 1s]", delay)
+	}
+}
+
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"newservice/pkg/clientinfo"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrRateLimited - сообщение ответа RESOURCE_EXHAUSTED
+const ErrRateLimited = "too many requests, try again later"
+
+// Rule - ограничения одного метода gRPC, у каждого метода свои корзины
+type Rule struct {
+	PerIP   Limit // по адресу клиента
+	PerUser Limit // по имени пользователя из запроса, для методов входа
+}
+
+// Rules - правила по полному имени метода, Default - для методов без своего правила
+type Rules struct {
+	Default  Rule
+	ByMethod map[string]Rule
+}
+
+func (r Rules) For(method string) Rule {
+	if rule, ok := r.ByMethod[method]; ok {
+		return rule
+	}
+	return r.Default
+}
+
+// запросы входа: identifier - почта или имя, username - устаревшее поле
+type identifierRequest interface {
+	GetIdentifier() string
+}
+
+type usernameRequest interface {
+	GetUsername() string
+}
+
+// UnaryServerInterceptor ограничивает частоту вызовов по правилам. Если хранилище лимитов недоступно,
+// запрос пропускается: отказ лимитера не должен останавливать вход в систему.
+// Адрес клиента должен определить clientinfo.UnaryServerInterceptor раньше в цепочке
+func UnaryServerInterceptor(limiter Limiter, rules Rules, log *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ip := clientinfo.FromContext(ctx).IP
+		if allowed, retryAfter := check(ctx, limiter, rules, info.FullMethod, ip, requestUsername(req), log); !allowed {
+			return nil, rateLimited(retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// check забирает токены из корзин метода по адресу и по имени пользователя. Корзины общие
+// для gRPC и HTTP: ключ строится по имени метода gRPC
+func check(ctx context.Context, limiter Limiter, rules Rules, method, ip, username string, log *zap.SugaredLogger) (bool, time.Duration) {
+	rule := rules.For(method)
+
+	if rule.PerIP.Enabled() && ip != "" {
+		if allowed, retryAfter := allow(ctx, limiter, method+"|ip|"+ip, rule.PerIP, log); !allowed {
+			return false, retryAfter
+		}
+	}
+
+	if rule.PerUser.Enabled() && username != "" {
+		if allowed, retryAfter := allow(ctx, limiter, method+"|user|"+username, rule.PerUser, log); !allowed {
+			return false, retryAfter
+		}
+	}
+
+	return true, 0
+}
+
+func allow(ctx context.Context, limiter Limiter, key string, limit Limit, log *zap.SugaredLogger) (bool, time.Duration) {
+	allowed, retryAfter, err := limiter.Allow(ctx, key, limit)
+	if err != nil {
+		log.Errorf("rate limiter err: key = %s: %v", key, err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// rateLimited - ошибка с RetryInfo, через сколько клиент может повторить запрос
+func rateLimited(retryAfter time.Duration) error {
+	if retryAfter < time.Millisecond {
+		retryAfter = time.Millisecond
+	}
+
+	st, err := status.New(codes.ResourceExhausted, ErrRateLimited).WithDetails(
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, ErrRateLimited)
+	}
+	return st.Err()
+}
+
+// requestUsername достаёт имя пользователя из запроса входа, регистр не важен
+func requestUsername(req interface{}) string {
+	var username string
+	if r, ok := req.(identifierRequest); ok {
+		username = r.GetIdentifier()
+	}
+	if r, ok := req.(usernameRequest); ok && username == "" {
+		username = r.GetUsername()
+	}
+	return normalizeUsername(username)
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
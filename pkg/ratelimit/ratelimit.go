@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничение частоты запросов алгоритмом token bucket: корзина ёмкостью Burst
+// пополняется на Rate токенов в секунду, каждый запрос забирает один токен
+
+type Limit struct {
+	Rate  float64 // токенов в секунду
+	Burst int     // ёмкость корзины
+}
+
+// Enabled - нулевой Limit ограничение выключает
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Decode разбирает ограничение из строки вида "20/1m": 20 запросов в минуту, столько же подряд.
+// Пустая строка или "0" выключают ограничение. Нужен для envconfig
+func (l *Limit) Decode(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		*l = Limit{}
+		return nil
+	}
+
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("invalid rate limit %q: expected count/period", value)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid rate limit %q: count must be a positive integer", value)
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid rate limit %q: period must be a positive duration", value)
+	}
+
+	*l = Limit{
+		Rate:  float64(n) / d.Seconds(),
+		Burst: n,
+	}
+	return nil
+}
+
+type Limiter interface {
+	// Allow забирает токен из корзины key. Если токенов нет, возвращает false и время до появления следующего
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// take пересчитывает корзину на момент now и пробует забрать из неё токен
+func take(tokens float64, updated, now time.Time, limit Limit) (float64, bool, time.Duration) {
+	if elapsed := now.Sub(updated).Seconds(); elapsed > 0 {
+		tokens = min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	retryAfter := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, retryAfter
+}
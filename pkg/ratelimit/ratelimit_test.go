@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitDecode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "20/1m", want: Limit{Rate: 20.0 / 60, Burst: 20}},
+		{value: " 5/1s ", want: Limit{Rate: 5, Burst: 5}},
+		{value: "", want: Limit{}},
+		{value: "0", want: Limit{}},
+		{value: "20", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "-1/1m", wantErr: true},
+		{value: "20/0s", wantErr: true},
+		{value: "20/minute", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got Limit
+			err := got.Decode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("Decode(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 4} // токен каждые 500ms
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		tokens         float64
+		elapsed        time.Duration
+		wantTokens     float64
+		wantAllowed    bool
+		wantRetryAfter time.Duration
+	}{
+		{name: "full bucket", tokens: 4, wantTokens: 3, wantAllowed: true},
+		{name: "last token", tokens: 1, wantTokens: 0, wantAllowed: true},
+		{name: "empty bucket", tokens: 0, wantTokens: 0, wantRetryAfter: 500 * time.Millisecond},
+		{name: "half a token", tokens: 0.5, wantTokens: 0.5, wantRetryAfter: 250 * time.Millisecond},
+		{name: "refilled", tokens: 0, elapsed: 500 * time.Millisecond, wantTokens: 0, wantAllowed: true},
+		{name: "refill capped at burst", tokens: 0, elapsed: time.Hour, wantTokens: 3, wantAllowed: true},
+		{name: "clock went back", tokens: 0, elapsed: -time.Second, wantTokens: 0, wantRetryAfter: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, allowed, retryAfter := take(tt.tokens, now.Add(-tt.elapsed), now, limit)
+			if tokens != tt.wantTokens || allowed != tt.wantAllowed || retryAfter != tt.wantRetryAfter {
+				t.Fatalf("take() = %v, %v, %v, want %v, %v, %v",
+					tokens, allowed, retryAfter, tt.wantTokens, tt.wantAllowed, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+// exhaust забирает burst токенов и проверяет, что следующий запрос отклонён
+func exhaust(t *testing.T, limiter Limiter, key string, limit Limit) time.Duration {
+	t.Helper()
+	ctx := context.Background()
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := limiter.Allow(ctx, key, limit)
+		if err != nil || !allowed {
+			t.Fatalf("request %d: Allow() = %v, %v, want allowed", i+1, allowed, err)
+		}
+	}
+
+	allowed, retryAfter, err := limiter.Allow(ctx, key, limit)
+	if err != nil || allowed {
+		t.Fatalf("request over burst: Allow() = %v, %v, want denied", allowed, err)
+	}
+	return retryAfter
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	retryAfter := exhaust(t, limiter, "a", limit)
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("retryAfter = %v, want (0, 1s]", retryAfter)
+	}
+
+	// у каждого ключа своя корзина
+	if allowed, _, _ := limiter.Allow(context.Background(), "b", limit); !allowed {
+		t.Fatal("Allow() for another key denied")
+	}
+}
+
+func TestMemoryLimiterRefill(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	limit := Limit{Rate: 50, Burst: 1} // токен каждые 20ms
+
+	retryAfter := exhaust(t, limiter, "a", limit)
+	time.Sleep(retryAfter + 5*time.Millisecond)
+
+	if allowed, _, _ := limiter.Allow(context.Background(), "a", limit); !allowed {
+		t.Fatal("Allow() after refill denied")
+	}
+	if allowed, _, _ := limiter.Allow(context.Background(), "a", limit); allowed {
+		t.Fatal("Allow() allowed more than the refilled token")
+	}
+}
+
+func TestMemoryLimiterCleanup(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	limit := Limit{Rate: 1000, Burst: 1}
+
+	exhaust(t, limiter, "a", limit)
+	time.Sleep(5 * time.Millisecond)
+
+	// корзина "a" уже заполнилась и при очистке удаляется, а "b" только что опустела
+	limiter.Allow(context.Background(), "b", Limit{Rate: 1, Burst: 1})
+	limiter.cleanup(time.Now())
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Fatal("cleanup() kept a full bucket")
+	}
+	if _, ok := limiter.buckets["b"]; !ok {
+		t.Fatal("cleanup() removed a bucket that is not full")
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript пересчитывает корзину атомарно на стороне Redis. Время берётся у Redis,
+// чтобы расхождение часов между репликами сервиса не влияло на лимиты.
+// Возвращает {1, 0}, если токен забран, или {0, миллисекунды до следующего токена}
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, retry}
+`)
+
+type RedisConfig struct {
+	Addr     string
+	Password string // если пусто - без AUTH
+	DB       int
+	Prefix   string        // префикс ключей корзин
+	Timeout  time.Duration // на подключение и на один запрос
+	PoolSize int           // размер пула соединений
+}
+
+// RedisLimiter хранит корзины в Redis (или совместимом по протоколу хранилище), лимиты общие для всех реплик.
+// Скрипт вызывается через EVALSHA, полный текст отправляется, только если Redis его ещё не знает
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisLimiter(cfg RedisConfig) *RedisLimiter {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 10
+	}
+	return &RedisLimiter{
+		client: redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  cfg.Timeout,
+			ReadTimeout:  cfg.Timeout,
+			WriteTimeout: cfg.Timeout,
+			PoolSize:     cfg.PoolSize,
+			// без повторов: при недоступном Redis запрос пропускается сразу, а не после нескольких таймаутов
+			MaxRetries: -1,
+		}),
+		prefix: cfg.Prefix,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	reply, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key},
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		limit.Burst,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take token: %w", err)
+	}
+	if len(reply) != 2 {
+		return false, 0, fmt.Errorf("failed to take token: unexpected reply %v", reply)
+	}
+
+	return reply[0] == 1, time.Duration(reply[1]) * time.Millisecond, nil
+}
+
+// Close закрывает соединения с Redis
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
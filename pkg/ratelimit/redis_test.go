@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	limiter := NewRedisLimiter(RedisConfig{
+		Addr:    server.Addr(),
+		Prefix:  "ratelimit:",
+		Timeout: time.Second,
+	})
+	t.Cleanup(func() { _ = limiter.Close() })
+	return limiter, server
+}
+
+func TestRedisLimiter(t *testing.T) {
+	limiter, server := newTestRedisLimiter(t)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	retryAfter := exhaust(t, limiter, "a", limit)
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("retryAfter = %v, want (0, 1s]", retryAfter)
+	}
+	if allowed, _, _ := limiter.Allow(context.Background(), "b", limit); !allowed {
+		t.Fatal("Allow() for another key denied")
+	}
+
+	// корзина хранится под префиксом и живёт не дольше, чем нужно на заполнение
+	if !server.Exists("ratelimit:a") {
+		t.Fatal("bucket key not found")
+	}
+	if ttl := server.TTL("ratelimit:a"); ttl <= 0 || ttl > 5*time.Second {
+		t.Fatalf("bucket ttl = %v", ttl)
+	}
+}
+
+func TestRedisLimiterEvalSha(t *testing.T) {
+	limiter, server := newTestRedisLimiter(t)
+	limit := Limit{Rate: 1, Burst: 10}
+	ctx := context.Background()
+
+	// первый вызов загружает скрипт, дальше передаётся только его SHA
+	for i := 0; i < 3; i++ {
+		if _, _, err := limiter.Allow(ctx, "a", limit); err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+	}
+	exists, err := limiter.client.ScriptExists(ctx, tokenBucketScript.Hash()).Result()
+	if err != nil || len(exists) != 1 || !exists[0] {
+		t.Fatalf("SCRIPT EXISTS = %v, %v, want the script cached", exists, err)
+	}
+
+	// после SCRIPT FLUSH (перезапуск Redis) скрипт загружается заново
+	server.FlushAll()
+	if err := limiter.client.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatalf("SCRIPT FLUSH error = %v", err)
+	}
+	if allowed, _, err := limiter.Allow(ctx, "a", limit); err != nil || !allowed {
+		t.Fatalf("Allow() after script flush = %v, %v", allowed, err)
+	}
+}
+
+func TestRedisLimiterUnavailable(t *testing.T) {
+	limiter, server := newTestRedisLimiter(t)
+	server.Close()
+
+	if _, _, err := limiter.Allow(context.Background(), "a", Limit{Rate: 1, Burst: 1}); err == nil {
+		t.Fatal("Allow() with Redis down error = nil")
+	}
+}
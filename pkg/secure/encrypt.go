@@ -0,0 +1,72 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// macKeyLabel - из ключа шифрования выводится отдельный ключ HMAC, чтобы один ключ не служил двум алгоритмам
+const macKeyLabel = "hmac-sha256"
+
+// Cipher шифрует небольшие секреты для хранения в БД (например, секреты TOTP) с помощью AES-256-GCM
+type Cipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewCipher создаёт шифр из ключа в base64, ключ должен быть длиной 32 байта
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key encoding: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(macKeyLabel))
+
+	return &Cipher{aead: aead, macKey: mac.Sum(nil)}, nil
+}
+
+// Encrypt возвращает nonce, за которым следует шифртекст
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return c.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+}
+
+// MAC - HMAC-SHA256 от data в hex. Подходит для поиска секрета по значению (например, кода восстановления):
+// в БД хранится только MAC, а без ключа, который в БД не лежит, подобрать по нему секрет нельзя
+func (c *Cipher) MAC(data string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,27 @@
+package secure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// длина непрозрачного токена в байтах до кодирования
+const opaqueTokenSize = 32
+
+// NewOpaqueToken генерирует случайный непрозрачный токен (refresh токены, одноразовые ссылки и т.п.)
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken - SHA-256 токена в hex, в БД хранится только он. У токена высокая энтропия,
+// поэтому медленный хэш вроде bcrypt не нужен, а поиск по хэшу остаётся точным
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Тестовые векторы RFC 6238, приложение B (SHA1). В RFC коды 8-значные, у нас 6 цифр - их младшие разряды
+func TestCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		if got := Code(secret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code(T = %d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", Code(secret, step), 1, step, true},
+		{"previous step within skew", Code(secret, step-1), 1, step - 1, true},
+		{"next step within skew", Code(secret, step+1), 1, step + 1, true},
+		{"previous step without skew", Code(secret, step-1), 0, 0, false},
+		{"outside skew", Code(secret, step-2), 1, 0, false},
+		{"wrong length", Code(secret, step)[:5], 1, 0, false},
+		{"empty", "", 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(secret, tt.code, now, tt.skew)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate() = (%d, %v), want (%d, %v)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}